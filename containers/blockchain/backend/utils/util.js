@@ -2,10 +2,13 @@ import config from '../set-up/config';
 import invokeFunc from '../set-up/invoke';
 import queryFunc from '../set-up/query';
 const uuidv4 = require('uuid/v4');
+const crypto = require('crypto');
 const request = require('request');
 const amqp = require('amqplib/callback_api');
 var RedisClustr = require('redis-clustr');
-async function invokeChaincode(type, client, values) {
+//functions whose contracts are handed over with a pickup secret derived from a transient nonce
+const PICKUP_FUNCTIONS = ['makePurchase', 'makeCartPurchase', 'placeBid'];
+async function invokeChaincode(type, client, values, idempotencyKey) {
   values = typeof values !== "string" ? values : JSON.parse(values);
   if(!values.userId) {
     throw new Error('Missing UserId');
@@ -17,18 +20,39 @@ async function invokeChaincode(type, client, values) {
       values.args = [""];
     }
     var func = null;
+    var transient = values.transient;
+    var pickupNonce = null;
     if(type === "query") {
       func = queryFunc;
     } else {
       func = invokeFunc;
+      //a retried invoke with the same key returns the first result instead of applying the call twice
+      idempotencyKey = values.idempotencyKey || idempotencyKey;
+      if(idempotencyKey) {
+        transient = Object.assign({}, transient, {
+          idempotencyKey: idempotencyKey
+        });
+      }
+      //make the pickup nonce when the app sends none, it is returned so the app can derive the pickup secret
+      if(PICKUP_FUNCTIONS.indexOf(values.fcn) >= 0 && !(transient && transient.pickupNonce)) {
+        pickupNonce = crypto.randomBytes(32).toString('hex');
+        transient = Object.assign({}, transient, {
+          pickupNonce: pickupNonce
+        });
+      }
     }
-    return func(values.userId, client, config.chaincodeId, config.chaincodeVersion, values.fcn, values.args).then((result) => {
+    return func(values.userId, client, config.chaincodeId, config.chaincodeVersion, values.fcn, values.args, transient).then((result) => {
       if(type === "query") {
         return result;
       } else {
         return client.getTransactionDetails(result);
       }
     }).then((result) => {
+      if(pickupNonce) {
+        result = Object.assign({}, result, {
+          pickupNonce: pickupNonce
+        });
+      }
       return {
         message: "success",
         result: result
@@ -74,12 +98,12 @@ async function getBlocks(client, values) {
     throw err;
   });
 }
-async function execute(type, client, params) {
+async function execute(type, client, params, idempotencyKey) {
   try {
     switch(type) {
     case 'invoke':
     case 'query':
-      return invokeChaincode(type, client, params);
+      return invokeChaincode(type, client, params, idempotencyKey);
     case 'enroll':
       return enrollUser(client);
     case 'blocks':
@@ -155,7 +179,8 @@ export async function createConnection(client, clientNo) {
             ch.ack(msg);
           };
           console.log(clientNo + " processing request : " + JSON.stringify(input.params));
-          execute(input.type, client, input.params).then(function (value) {
+          //a redelivered message keeps its correlationId, which keys the invoke
+          execute(input.type, client, input.params, msg.properties.correlationId).then(function (value) {
             reply(ch, msg, JSON.stringify(value));
           }).catch(err => {
             console.error("Failed request on " + clientNo);
@@ -0,0 +1,257 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//id of the built in steps activity, converted at STEPS_TO_FITCOIN steps per fitcoin
+const ACTIVITY_STEPS = "steps"
+
+//composite key object type of activity types, keyed by activity type id
+const ACTIVITY_TYPE_INDEX = "activityType"
+
+//longest activity type id, in characters
+const MAX_ACTIVITY_TYPE_ID_LENGTH = 64
+
+// Users earn fitcoins for steps and for the activities an organizer (admin) registers, such as cycling distance,
+// active minutes or workshop attendance. Every activity type has its own conversion rule: each Units of the
+// activity earn Fitcoins fitcoins. Like steps, the app reports the user's running total of an activity, and the
+// units left over by a conversion count towards the next one. Steps keep their own fields on the user and feed
+// teams, challenges and the step history; the totals of other activities are kept in the user's Activities.
+
+// ActivityType registered on the ledger with its conversion rule
+type ActivityType struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Unit      string    `json:"unit"`
+	Units     int       `json:"units"`
+	Fitcoins  int       `json:"fitcoins"`
+	UpdatedAt string    `json:"updatedAt,omitempty"`
+	UpdatedBy *Identity `json:"updatedBy,omitempty"`
+	Version   int       `json:"schemaVersion"`
+}
+
+// ActivityTotal of a user, the units already converted to fitcoins and the running total
+type ActivityTotal struct {
+	Total             int `json:"total"`
+	UsedForConversion int `json:"usedForConversion"`
+}
+
+type registerActivityTypeRequest struct {
+	ActivityTypeId string `json:"activityTypeId" desc:"the id generation calls pass as activityType"`
+	Name           string `json:"name" desc:"the name shown for the activity"`
+	Unit           string `json:"unit" desc:"the unit of the reported totals, such as meters or minutes"`
+	Units          int    `json:"units" desc:"the units of the activity that earn fitcoins"`
+	Fitcoins       int    `json:"fitcoins" desc:"the fitcoins earned for every units of the activity"`
+}
+
+func (r *registerActivityTypeRequest) validate() error {
+	if r.ActivityTypeId == ACTIVITY_STEPS {
+		return argError(0, "activityTypeId", "must not be steps, which is built in")
+	}
+	if len(r.ActivityTypeId) > MAX_ACTIVITY_TYPE_ID_LENGTH || strings.IndexFunc(r.ActivityTypeId, isNotIdRune) >= 0 {
+		return argError(0, "activityTypeId", "must be at most "+strconv.Itoa(MAX_ACTIVITY_TYPE_ID_LENGTH)+" letters, digits, '-' or '_'")
+	}
+	if r.Units <= 0 {
+		return argError(3, "units", "must be positive")
+	}
+	if r.Fitcoins <= 0 {
+		return argError(4, "fitcoins", "must be positive")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "registerActivityType",
+		Description: "Register an activity type or change its conversion rule",
+		Request:     registerActivityTypeRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).registerActivityType,
+	})
+	registerFunction(Function{
+		Name:        "getActivityTypes",
+		Description: "Get the activity types with their conversion rules, steps included",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getActivityTypes,
+	})
+}
+
+// ============================================================================================================================
+// Register activity type - registering an existing id changes its rule, for conversions from then on
+// Inputs - activityTypeId, name, unit, units, fitcoins
+// ============================================================================================================================
+func (t *SimpleChaincode) registerActivityType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request registerActivityTypeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	admin, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var activityType ActivityType
+	activityType.Id = request.ActivityTypeId
+	activityType.Name = request.Name
+	activityType.Unit = request.Unit
+	activityType.Units = request.Units
+	activityType.Fitcoins = request.Fitcoins
+	activityType.UpdatedAt = txTime.Format(TIMESTAMP_FORMAT)
+	activityType.UpdatedBy = &admin
+
+	key, err := stub.CreateCompositeKey(ACTIVITY_TYPE_INDEX, []string{activityType.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	activityTypeAsBytes, err := writeRecord(stub, key, &activityType)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(activityTypeAsBytes)
+}
+
+// ============================================================================================================================
+// Get activity types
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) getActivityTypes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ACTIVITY_TYPE_INDEX, []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	//steps come first
+	activityTypes := []ActivityType{stepsActivityType()}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var activityType ActivityType
+		err = decodeStrict(aKeyValue.Value, &activityType)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not an activity type: "+err.Error()))
+		}
+		activityTypes = append(activityTypes, activityType)
+	}
+
+	activityTypesAsBytes, _ := json.Marshal(activityTypes)
+	return shim.Success(activityTypesAsBytes)
+}
+
+// generateActivityFitcoins converts the user's new units of a registered activity to fitcoins
+func generateActivityFitcoins(stub shim.ChaincodeStubInterface, user User, activityTypeId string, total int) pb.Response {
+	activityType, err := loadActivityType(stub, activityTypeId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//update user account
+	activity := user.Activities[activityType.Id]
+	var newUnits = total - activity.UsedForConversion
+	var newFitcoins = 0
+	if newUnits >= activityType.Units {
+		newFitcoins = newUnits / activityType.Units * activityType.Fitcoins
+		err = mintFitcoins(stub, &user.Member, newFitcoins)
+		if err != nil {
+			return errorResponse(err)
+		}
+		activity.UsedForConversion = total - newUnits%activityType.Units
+		activity.Total = total
+		if user.Activities == nil {
+			user.Activities = map[string]ActivityTotal{}
+		}
+		user.Activities[activityType.Id] = activity
+
+		//update users state
+		_, err = writeRecord(stub, user.Id, &user)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	//return updated user with GeneratedFitcoins
+	type ReturnUser struct {
+		User
+		GeneratedFitcoins int `json:"generatedFitcoins"`
+	}
+	var returnUser ReturnUser
+
+	returnUser.User = user
+	returnUser.GeneratedFitcoins = newFitcoins
+
+	returnUserBytes, _ := json.Marshal(returnUser)
+	return shim.Success(returnUserBytes)
+}
+
+// stepsActivityType describes the built in steps activity
+func stepsActivityType() ActivityType {
+	var activityType ActivityType
+	activityType.Id = ACTIVITY_STEPS
+	activityType.Name = "Steps"
+	activityType.Unit = ACTIVITY_STEPS
+	activityType.Units = STEPS_TO_FITCOIN
+	activityType.Fitcoins = 1
+	activityType.Version = SCHEMA_VERSION
+	return activityType
+}
+
+// loadActivityType reads a registered activity type
+func loadActivityType(stub shim.ChaincodeStubInterface, activityTypeId string) (ActivityType, error) {
+	var activityType ActivityType
+	key, err := stub.CreateCompositeKey(ACTIVITY_TYPE_INDEX, []string{activityTypeId})
+	if err != nil {
+		return activityType, err
+	}
+	activityTypeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return activityType, err
+	}
+	if activityTypeAsBytes == nil {
+		return activityType, newError(ERR_ACTIVITY_TYPE_NOT_FOUND, "Activity type "+activityTypeId+" not found").
+			withDetail("activityType", activityTypeId)
+	}
+	err = decodeStrict(activityTypeAsBytes, &activityType)
+	if err != nil {
+		return activityType, corruptRecordError(key, "is not an activity type: "+err.Error())
+	}
+	return activityType, nil
+}
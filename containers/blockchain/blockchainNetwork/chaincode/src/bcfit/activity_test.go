@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestActivitiesConvertByTheirOwnRule(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.asAdmin().mustInvoke(nil, "registerActivityType", "cycling", "Cycling", "meters", "1000", "2")
+
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "2500", "cycling")
+	e.checkBalance("u1", 4)
+	//units left over count towards the next conversion
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "2900", "cycling")
+	e.checkBalance("u1", 4)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "3100", "cycling")
+	e.checkBalance("u1", 6)
+	if user := e.user("u1"); user.Activities["cycling"].UsedForConversion != 3000 || user.TotalSteps != 0 {
+		t.Fatalf("user is %+v", user)
+	}
+
+	//a new rule applies to conversions from then on
+	e.asAdmin().mustInvoke(nil, "registerActivityType", "cycling", "Cycling", "meters", "500", "1")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "3600", "cycling")
+	e.checkBalance("u1", 7)
+
+	//steps keep their own totals
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "100", ACTIVITY_STEPS)
+	e.checkBalance("u1", 8)
+	if user := e.user("u1"); user.TotalSteps != 100 || user.Activities["cycling"].Total != 3600 {
+		t.Fatalf("user is %+v", user)
+	}
+}
+
+func TestActivityTypesAreRegisteredByAdmins(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "registerActivityType", "cycling", "Cycling", "meters", "1000", "2")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "registerActivityType", ACTIVITY_STEPS, "Steps", "steps", "10", "1")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "registerActivityType", "road cycling", "Cycling", "meters", "1000", "2")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "registerActivityType", "cycling", "Cycling", "meters", "0", "2")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "registerActivityType", "cycling", "Cycling", "meters", "1000", "0")
+	e.as("u1").mustFail(ERR_ACTIVITY_TYPE_NOT_FOUND, "generateFitcoins", "u1", "2500", "cycling")
+
+	e.asAdmin().mustInvoke(nil, "registerActivityType", "cycling", "Cycling", "meters", "1000", "2")
+	var activityTypes []ActivityType
+	e.as("u1").mustInvoke(&activityTypes, "getActivityTypes")
+	if len(activityTypes) != 2 || activityTypes[0].Id != ACTIVITY_STEPS || activityTypes[0].Units != STEPS_TO_FITCOIN {
+		t.Fatalf("activity types are %+v", activityTypes)
+	}
+	if cycling := activityTypes[1]; cycling.Id != "cycling" || cycling.Units != 1000 || cycling.Fitcoins != 2 || cycling.UpdatedBy == nil || cycling.UpdatedBy.Subject != TEST_ADMIN {
+		t.Fatalf("cycling is %+v", cycling)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//admin actions recorded in the audit log
+const ACTION_MINT = "mint"
+const ACTION_BURN = "burn"
+const ACTION_FREEZE = "freeze"
+const ACTION_UNFREEZE = "unfreeze"
+const ACTION_EXPIRE = "expire"
+const ACTION_ROLLOVER = "rollover"
+const ACTION_PAYOUT = "payout"
+
+//composite key object type of audit records, keyed by member id and transaction id
+const AUDIT_INDEX = "audit"
+
+type adminAmountRequest struct {
+	MemberId string `json:"memberId" desc:"the user's or seller's id"`
+	Amount   int    `json:"amount" desc:"the number of fitcoins"`
+	Reason   string `json:"reason" desc:"why the balance is corrected, kept in the audit log"`
+}
+
+func (r *adminAmountRequest) validate() error {
+	if r.Amount <= 0 {
+		return argError(1, "amount", "must be positive")
+	}
+	return nil
+}
+
+type freezeRequest struct {
+	MemberId string `json:"memberId" desc:"the user's or seller's id"`
+	Reason   string `json:"reason" desc:"why the member is frozen or unfrozen, kept in the audit log"`
+}
+
+type getAuditLogRequest struct {
+	MemberId string `json:"memberId,omitempty" desc:"only return the admin actions on this member"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "adminMint",
+		Description: "Credit fitcoins to a member to correct its balance",
+		Request:     adminAmountRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).adminMint,
+	})
+	registerFunction(Function{
+		Name:        "adminBurn",
+		Description: "Debit fitcoins from a member to correct its balance",
+		Request:     adminAmountRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).adminBurn,
+	})
+	registerFunction(Function{
+		Name:        "freezeMember",
+		Description: "Freeze a member, frozen members cannot act until unfrozen by an admin",
+		Request:     freezeRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).freezeMember,
+	})
+	registerFunction(Function{
+		Name:        "unfreezeMember",
+		Description: "Unfreeze a frozen member",
+		Request:     freezeRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).unfreezeMember,
+	})
+	registerFunction(Function{
+		Name:        "getAuditLog",
+		Description: "Get the audit records of admin actions, oldest first",
+		Request:     getAuditLogRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).getAuditLog,
+	})
+}
+
+// ============================================================================================================================
+// Admin mint - credit fitcoins to a member
+// Inputs - memberId, amount, reason
+// ============================================================================================================================
+func (t *SimpleChaincode) adminMint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return adjustBalance(stub, args, ACTION_MINT)
+}
+
+// ============================================================================================================================
+// Admin burn - debit fitcoins from a member
+// Inputs - memberId, amount, reason
+// ============================================================================================================================
+func (t *SimpleChaincode) adminBurn(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return adjustBalance(stub, args, ACTION_BURN)
+}
+
+// adjustBalance mints or burns fitcoins on a member's balance and audits the correction
+func adjustBalance(stub shim.ChaincodeStubInterface, args []string, action string) pb.Response {
+	var request adminAmountRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get member, frozen members can still be corrected
+	record, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if member.Status != STATUS_ACTIVE {
+		return errorResponse(memberStatusError(member))
+	}
+
+	//update balance and supply
+	if action == ACTION_MINT {
+		err = mintFitcoins(stub, member, request.Amount)
+	} else {
+		err = burnFitcoins(stub, member, request.Amount)
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	memberAsBytes, err := writeRecord(stub, member.Id, record)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = writeAuditRecord(stub, action, member.Id, request.Amount, request.Reason)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(memberAsBytes)
+}
+
+// ============================================================================================================================
+// Freeze member
+// Inputs - memberId, reason
+// ============================================================================================================================
+func (t *SimpleChaincode) freezeMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return setFrozen(stub, args, true)
+}
+
+// ============================================================================================================================
+// Unfreeze member
+// Inputs - memberId, reason
+// ============================================================================================================================
+func (t *SimpleChaincode) unfreezeMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return setFrozen(stub, args, false)
+}
+
+// setFrozen freezes or unfreezes a member that is not closed and audits the change
+func setFrozen(stub shim.ChaincodeStubInterface, args []string, frozen bool) pb.Response {
+	var request freezeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get member
+	record, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if member.Status == STATUS_CLOSED {
+		return errorResponse(memberStatusError(member))
+	}
+	if member.Frozen == frozen {
+		return errorResponse(newError(ERR_INVALID_STATE, "Member "+member.Id+" is already in the requested state").
+			withDetail("memberId", member.Id).
+			withDetail("frozen", member.Frozen))
+	}
+
+	//update member state
+	member.Frozen = frozen
+	memberAsBytes, err := writeRecord(stub, member.Id, record)
+	if err != nil {
+		return errorResponse(err)
+	}
+	action := ACTION_UNFREEZE
+	if frozen {
+		action = ACTION_FREEZE
+	}
+	err = writeAuditRecord(stub, action, member.Id, 0, request.Reason)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(memberAsBytes)
+}
+
+// ============================================================================================================================
+// Write audit record - record an admin action with the acting identity, once per transaction
+// ============================================================================================================================
+func writeAuditRecord(stub shim.ChaincodeStubInterface, action string, memberId string, amount int, reason string) error {
+	actor, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	key, err := stub.CreateCompositeKey(AUDIT_INDEX, []string{memberId, stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingAsBytes != nil {
+		return newError(ERR_ALREADY_EXISTS, "Audit record "+stub.GetTxID()+" already exists").withDetail("txId", stub.GetTxID())
+	}
+
+	var auditRecord AuditRecord
+	auditRecord.Id = stub.GetTxID()
+	auditRecord.Action = action
+	auditRecord.MemberId = memberId
+	auditRecord.Amount = amount
+	auditRecord.Reason = reason
+	auditRecord.Actor = actor
+	auditRecord.Timestamp = txTime.Format(TIMESTAMP_FORMAT)
+
+	_, err = writeRecord(stub, key, &auditRecord)
+	return err
+}
+
+// ============================================================================================================================
+// Get audit log - all admin actions, or those on one member
+// Inputs - (none) or memberId
+// ============================================================================================================================
+func (t *SimpleChaincode) getAuditLog(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getAuditLogRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var keys []string
+	if request.MemberId != "" {
+		keys = append(keys, request.MemberId)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(AUDIT_INDEX, keys)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	auditRecords := []AuditRecord{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var auditRecord AuditRecord
+		err = decodeStrict(aKeyValue.Value, &auditRecord)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not an audit record: "+err.Error()))
+		}
+		auditRecords = append(auditRecords, auditRecord)
+	}
+
+	//keys are ordered by member and transaction id, return the log in time order
+	sort.Stable(auditRecordsByTime(auditRecords))
+
+	auditRecordsAsBytes, _ := json.Marshal(auditRecords)
+	return shim.Success(auditRecordsAsBytes)
+}
+
+// auditRecordsByTime sorts audit records oldest first
+type auditRecordsByTime []AuditRecord
+
+func (a auditRecordsByTime) Len() int           { return len(a) }
+func (a auditRecordsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a auditRecordsByTime) Less(i, j int) bool { return a[i].Timestamp < a[j].Timestamp }
@@ -0,0 +1,93 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAdminCorrectionsAreAudited(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 500)
+	e.createUser("u2", 0)
+
+	e.asAdmin().mustInvoke(nil, "adminMint", "u1", "10", "missed sync")
+	e.asAdmin().mustInvoke(nil, "adminBurn", "u1", "3", "double sync")
+	e.asAdmin().mustFail(ERR_INSUFFICIENT_FUNDS, "adminBurn", "u2", "1", "no balance")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "adminMint", "u1", "0", "nothing")
+	e.asAdmin().mustFail(ERR_MEMBER_NOT_FOUND, "adminMint", "u9", "1", "nobody")
+	e.checkBalance("u1", 12)
+
+	var auditLog []AuditRecord
+	e.asAdmin().mustInvoke(&auditLog, "getAuditLog", "u1")
+	if len(auditLog) != 2 || auditLog[0].Action != ACTION_MINT || auditLog[1].Action != ACTION_BURN || auditLog[1].Amount != 3 {
+		t.Fatalf("audit log is %+v", auditLog)
+	}
+	if auditLog[0].Actor.MspId != TEST_USER_MSP || auditLog[0].Actor.Subject != TEST_ADMIN || auditLog[0].Reason != "missed sync" {
+		t.Fatalf("audit record is %+v", auditLog[0])
+	}
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "getAuditLog", "u1")
+}
+
+func TestFrozenMembersCannotAct(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 500)
+	e.createSeller("s1", "p1", 5, 1)
+
+	e.asAdmin().mustInvoke(nil, "freezeMember", "u1", "chargeback")
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "freezeMember", "u1", "again")
+	e.as("u1").mustFail(ERR_MEMBER_FROZEN, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("u1").mustFail(ERR_MEMBER_FROZEN, "deactivateMember", "u1")
+
+	//frozen members can still be corrected
+	e.asAdmin().mustInvoke(nil, "adminBurn", "u1", "2", "chargeback")
+	e.asAdmin().mustInvoke(nil, "unfreezeMember", "u1", "resolved")
+	e.as("u1").mustInvoke(nil, "makePurchase", "u1", "s1", "p1", "1")
+	e.checkBalance("u1", 3)
+
+	var auditLog []AuditRecord
+	e.asAdmin().mustInvoke(&auditLog, "getAuditLog")
+	if len(auditLog) != 3 || auditLog[0].Action != ACTION_FREEZE || auditLog[2].Action != ACTION_UNFREEZE {
+		t.Fatalf("audit log is %+v", auditLog)
+	}
+}
+
+func TestAdminsAreSetOnInstantiateAndKeptOnUpgrade(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	//an upgrade without args keeps the admins
+	e.asAdmin()
+	if response := e.run(true, "init"); response.Status != shim.OK {
+		t.Fatalf("upgrade failed: %s", response.Message)
+	}
+	e.asAdmin().mustInvoke(nil, "adminMint", "u1", "1", "bonus")
+
+	//a whole MSP can be admin
+	e.asAdmin()
+	if response := e.run(true, "init", TEST_SELLER_MSP); response.Status != shim.OK {
+		t.Fatalf("upgrade failed: %s", response.Message)
+	}
+	e.asAdmin().mustFail(ERR_UNAUTHORIZED, "adminMint", "u1", "1", "bonus")
+	e.as("s1").mustInvoke(nil, "adminMint", "u1", "1", "bonus")
+	e.checkBalance("u1", 2)
+}
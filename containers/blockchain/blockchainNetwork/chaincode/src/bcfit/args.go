@@ -0,0 +1,206 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Functions take their arguments either positionally, in the order of the request struct's fields,
+// or as a single JSON object argument whose keys are the fields' json names:
+//
+//	makePurchase  ["userId", "sellerId", "productId", "2"]
+//	makePurchase  ["{\"userId\":\"...\",\"sellerId\":\"...\",\"productId\":\"...\",\"quantity\":2}"]
+//
+// Request struct fields are strings, ints or any JSON decodable type. A field tagged omitempty is
+// optional, the desc tag documents the field in the function metadata. Requests implementing
+// validator are checked once decoded.
+
+// validator is implemented by requests with constraints beyond their field types
+type validator interface {
+	validate() error
+}
+
+// emptyRequest is the request of functions without arguments
+type emptyRequest struct{}
+
+// isSha256Hex tells whether value is a hex encoded sha256 hash
+func isSha256Hex(value string) bool {
+	hash, err := hex.DecodeString(value)
+	return err == nil && len(hash) == sha256.Size
+}
+
+// ============================================================================================================================
+// requestArgs - derive the argument schema of a function from its request struct
+// ============================================================================================================================
+func requestArgs(request interface{}) []Arg {
+	args := []Arg{}
+	requestType := reflect.TypeOf(request)
+	optionalSeen := false
+	for i := 0; i < requestType.NumField(); i++ {
+		field := requestType.Field(i)
+		name, optional := argName(field)
+		if optionalSeen && !optional {
+			panic("request " + requestType.Name() + " has required field " + name + " after an optional field")
+		}
+		optionalSeen = optionalSeen || optional
+		args = append(args, Arg{
+			Name:        name,
+			Type:        argType(field.Type),
+			Description: field.Tag.Get("desc"),
+			Optional:    optional,
+		})
+	}
+	return args
+}
+
+// argName reads the argument name and whether it is optional from a field's json tag
+func argName(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	optional := len(tag) > 1 && tag[1] == "omitempty"
+	return tag[0], optional
+}
+
+// argType maps a request field type to the argument type shown in the function metadata
+func argType(fieldType reflect.Type) string {
+	if fieldType.Kind() == reflect.String {
+		return ARG_STRING
+	} else if fieldType.Kind() == reflect.Int {
+		return ARG_INT
+	}
+	return ARG_JSON
+}
+
+// ============================================================================================================================
+// decodeRequest - decode positional or JSON object args into the request struct pointed to by request
+// ============================================================================================================================
+func decodeRequest(args []string, request interface{}) error {
+	requestValue := reflect.ValueOf(request).Elem()
+	specs := requestArgs(requestValue.Interface())
+
+	var err error
+
+	if isJSONObjectArg(args) && !(len(specs) == 1 && specs[0].Type == ARG_JSON) {
+		err = decodeNamedArgs(args[0], specs, requestValue)
+	} else {
+		err = decodePositionalArgs(args, specs, requestValue)
+	}
+	if err != nil {
+		return err
+	}
+
+	if v, ok := request.(validator); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// isJSONObjectArg tells whether args is a single JSON object argument
+func isJSONObjectArg(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+// decodePositionalArgs sets the request fields from args in field order
+func decodePositionalArgs(args []string, specs []Arg, requestValue reflect.Value) error {
+	//the app sends [""] for calls without args, so trailing empty args of optional or absent fields are dropped
+	for len(args) > 0 && args[len(args)-1] == "" && (len(args) > len(specs) || specs[len(args)-1].Optional) {
+		args = args[:len(args)-1]
+	}
+	if len(args) > len(specs) {
+		return argCountError(len(args))
+	}
+	for i, spec := range specs {
+		if i >= len(args) {
+			if !spec.Optional {
+				return argCountError(len(args))
+			}
+			continue
+		}
+		field := requestValue.Field(i)
+		if spec.Type == ARG_STRING {
+			field.SetString(args[i])
+		} else if spec.Type == ARG_INT {
+			number, err := strconv.Atoi(args[i])
+			if err != nil {
+				return argError(i, spec.Name, "must be a numeric string")
+			}
+			field.SetInt(int64(number))
+		} else if err := decodeStrict([]byte(args[i]), field.Addr().Interface()); err != nil {
+			return argError(i, spec.Name, "must be valid JSON: "+err.Error())
+		}
+	}
+	return checkRequired(specs, requestValue)
+}
+
+// decodeNamedArgs sets the request fields from a JSON object, rejecting unknown and mistyped fields
+func decodeNamedArgs(arg string, specs []Arg, requestValue reflect.Value) error {
+	var named map[string]json.RawMessage
+	if err := decodeStrict([]byte(arg), &named); err != nil {
+		return argError(0, "request", "must be a JSON object: "+err.Error())
+	}
+
+	//reject fields the function does not take, they are usually misspelled names
+	known := map[string]bool{}
+	for _, spec := range specs {
+		known[spec.Name] = true
+	}
+	var unknown []string
+	for name := range named {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return newError(ERR_INVALID_ARGUMENT, "unknown argument "+unknown[0]).withDetail("argName", unknown[0])
+	}
+
+	for i, spec := range specs {
+		raw, ok := named[spec.Name]
+		if !ok || bytes.Equal(raw, []byte("null")) {
+			if !spec.Optional {
+				return argError(i, spec.Name, "is required")
+			}
+			continue
+		}
+		field := requestValue.Field(i)
+		if err := decodeStrict(raw, field.Addr().Interface()); err != nil {
+			return argError(i, spec.Name, "must be of type "+spec.Type)
+		}
+	}
+	return checkRequired(specs, requestValue)
+}
+
+// checkRequired rejects empty required string arguments
+func checkRequired(specs []Arg, requestValue reflect.Value) error {
+	for i, spec := range specs {
+		if spec.Type == ARG_STRING && !spec.Optional && requestValue.Field(i).String() == "" {
+			return argError(i, spec.Name, "must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+type testArgsItem struct {
+	SellerId  string `json:"sellerId"`
+	ProductId string `json:"productId"`
+	Quantity  int    `json:"quantity"`
+}
+
+type testArgsRequest struct {
+	Id     string         `json:"id"`
+	Count  int            `json:"count"`
+	Items  []testArgsItem `json:"items"`
+	Note   string         `json:"note,omitempty"`
+	Amount int            `json:"amount,omitempty"`
+}
+
+func TestDecodePositionalArgs(t *testing.T) {
+	var request testArgsRequest
+	err := decodeRequest([]string{"a", "2", `[{"sellerId":"s1","productId":"p1","quantity":1}]`, "hello"}, &request)
+	if err != nil || request.Id != "a" || request.Count != 2 || len(request.Items) != 1 || request.Note != "hello" || request.Amount != 0 {
+		t.Fatalf("decoded %+v, %v", request, err)
+	}
+	//trailing empty args of optional fields are left unset
+	request = testArgsRequest{}
+	err = decodeRequest([]string{"a", "2", "[]", "", ""}, &request)
+	if err != nil || request.Id != "a" || request.Note != "" || request.Amount != 0 {
+		t.Fatalf("decoded %+v, %v", request, err)
+	}
+
+	for _, args := range [][]string{
+		{"a", "2"},
+		{"a", "2", "[]", "", "1", "extra"},
+	} {
+		err = decodeRequest(args, &testArgsRequest{})
+		if chaincodeError, ok := err.(*ChaincodeError); !ok || chaincodeError.Code != ERR_INVALID_ARGUMENT_COUNT {
+			t.Fatalf("decoding %v returned %v", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"", "2", "[]"},
+		{"a", "two", "[]"},
+		{"a", "2", `[{"sellerId":"s1","productId":"p1","quantity":1,"price":3}]`},
+	} {
+		err = decodeRequest(args, &testArgsRequest{})
+		if chaincodeError, ok := err.(*ChaincodeError); !ok || chaincodeError.Code != ERR_INVALID_ARGUMENT {
+			t.Fatalf("decoding %v returned %v", args, err)
+		}
+	}
+}
+
+func TestDecodeNamedArgs(t *testing.T) {
+	var request testArgsRequest
+	err := decodeRequest([]string{`{"id":"a","count":2,"items":[],"amount":5,"note":null}`}, &request)
+	if err != nil || request.Id != "a" || request.Count != 2 || request.Amount != 5 || request.Note != "" {
+		t.Fatalf("decoded %+v, %v", request, err)
+	}
+
+	for args, argName := range map[string]string{
+		`{"id":"a","count":2}`:                      "items",
+		`{"id":"a","count":"2","items":[]}`:         "count",
+		`{"id":"a","count":2,"items":[],"cuont":1}`: "cuont",
+		`{"id":"","count":2,"items":[]}`:            "id",
+	} {
+		err = decodeRequest([]string{args}, &testArgsRequest{})
+		chaincodeError, ok := err.(*ChaincodeError)
+		if !ok || chaincodeError.Code != ERR_INVALID_ARGUMENT || chaincodeError.Details["argName"] != argName {
+			t.Fatalf("decoding %s returned %v", args, err)
+		}
+	}
+}
+
+func TestRequiredArgsAfterOptionalArgsAreRefused(t *testing.T) {
+	type badRequest struct {
+		Note string `json:"note,omitempty"`
+		Id   string `json:"id"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("a required argument after an optional one was accepted")
+		}
+	}()
+	requestArgs(badRequest{})
+}
+
+func TestFunctionsTakeNamedArgs(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createSeller("s1", "p1", 5, 2)
+
+	var contract Contract
+	e.as("u1").mustInvoke(&contract, "makePurchase", `{"userId":"u1","sellerId":"s1","productId":"p1","quantity":3}`)
+	if contract.Quantity != 3 || contract.Cost != 6 {
+		t.Fatalf("contract is %+v", contract)
+	}
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "makePurchase", `{"userId":"u1","sellerId":"s1","productId":"p1","qty":3}`)
+}
+
+func TestCallsWithoutArgsTakeTheAppsEmptyArg(t *testing.T) {
+	e := newTestEnv(t)
+	e.createSeller("s1", "p1", 5, 3)
+
+	//the app sends [""] when a call has no args
+	var products []struct {
+		SellerId string `json:"sellerId"`
+	}
+	e.as("s1").mustInvoke(&products, "getProductsForSale", "")
+	if len(products) != 1 || products[0].SellerId != "s1" {
+		t.Fatalf("products are %+v", products)
+	}
+	e.as("s1").mustInvoke(nil, "getAllContracts", "")
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT_COUNT, "getAllContracts", "", "extra")
+}
@@ -0,0 +1,554 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//auction state
+const AUCTION_OPEN = "open"
+const AUCTION_SETTLED = "settled"
+const AUCTION_CANCELLED = "cancelled"
+
+//composite key object type of auctions, keyed by auction id
+const AUCTION_INDEX = "auction"
+
+// An auction sells a lot of a seller's product to the highest bidder. The lot is taken from the seller's
+// inventory when the auction is created. A bid moves the bid amount from the user's balance into escrow
+// on the auction and refunds the previous highest bidder. Once the auction has ended, settleAuction pays
+// the escrowed bid to the seller and creates a contract for the winner, or puts the lot back into the
+// inventory when nobody bid. A bid made with a pickup nonce stores the hash of the winner's pickup secret,
+// see pickup.go: the contract is then ready and the seller completes it at hand-over with the secret.
+// Without a pickup secret the contract is completed at settlement.
+
+// Auction of a lot of a seller's product
+type Auction struct {
+	Id              string      `json:"id"`
+	SellerId        string      `json:"sellerId"`
+	ProductId       string      `json:"productId"`
+	ProductName     string      `json:"productName"`
+	Quantity        int         `json:"quantity"`
+	ReservePrice    int         `json:"reservePrice"`
+	StartsAt        string      `json:"startsAt"`
+	EndsAt          string      `json:"endsAt"`
+	HighestBid      int         `json:"highestBid"`
+	HighestBidderId string      `json:"highestBidderId"`
+	EscrowBatches   []CoinSlice `json:"escrowBatches,omitempty"`
+	PickupHash      string      `json:"pickupHash,omitempty"`
+	State           string      `json:"state"`
+	ContractId      string      `json:"contractId,omitempty"`
+	Version         int         `json:"schemaVersion"`
+}
+
+type createAuctionRequest struct {
+	SellerId     string `json:"sellerId" desc:"the seller's id"`
+	ProductId    string `json:"productId" desc:"the id of the product with the seller"`
+	Quantity     int    `json:"quantity" desc:"the quantity sold as one lot"`
+	ReservePrice int    `json:"reservePrice" desc:"the lowest bid accepted, in fitcoins"`
+	StartsAt     string `json:"startsAt" desc:"RFC 3339 time from which bids are accepted"`
+	EndsAt       string `json:"endsAt" desc:"RFC 3339 time from which bids are refused and the auction can be settled"`
+}
+
+func (r *createAuctionRequest) validate() error {
+	if r.Quantity <= 0 {
+		return argError(2, "quantity", "must be positive")
+	}
+	if r.ReservePrice < 0 {
+		return argError(3, "reservePrice", "must not be negative")
+	}
+	startsAt, err := time.Parse(time.RFC3339, r.StartsAt)
+	if err != nil {
+		return argError(4, "startsAt", "must be an RFC 3339 time")
+	}
+	endsAt, err := time.Parse(time.RFC3339, r.EndsAt)
+	if err != nil {
+		return argError(5, "endsAt", "must be an RFC 3339 time")
+	}
+	if !endsAt.After(startsAt) {
+		return argError(5, "endsAt", "must be after startsAt")
+	}
+	return nil
+}
+
+type placeBidRequest struct {
+	UserId    string `json:"userId" desc:"the bidding user's id"`
+	AuctionId string `json:"auctionId" desc:"the auction id returned by createAuction"`
+	Amount    int    `json:"amount" desc:"the bid in fitcoins, held in escrow until outbid or settled"`
+}
+
+type settleAuctionRequest struct {
+	AuctionId string `json:"auctionId" desc:"the auction id returned by createAuction"`
+}
+
+type getAuctionsRequest struct {
+	State string `json:"state,omitempty" desc:"only return auctions in this state: open, settled or cancelled"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createAuction",
+		Description: "Auction a lot of a seller's product, taking it from the inventory",
+		Request:     createAuctionRequest{},
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).createAuction,
+	})
+	registerFunction(Function{
+		Name:        "placeBid",
+		Description: "Bid on an open auction, escrowing the bid and refunding the previous highest bidder",
+		Request:     placeBidRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).placeBid,
+	})
+	registerFunction(Function{
+		Name:        "settleAuction",
+		Description: "Settle an ended auction, creating a completed contract for the highest bidder",
+		Request:     settleAuctionRequest{},
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).settleAuction,
+	})
+	registerFunction(Function{
+		Name:        "getAuctions",
+		Description: "Get all auctions, or the auctions in a state",
+		Request:     getAuctionsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getAuctions,
+	})
+}
+
+// ============================================================================================================================
+// Create auction
+// Inputs - sellerId, productId, quantity, reservePrice, startsAt, endsAt
+// ============================================================================================================================
+func (t *SimpleChaincode) createAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request createAuctionRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get seller
+	seller, err := loadSeller(stub, request.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(seller.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//take the lot from the seller's inventory
+	productIndex := -1
+	for h := 0; h < len(seller.Products); h++ {
+		if seller.Products[h].Id == request.ProductId {
+			productIndex = h
+			break
+		}
+	}
+	if productIndex < 0 {
+		return errorResponse(productNotFoundError(seller.Id, request.ProductId))
+	}
+	if seller.Products[productIndex].Count < request.Quantity {
+		return errorResponse(newError(ERR_PRODUCT_UNAVAILABLE, "Not enough of the product in stock").
+			withDetail("productId", request.ProductId).
+			withDetail("count", seller.Products[productIndex].Count))
+	}
+	seller.Products[productIndex].Count = seller.Products[productIndex].Count - request.Quantity
+
+	//create auction, identified by the transaction id
+	var auction Auction
+	auction.Id = stub.GetTxID()
+	auction.SellerId = seller.Id
+	auction.ProductId = request.ProductId
+	auction.ProductName = seller.Products[productIndex].Name
+	auction.Quantity = request.Quantity
+	auction.ReservePrice = request.ReservePrice
+	startsAt, _ := time.Parse(time.RFC3339, request.StartsAt)
+	endsAt, _ := time.Parse(time.RFC3339, request.EndsAt)
+	auction.StartsAt = startsAt.UTC().Format(TIMESTAMP_FORMAT)
+	auction.EndsAt = endsAt.UTC().Format(TIMESTAMP_FORMAT)
+	auction.State = AUCTION_OPEN
+
+	_, err = writeRecord(stub, seller.Id, &seller)
+	if err != nil {
+		return errorResponse(err)
+	}
+	auctionAsBytes, err := writeAuction(stub, &auction)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(auctionAsBytes)
+}
+
+// ============================================================================================================================
+// Place bid
+// Inputs - userId, auctionId, amount
+// ============================================================================================================================
+func (t *SimpleChaincode) placeBid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request placeBidRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	auction, err := loadAuction(stub, request.AuctionId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//bids are accepted from the start until the end of an open auction
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now := txTime.Format(TIMESTAMP_FORMAT)
+	if auction.State != AUCTION_OPEN || now < auction.StartsAt || now >= auction.EndsAt {
+		return errorResponse(newError(ERR_AUCTION_CLOSED, "Auction is not accepting bids").
+			withDetail("auctionId", auction.Id).
+			withDetail("startsAt", auction.StartsAt).
+			withDetail("endsAt", auction.EndsAt))
+	}
+	if request.Amount < auction.ReservePrice || request.Amount <= auction.HighestBid {
+		return errorResponse(newError(ERR_BID_TOO_LOW, "Bid must reach the reserve price and beat the highest bid").
+			withDetail("auctionId", auction.Id).
+			withDetail("reservePrice", auction.ReservePrice).
+			withDetail("highestBid", auction.HighestBid))
+	}
+
+	//get bidder
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if auction.HighestBidderId == user.Id {
+		//the highest bidder raising the bid only escrows the difference
+		slices, err := spendCoins(stub, &user.Member, request.Amount-auction.HighestBid)
+		if err != nil {
+			return errorResponse(err)
+		}
+		auction.EscrowBatches = append(auction.EscrowBatches, slices...)
+	} else {
+		//refund the previous highest bidder, with the coins it escrowed
+		if auction.HighestBidderId != "" {
+			outbid, err := loadUser(stub, auction.HighestBidderId)
+			if err != nil {
+				return errorResponse(err)
+			}
+			err = restoreCoins(stub, &outbid.Member, auction.HighestBid, auction.EscrowBatches)
+			if err != nil {
+				return errorResponse(err)
+			}
+			_, err = writeRecord(stub, outbid.Id, &outbid)
+			if err != nil {
+				return errorResponse(err)
+			}
+		}
+
+		//escrow the bid
+		slices, err := spendCoins(stub, &user.Member, request.Amount)
+		if err != nil {
+			return errorResponse(err)
+		}
+		auction.EscrowBatches = slices
+	}
+	auction.HighestBid = request.Amount
+	auction.HighestBidderId = user.Id
+
+	//the winning bid's pickup secret is presented at hand-over
+	auction.PickupHash, err = newPickupHash(stub, "")
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	_, err = writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+	auctionAsBytes, err := writeAuction(stub, &auction)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(auctionAsBytes)
+}
+
+// ============================================================================================================================
+// Settle auction
+// Inputs - auctionId
+// ============================================================================================================================
+func (t *SimpleChaincode) settleAuction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request settleAuctionRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	auction, err := loadAuction(stub, request.AuctionId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if auction.State != AUCTION_OPEN {
+		return errorResponse(newError(ERR_INVALID_STATE, "Auction already settled or cancelled").
+			withDetail("auctionId", auction.Id).
+			withDetail("state", auction.State))
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if txTime.Format(TIMESTAMP_FORMAT) < auction.EndsAt {
+		return errorResponse(newError(ERR_INVALID_STATE, "Auction has not ended").
+			withDetail("auctionId", auction.Id).
+			withDetail("endsAt", auction.EndsAt))
+	}
+
+	seller, err := loadSeller(stub, auction.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if auction.HighestBidderId == "" {
+		//nobody bid, put the lot back into the inventory
+		for h := 0; h < len(seller.Products); h++ {
+			if seller.Products[h].Id == auction.ProductId {
+				seller.Products[h].Count = seller.Products[h].Count + auction.Quantity
+				break
+			}
+		}
+		auction.State = AUCTION_SETTLED
+	} else if checkActive(seller.Member) != nil {
+		//a seller that can no longer sell does not get paid, the bid is refunded
+		winner, err := loadUser(stub, auction.HighestBidderId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = restoreCoins(stub, &winner.Member, auction.HighestBid, auction.EscrowBatches)
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = writeRecord(stub, winner.Id, &winner)
+		if err != nil {
+			return errorResponse(err)
+		}
+		auction.EscrowBatches = nil
+		auction.State = AUCTION_CANCELLED
+	} else {
+		//pay the seller and record the sale to the winner
+		winner, err := loadUser(stub, auction.HighestBidderId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		var contract Contract
+		contract.Id, err = newContractId(stub)
+		if err != nil {
+			return errorResponse(err)
+		}
+		contract.Type = KIND_CONTRACT
+		contract.SellerId = seller.Id
+		contract.UserId = winner.Id
+		contract.ProductId = auction.ProductId
+		contract.ProductName = auction.ProductName
+		contract.Quantity = auction.Quantity
+		contract.Cost = auction.HighestBid
+		contract.AuctionId = auction.Id
+		contract.PickupHash = auction.PickupHash
+		state := STATE_COMPLETE
+		if contract.PickupHash != "" {
+			state = STATE_READY
+		}
+		err = setContractState(stub, &contract, state, "")
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		seller.FitcoinsBalance = seller.FitcoinsBalance + auction.HighestBid
+		auction.EscrowBatches = nil
+		winner.ContractIds = append(winner.ContractIds, contract.Id)
+		_, err = writeRecord(stub, contract.Id, &contract)
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = writeRecord(stub, winner.Id, &winner)
+		if err != nil {
+			return errorResponse(err)
+		}
+		auction.ContractId = contract.Id
+		auction.State = AUCTION_SETTLED
+	}
+
+	_, err = writeRecord(stub, seller.Id, &seller)
+	if err != nil {
+		return errorResponse(err)
+	}
+	auctionAsBytes, err := writeAuction(stub, &auction)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(auctionAsBytes)
+}
+
+// ============================================================================================================================
+// Transact Auction Contract - complete the contract of a settled auction at hand-over, called from transactPurchase.
+// The lot was taken from the inventory and paid for when the auction was settled.
+// ============================================================================================================================
+func transactAuctionContract(stub shim.ChaincodeStubInterface, contract Contract, memberId string, newState string, pickupSecret string) pb.Response {
+	if newState != STATE_COMPLETE {
+		return errorResponse(argError(2, "newState", "must be complete for an auction contract"))
+	}
+
+	//ensure the calling member is active
+	_, caller, err := loadMember(stub, memberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(*caller)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkTransition(contract, memberId, newState)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//the seller proves the hand-over with the winner's pickup secret
+	err = checkPickupSecret(contract, pickupSecret)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	contract.RedeemedAt = txTime.Format(TIMESTAMP_FORMAT)
+
+	err = setContractState(stub, &contract, newState, memberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	contractAsBytes, err := writeRecord(stub, contract.Id, &contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(contractAsBytes)
+}
+
+// ============================================================================================================================
+// Get auctions
+// Inputs - (none) or state
+// ============================================================================================================================
+func (t *SimpleChaincode) getAuctions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getAuctionsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	auctions, err := readAuctions(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	filtered := []Auction{}
+	for _, auction := range auctions {
+		if request.State == "" || auction.State == request.State {
+			filtered = append(filtered, auction)
+		}
+	}
+
+	auctionsAsBytes, _ := json.Marshal(filtered)
+	return shim.Success(auctionsAsBytes)
+}
+
+// auctionEscrow sums the bids held in escrow by open auctions
+func auctionEscrow(stub shim.ChaincodeStubInterface) (int, error) {
+	auctions, err := readAuctions(stub)
+	if err != nil {
+		return 0, err
+	}
+	escrow := 0
+	for _, auction := range auctions {
+		if auction.State == AUCTION_OPEN {
+			escrow = escrow + auction.HighestBid
+		}
+	}
+	return escrow, nil
+}
+
+// readAuctions reads every auction
+func readAuctions(stub shim.ChaincodeStubInterface) ([]Auction, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(AUCTION_INDEX, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var auctions []Auction
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var auction Auction
+		err = decodeStrict(aKeyValue.Value, &auction)
+		if err != nil {
+			return nil, corruptRecordError(aKeyValue.Key, "is not an auction: "+err.Error())
+		}
+		auctions = append(auctions, auction)
+	}
+	return auctions, nil
+}
+
+// loadAuction reads an auction by id
+func loadAuction(stub shim.ChaincodeStubInterface, id string) (Auction, error) {
+	var auction Auction
+	key, err := stub.CreateCompositeKey(AUCTION_INDEX, []string{id})
+	if err != nil {
+		return auction, err
+	}
+	auctionAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return auction, err
+	}
+	if auctionAsBytes == nil {
+		return auction, newError(ERR_AUCTION_NOT_FOUND, "Auction "+id+" not found").withDetail("auctionId", id)
+	}
+	err = decodeStrict(auctionAsBytes, &auction)
+	if err != nil {
+		return auction, corruptRecordError(key, "is not an auction: "+err.Error())
+	}
+	return auction, nil
+}
+
+// writeAuction stores an auction under its composite key
+func writeAuction(stub shim.ChaincodeStubInterface, auction *Auction) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(AUCTION_INDEX, []string{auction.Id})
+	if err != nil {
+		return nil, err
+	}
+	return writeRecord(stub, key, auction)
+}
@@ -0,0 +1,112 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newAuctionEnv creates s1 with 5 of p1 and an auction of 2 of them, open for bids for an hour
+func newAuctionEnv(t *testing.T) (*testEnv, Auction) {
+	e := newTestEnv(t)
+	e.createUser("u1", 5000)
+	e.createUser("u2", 5000)
+	e.createSeller("s1", "p1", 5, 10)
+
+	var auction Auction
+	e.as("s1").mustInvoke(&auction, "createAuction", "s1", "p1", "2", "10", "2018-03-01T09:00:00Z", "2018-03-01T10:00:00Z")
+	return e, auction
+}
+
+func TestAuctionEscrowsBidsAndRefundsTheOutbid(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+	if e.seller("s1").Products[0].Count != 3 {
+		t.Fatalf("lot was not taken from the inventory: %+v", e.seller("s1").Products)
+	}
+
+	e.as("u1").mustFail(ERR_BID_TOO_LOW, "placeBid", "u1", auction.Id, "9")
+	e.as("u1").mustInvoke(nil, "placeBid", "u1", auction.Id, "20")
+	e.as("u2").mustFail(ERR_BID_TOO_LOW, "placeBid", "u2", auction.Id, "20")
+	e.checkBalance("u1", 30)
+	e.as("u2").mustInvoke(nil, "placeBid", "u2", auction.Id, "25")
+	e.checkBalance("u1", 50)
+	e.checkBalance("u2", 25)
+	e.as("u1").mustFail(ERR_INSUFFICIENT_FUNDS, "placeBid", "u1", auction.Id, "60")
+
+	//escrow is part of the supply
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Escrow != 25 {
+		t.Fatalf("audit is %+v", audit)
+	}
+
+	e.as("u1").mustFail(ERR_INVALID_STATE, "settleAuction", auction.Id)
+	e.advance(time.Hour)
+	e.as("u1").mustFail(ERR_AUCTION_CLOSED, "placeBid", "u1", auction.Id, "30")
+
+	txId := e.nextTxId()
+	var settled Auction
+	e.as("u1").mustInvoke(&settled, "settleAuction", auction.Id)
+	if settled.State != AUCTION_SETTLED || settled.ContractId != "c"+txId || len(settled.EscrowBatches) != 0 {
+		t.Fatalf("settled auction is %+v", settled)
+	}
+	contract := e.contract(settled.ContractId)
+	if contract.State != STATE_READY || contract.UserId != "u2" || contract.Cost != 25 || contract.Quantity != 2 {
+		t.Fatalf("auction contract is %+v", contract)
+	}
+	e.checkBalance("s1", 25)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "settleAuction", auction.Id)
+}
+
+func TestAuctionWithoutBidsReturnsTheLot(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+	e.as("s1").mustFail(ERR_PRODUCT_UNAVAILABLE, "createAuction", "s1", "p1", "4", "10", "2018-03-01T09:00:00Z", "2018-03-01T10:00:00Z")
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "createAuction", "s1", "p1", "1", "10", "2018-03-01T10:00:00Z", "2018-03-01T09:00:00Z")
+
+	e.advance(time.Hour)
+	var settled Auction
+	e.as("u1").mustInvoke(&settled, "settleAuction", auction.Id)
+	if settled.State != AUCTION_SETTLED || settled.ContractId != "" || e.seller("s1").Products[0].Count != 5 {
+		t.Fatalf("auction without bids is %+v", settled)
+	}
+	e.as("u1").mustFail(ERR_AUCTION_NOT_FOUND, "placeBid", "u1", "nope", "10")
+}
+
+func TestAuctionOfAClosedSellerRefundsTheWinner(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+	e.as("u1").mustInvoke(nil, "placeBid", "u1", auction.Id, "20")
+	e.as("s1").mustInvoke(nil, "closeAccount", "s1")
+
+	e.advance(time.Hour)
+	var settled Auction
+	e.as("u1").mustInvoke(&settled, "settleAuction", auction.Id)
+	if settled.State != AUCTION_CANCELLED {
+		t.Fatalf("auction of a closed seller is %+v", settled)
+	}
+	e.checkBalance("u1", 50)
+	e.checkBalance("s1", 0)
+
+	var open []Auction
+	e.as("u1").mustInvoke(&open, "getAuctions", AUCTION_OPEN)
+	if len(open) != 0 {
+		t.Fatalf("open auctions are %+v", open)
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//badge achievement kind
+const BADGE_MILESTONE = "milestone"
+const BADGE_ZONE = "zone"
+
+//composite key object types of badges, keyed by badge id, of the owner index, keyed by owner and badge id,
+//and of achievements already awarded, keyed by the earning user, kind and milestone or zone
+const BADGE_INDEX = "badge"
+const BADGE_OWNER_INDEX = "badgeOwner"
+const BADGE_AWARD_INDEX = "badgeAward"
+
+// Badge is a non-fungible token awarded to a user for an achievement. It can be transferred to other
+// users, but each achievement is awarded to a user only once, whoever holds the badge now.
+type Badge struct {
+	Id           string   `json:"id"`
+	OwnerId      string   `json:"ownerId"`
+	EarnedBy     string   `json:"earnedBy"`
+	Kind         string   `json:"kind"`
+	Milestone    int      `json:"milestone,omitempty"`
+	ZoneId       string   `json:"zoneId,omitempty"`
+	MetadataHash string   `json:"metadataHash"`
+	IssuedAt     string   `json:"issuedAt"`
+	IssuedBy     Identity `json:"issuedBy"`
+	Version      int      `json:"schemaVersion"`
+}
+
+type issueBadgeRequest struct {
+	BadgeId      string `json:"badgeId" desc:"the id of the new badge"`
+	UserId       string `json:"userId" desc:"the id of the user earning the badge"`
+	Kind         string `json:"kind" desc:"milestone or zone"`
+	MetadataHash string `json:"metadataHash" desc:"hex sha256 hash of the badge metadata kept off the ledger"`
+	Milestone    int    `json:"milestone,omitempty" desc:"for milestone badges, the total steps the user reached"`
+	ZoneId       string `json:"zoneId,omitempty" desc:"for zone badges, the id of the zone the user visited"`
+}
+
+func (r *issueBadgeRequest) validate() error {
+	if !isSha256Hex(r.MetadataHash) {
+		return argError(3, "metadataHash", "must be a hex sha256 hash")
+	}
+	if r.Kind == BADGE_MILESTONE {
+		if r.Milestone <= 0 {
+			return argError(4, "milestone", "must be positive for a milestone badge")
+		}
+	} else if r.Kind == BADGE_ZONE {
+		if r.ZoneId == "" {
+			return argError(5, "zoneId", "is required for a zone badge")
+		}
+	} else {
+		return argError(2, "kind", "must be milestone or zone")
+	}
+	return nil
+}
+
+type transferBadgeRequest struct {
+	OwnerId    string `json:"ownerId" desc:"the id of the user owning the badge"`
+	BadgeId    string `json:"badgeId" desc:"the badge id"`
+	NewOwnerId string `json:"newOwnerId" desc:"the id of the user receiving the badge"`
+}
+
+type getUserBadgesRequest struct {
+	UserId string `json:"userId" desc:"the user's id"`
+}
+
+type getBadgeRequest struct {
+	BadgeId string `json:"badgeId" desc:"the badge id"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "issueBadge",
+		Description: "Issue a badge to a user for a step milestone or a zone visit",
+		Request:     issueBadgeRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).issueBadge,
+	})
+	registerFunction(Function{
+		Name:        "transferBadge",
+		Description: "Transfer a badge to another user",
+		Request:     transferBadgeRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).transferBadge,
+	})
+	registerFunction(Function{
+		Name:        "getUserBadges",
+		Description: "Get the badges a user owns",
+		Request:     getUserBadgesRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getUserBadges,
+	})
+	registerFunction(Function{
+		Name:        "getBadge",
+		Description: "Get a badge",
+		Request:     getBadgeRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getBadge,
+	})
+}
+
+// ============================================================================================================================
+// Issue badge
+// Inputs - badgeId, userId, kind, metadataHash, milestone (milestone badges), zoneId (zone badges)
+// ============================================================================================================================
+func (t *SimpleChaincode) issueBadge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request issueBadgeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get user
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//milestones must have been reached
+	achievement := request.ZoneId
+	if request.Kind == BADGE_MILESTONE {
+		if user.TotalSteps < request.Milestone {
+			return errorResponse(newError(ERR_INVALID_STATE, "User has not reached the milestone").
+				withDetail("userId", user.Id).
+				withDetail("totalSteps", user.TotalSteps).
+				withDetail("milestone", request.Milestone))
+		}
+		achievement = strconv.Itoa(request.Milestone)
+	}
+
+	//refuse badge ids in use and achievements already awarded
+	key, err := stub.CreateCompositeKey(BADGE_INDEX, []string{request.BadgeId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	awardKey, err := stub.CreateCompositeKey(BADGE_AWARD_INDEX, []string{user.Id, request.Kind, achievement})
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, existingKey := range []string{key, awardKey} {
+		existingAsBytes, err := stub.GetState(existingKey)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if existingAsBytes != nil {
+			return errorResponse(newError(ERR_ALREADY_EXISTS, "Badge already issued").
+				withDetail("badgeId", request.BadgeId).
+				withDetail("userId", user.Id).
+				withDetail("kind", request.Kind))
+		}
+	}
+
+	//create badge
+	issuer, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var badge Badge
+	badge.Id = request.BadgeId
+	badge.OwnerId = user.Id
+	badge.EarnedBy = user.Id
+	badge.Kind = request.Kind
+	if request.Kind == BADGE_MILESTONE {
+		badge.Milestone = request.Milestone
+	} else {
+		badge.ZoneId = request.ZoneId
+	}
+	badge.MetadataHash = request.MetadataHash
+	badge.IssuedAt = txTime.Format(TIMESTAMP_FORMAT)
+	badge.IssuedBy = issuer
+
+	//store badge, owner index and award
+	badgeAsBytes, err := writeRecord(stub, key, &badge)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = putBadgeOwner(stub, badge.OwnerId, badge.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	awardAsBytes, _ := json.Marshal(badge.Id)
+	err = stub.PutState(awardKey, awardAsBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(badgeAsBytes)
+}
+
+// ============================================================================================================================
+// Transfer badge
+// Inputs - ownerId, badgeId, newOwnerId
+// ============================================================================================================================
+func (t *SimpleChaincode) transferBadge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request transferBadgeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	badge, key, err := loadBadge(stub, request.BadgeId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if badge.OwnerId != request.OwnerId {
+		return errorResponse(newError(ERR_UNAUTHORIZED, "Member does not own the badge").
+			withDetail("memberId", request.OwnerId).
+			withDetail("badgeId", badge.Id))
+	}
+
+	//both users must be active
+	for _, userId := range []string{request.OwnerId, request.NewOwnerId} {
+		user, err := loadUser(stub, userId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = checkActive(user.Member)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	//move the badge in the owner index
+	oldOwnerKey, err := stub.CreateCompositeKey(BADGE_OWNER_INDEX, []string{badge.OwnerId, badge.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.DelState(oldOwnerKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	badge.OwnerId = request.NewOwnerId
+	err = putBadgeOwner(stub, badge.OwnerId, badge.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	badgeAsBytes, err := writeRecord(stub, key, &badge)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(badgeAsBytes)
+}
+
+// ============================================================================================================================
+// Get user badges
+// Inputs - userId
+// ============================================================================================================================
+func (t *SimpleChaincode) getUserBadges(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getUserBadgesRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(BADGE_OWNER_INDEX, []string{request.UserId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	badges := []Badge{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		badge, _, err := loadBadge(stub, keyParts[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		badges = append(badges, badge)
+	}
+
+	badgesAsBytes, _ := json.Marshal(badges)
+	return shim.Success(badgesAsBytes)
+}
+
+// ============================================================================================================================
+// Get badge
+// Inputs - badgeId
+// ============================================================================================================================
+func (t *SimpleChaincode) getBadge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getBadgeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	badge, _, err := loadBadge(stub, request.BadgeId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	badgeAsBytes, _ := json.Marshal(badge)
+	return shim.Success(badgeAsBytes)
+}
+
+// putBadgeOwner adds a badge to the owner index, the value is unused
+func putBadgeOwner(stub shim.ChaincodeStubInterface, ownerId string, badgeId string) error {
+	ownerKey, err := stub.CreateCompositeKey(BADGE_OWNER_INDEX, []string{ownerId, badgeId})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(ownerKey, []byte{0x00})
+}
+
+// loadBadge reads a badge by id, returning its key
+func loadBadge(stub shim.ChaincodeStubInterface, id string) (Badge, string, error) {
+	var badge Badge
+	key, err := stub.CreateCompositeKey(BADGE_INDEX, []string{id})
+	if err != nil {
+		return badge, "", err
+	}
+	badgeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return badge, "", err
+	}
+	if badgeAsBytes == nil {
+		return badge, "", newError(ERR_BADGE_NOT_FOUND, "Badge "+id+" not found").withDetail("badgeId", id)
+	}
+	err = decodeStrict(badgeAsBytes, &badge)
+	if err != nil {
+		return badge, "", corruptRecordError(key, "is not a badge: "+err.Error())
+	}
+	return badge, key, nil
+}
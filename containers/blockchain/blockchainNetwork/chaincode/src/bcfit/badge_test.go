@@ -0,0 +1,88 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// testMetadataHash is the metadata hash of a test badge
+func testMetadataHash(name string) string {
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:])
+}
+
+// userBadgeIds lists the ids of the badges a user owns
+func userBadgeIds(e *testEnv, userId string) []string {
+	var badges []Badge
+	e.as(userId).mustInvoke(&badges, "getUserBadges", userId)
+	ids := []string{}
+	for _, badge := range badges {
+		ids = append(ids, badge.Id)
+	}
+	return ids
+}
+
+func TestAchievementsAreAwardedOnce(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 12000)
+
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "issueBadge", "b1", "u1", BADGE_MILESTONE, testMetadataHash("20k"), "20000")
+	e.asAdmin().mustInvoke(nil, "issueBadge", "b1", "u1", BADGE_MILESTONE, testMetadataHash("10k"), "10000")
+	e.asAdmin().mustFail(ERR_ALREADY_EXISTS, "issueBadge", "b2", "u1", BADGE_MILESTONE, testMetadataHash("10k"), "10000")
+	e.asAdmin().mustFail(ERR_ALREADY_EXISTS, "issueBadge", "b1", "u1", BADGE_ZONE, testMetadataHash("park"), "0", "park")
+	e.asAdmin().mustInvoke(nil, "issueBadge", "b2", "u1", BADGE_ZONE, testMetadataHash("park"), "0", "park")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "issueBadge", "b3", "u1", BADGE_ZONE, testMetadataHash("lake"))
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "issueBadge", "b3", "u1", BADGE_ZONE, testMetadataHash("lake"), "0", "lake")
+
+	var badge Badge
+	e.as("u1").mustInvoke(&badge, "getBadge", "b1")
+	if badge.OwnerId != "u1" || badge.EarnedBy != "u1" || badge.Milestone != 10000 || badge.IssuedBy.Subject != TEST_ADMIN {
+		t.Fatalf("badge is %+v", badge)
+	}
+	e.as("u1").mustFail(ERR_BADGE_NOT_FOUND, "getBadge", "b9")
+}
+
+func TestBadgeTransfersMoveTheOwnerIndex(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 12000)
+	e.createUser("u2", 0)
+	e.asAdmin().mustInvoke(nil, "issueBadge", "b1", "u1", BADGE_MILESTONE, testMetadataHash("10k"), "10000")
+
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "transferBadge", "u2", "b1", "u2")
+	e.as("u1").mustFail(ERR_MEMBER_NOT_FOUND, "transferBadge", "u1", "b1", "u9")
+	e.as("u1").mustInvoke(nil, "transferBadge", "u1", "b1", "u2")
+	if ids := userBadgeIds(e, "u1"); len(ids) != 0 {
+		t.Fatalf("u1 still owns %v", ids)
+	}
+	if ids := userBadgeIds(e, "u2"); len(ids) != 1 || ids[0] != "b1" {
+		t.Fatalf("u2 owns %v", ids)
+	}
+
+	//the achievement stays with the user who earned it
+	e.asAdmin().mustFail(ERR_ALREADY_EXISTS, "issueBadge", "b2", "u1", BADGE_MILESTONE, testMetadataHash("10k"), "10000")
+	var badge Badge
+	e.as("u2").mustInvoke(&badge, "getBadge", "b1")
+	if badge.OwnerId != "u2" || badge.EarnedBy != "u1" {
+		t.Fatalf("transferred badge is %+v", badge)
+	}
+}
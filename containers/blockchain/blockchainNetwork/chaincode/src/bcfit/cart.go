@@ -0,0 +1,361 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//most line items in a cart
+const MAX_CART_ITEMS = 50
+
+// A cart contract buys several products, from one or more sellers, in a single contract. Its line items
+// are kept in Items and its SellerId and ProductId are empty. Each seller completes or declines all of
+// its own items at once; the user can decline the items of every seller. The contract stays pending
+// until no item is pending, then it is complete when any item was completed and declined otherwise.
+
+// CartItem is one product in a cart purchase request
+type CartItem struct {
+	SellerId  string `json:"sellerId"`
+	ProductId string `json:"productId"`
+	Quantity  int    `json:"quantity"`
+}
+
+type makeCartPurchaseRequest struct {
+	UserId string     `json:"userId" desc:"the buying user's id"`
+	Items  []CartItem `json:"items" desc:"the products to buy, each with sellerId, productId and quantity"`
+}
+
+func (r *makeCartPurchaseRequest) validate() error {
+	if len(r.Items) == 0 || len(r.Items) > MAX_CART_ITEMS {
+		return argError(1, "items", "must have between 1 and "+strconv.Itoa(MAX_CART_ITEMS)+" items")
+	}
+	seen := map[string]bool{}
+	for _, item := range r.Items {
+		if item.SellerId == "" || item.ProductId == "" {
+			return argError(1, "items", "must all have a sellerId and productId")
+		}
+		if item.Quantity <= 0 {
+			return argError(1, "items", "must all have a positive quantity")
+		}
+		key := item.SellerId + "/" + item.ProductId
+		if seen[key] {
+			return argError(1, "items", "must not list product "+key+" twice")
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "makeCartPurchase",
+		Description: "Create a pending purchase contract for several products from one or more sellers",
+		Request:     makeCartPurchaseRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).makeCartPurchase,
+	})
+}
+
+// ============================================================================================================================
+// Make Cart Purchase - creates a purchase Contract with a line item per product
+// Inputs - userID, items
+// ============================================================================================================================
+func (t *SimpleChaincode) makeCartPurchase(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request makeCartPurchaseRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var contract Contract
+	contract.Id, err = newContractId(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	contract.Type = KIND_CONTRACT
+	contract.UserId = request.UserId
+	err = setContractState(stub, &contract, STATE_PENDING, contract.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//price every item with its seller's current price
+	sellers := map[string]Seller{}
+	for _, item := range request.Items {
+		seller, ok := sellers[item.SellerId]
+		if !ok {
+			seller, err = loadSeller(stub, item.SellerId)
+			if err != nil {
+				return errorResponse(err)
+			}
+			err = checkActive(seller.Member)
+			if err != nil {
+				return errorResponse(err)
+			}
+			sellers[item.SellerId] = seller
+		}
+
+		product, found := findProduct(seller, item.ProductId)
+		if !found {
+			return errorResponse(productNotFoundError(item.SellerId, item.ProductId))
+		}
+
+		var lineItem LineItem
+		lineItem.SellerId = item.SellerId
+		lineItem.ProductId = item.ProductId
+		lineItem.ProductName = product.Name
+		lineItem.Quantity = item.Quantity
+		lineItem.Cost = product.Price * item.Quantity
+		lineItem.State = STATE_PENDING
+		//each seller gets its own pickup secret
+		lineItem.PickupHash, err = newPickupHash(stub, item.SellerId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		contract.Items = append(contract.Items, lineItem)
+		contract.Quantity = contract.Quantity + lineItem.Quantity
+		contract.Cost = contract.Cost + lineItem.Cost
+	}
+
+	// get user's current state
+	user, err := loadUser(stub, contract.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//check if user has enough Fitcoinsbalance for the whole cart
+	if user.FitcoinsBalance < contract.Cost {
+		return errorResponse(insufficientFundsError(user.Id, user.FitcoinsBalance, contract.Cost))
+	}
+
+	//store contract
+	contractAsBytes, err := writeRecord(stub, contract.Id, &contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//append contractId and update user's state
+	user.ContractIds = append(user.ContractIds, contract.Id)
+	_, err = writeRecord(stub, contract.UserId, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return shim.Success(contractAsBytes)
+}
+
+// ============================================================================================================================
+// Transact Cart - complete or decline the line items of a cart contract, called from transactPurchase
+// ============================================================================================================================
+func transactCart(stub shim.ChaincodeStubInterface, contract Contract, memberId string, newState string, pickupSecret string) pb.Response {
+
+	//ensure call is called by the user or one of the sellers
+	isSeller := false
+	for _, item := range contract.Items {
+		if item.SellerId == memberId {
+			isSeller = true
+		}
+	}
+	if memberId != contract.UserId && !isSeller {
+		return errorResponse(newError(ERR_UNAUTHORIZED, "Member not authorized to update contract").
+			withDetail("memberId", memberId).
+			withDetail("contractId", contract.Id))
+	}
+
+	//ensure the calling member is active
+	_, caller, err := loadMember(stub, memberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(*caller)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if contract.State != STATE_PENDING {
+		return errorResponse(newError(ERR_INVALID_STATE, "Contract already Complete or Declined").
+			withDetail("contractId", contract.Id).
+			withDetail("state", contract.State))
+	}
+
+	if newState == STATE_COMPLETE && isSeller {
+		err = completeCartItems(stub, &contract, memberId, pickupSecret)
+	} else if newState == STATE_DECLINED {
+		//the user declines the items of every seller
+		sellerId := memberId
+		if memberId == contract.UserId {
+			sellerId = ""
+		}
+		err = declineCartItems(stub, &contract, sellerId, memberId)
+	} else {
+		return errorResponse(argError(2, "newState", "must be complete or declined for a cart contract"))
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// update contract state on ledger
+	updatedContractAsBytes, err := writeRecord(stub, contract.Id, &contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(updatedContractAsBytes)
+}
+
+// completeCartItems completes all pending items of a seller, or none of them, when the seller presents its pickup secret
+func completeCartItems(stub shim.ChaincodeStubInterface, contract *Contract, sellerId string, pickupSecret string) error {
+	seller, err := loadSeller(stub, sellerId)
+	if err != nil {
+		return err
+	}
+	user, err := loadUser(stub, contract.UserId)
+	if err != nil {
+		return err
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	//take every item from the seller's inventory
+	subtotal := 0
+	completed := 0
+	for i := range contract.Items {
+		item := &contract.Items[i]
+		if item.SellerId != sellerId || item.State != STATE_PENDING {
+			continue
+		}
+		err = matchPickupSecret(contract.Id, item.PickupHash, pickupSecret)
+		if err != nil {
+			return err
+		}
+		if item.PickupHash != "" {
+			item.RedeemedAt = txTime.Format(TIMESTAMP_FORMAT)
+		}
+		productIndex := -1
+		for h := 0; h < len(seller.Products); h++ {
+			if seller.Products[h].Id == item.ProductId {
+				productIndex = h
+				break
+			}
+		}
+		if productIndex < 0 || seller.Products[productIndex].Count < item.Quantity {
+			return newError(ERR_PRODUCT_UNAVAILABLE, "Product not available for sale. Decline the seller's items instead.").
+				withDetail("contractId", contract.Id).
+				withDetail("productId", item.ProductId)
+		}
+		seller.Products[productIndex].Count = seller.Products[productIndex].Count - item.Quantity
+		subtotal = subtotal + item.Cost
+		item.State = STATE_COMPLETE
+		completed++
+	}
+	if completed == 0 {
+		return noPendingItemsError(*contract, sellerId)
+	}
+
+	//move the seller's subtotal from the user to the seller
+	_, err = spendCoins(stub, &user.Member, subtotal)
+	if err != nil {
+		return err
+	}
+	seller.FitcoinsBalance = seller.FitcoinsBalance + subtotal
+	_, err = writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return err
+	}
+	_, err = writeRecord(stub, seller.Id, &seller)
+	if err != nil {
+		return err
+	}
+
+	return settleCartState(stub, contract, sellerId)
+}
+
+// declineCartItems declines the pending items of a seller, or of every seller when sellerId is empty
+func declineCartItems(stub shim.ChaincodeStubInterface, contract *Contract, sellerId string, memberId string) error {
+	declined := 0
+	for i := range contract.Items {
+		item := &contract.Items[i]
+		if item.State != STATE_PENDING || (sellerId != "" && item.SellerId != sellerId) {
+			continue
+		}
+		item.State = STATE_DECLINED
+		declined++
+	}
+	if declined == 0 {
+		return noPendingItemsError(*contract, sellerId)
+	}
+	return settleCartState(stub, contract, memberId)
+}
+
+// settleCartState resolves the contract once none of its items is pending, the member's change resolving it
+// is recorded in the history
+func settleCartState(stub shim.ChaincodeStubInterface, contract *Contract, memberId string) error {
+	if hasItemsInState(*contract, "", STATE_PENDING) {
+		return nil
+	}
+	if hasItemsInState(*contract, "", STATE_COMPLETE) {
+		return setContractState(stub, contract, STATE_COMPLETE, memberId)
+	}
+	return setContractState(stub, contract, STATE_DECLINED, memberId)
+}
+
+// hasItemsInState tells whether the contract has items of the seller, or of any seller when sellerId is empty, in state
+func hasItemsInState(contract Contract, sellerId string, state string) bool {
+	for _, item := range contract.Items {
+		if item.State == state && (sellerId == "" || item.SellerId == sellerId) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContractParty tells whether the member is the user or a seller of the contract
+func isContractParty(contract Contract, memberId string) bool {
+	if contract.UserId == memberId || contract.SellerId == memberId {
+		return true
+	}
+	for _, item := range contract.Items {
+		if item.SellerId == memberId {
+			return true
+		}
+	}
+	return false
+}
+
+// noPendingItemsError reports a cart without pending items for the seller
+func noPendingItemsError(contract Contract, sellerId string) *ChaincodeError {
+	return newError(ERR_INVALID_STATE, "Contract has no pending items for the member").
+		withDetail("contractId", contract.Id).
+		withDetail("sellerId", sellerId)
+}
@@ -0,0 +1,110 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+//testCart is a cart of two products of s1 and one of s2
+const testCart = `[{"sellerId":"s1","productId":"p1","quantity":2},{"sellerId":"s2","productId":"p2","quantity":1},{"sellerId":"s1","productId":"p3","quantity":1}]`
+
+func newCartEnv(t *testing.T) *testEnv {
+	e := newTestEnv(t)
+	e.createUser("u1", 2000)
+	e.createSeller("s1", "p1", 5, 3)
+	e.as("s1").mustInvoke(nil, "createProduct", "s1", "p3", "Product p3", "1", "4")
+	e.createSeller("s2", "p2", 5, 6)
+	return e
+}
+
+func TestCartIsSettledPerSeller(t *testing.T) {
+	e := newCartEnv(t)
+
+	txId := e.nextTxId()
+	var cart Contract
+	e.as("u1").mustInvoke(&cart, "makeCartPurchase", "u1", testCart)
+	if cart.Id != "c"+txId || cart.Cost != 16 || cart.Quantity != 4 || len(cart.Items) != 3 || cart.State != STATE_PENDING {
+		t.Fatalf("cart is %+v", cart)
+	}
+
+	//s1 completes both its items at once and is paid their subtotal
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", cart.Id, STATE_COMPLETE, e.pickupSecret(cart.Id, "s1"))
+	e.as("s1").mustFail(ERR_INVALID_STATE, "transactPurchase", "s1", cart.Id, STATE_COMPLETE)
+	e.checkBalance("s1", 10)
+	e.checkBalance("u1", 10)
+	if e.contract(cart.Id).State != STATE_PENDING {
+		t.Fatalf("cart with a pending item is %s", e.contract(cart.Id).State)
+	}
+
+	//the user declines what is left, and the cart is complete because some items were
+	e.as("u1").mustInvoke(nil, "transactPurchase", "u1", cart.Id, STATE_DECLINED)
+	settled := e.contract(cart.Id)
+	if settled.State != STATE_COMPLETE || settled.Items[1].State != STATE_DECLINED || settled.Items[0].State != STATE_COMPLETE {
+		t.Fatalf("settled cart is %+v", settled)
+	}
+	e.as("s2").mustFail(ERR_INVALID_STATE, "transactPurchase", "s2", cart.Id, STATE_COMPLETE)
+	e.checkBalance("s2", 0)
+
+	seller := e.seller("s1")
+	if seller.Products[0].Count != 3 || seller.Products[1].Count != 0 {
+		t.Fatalf("s1 inventory is %+v", seller.Products)
+	}
+}
+
+func TestCartDeclinedByEverySellerIsDeclined(t *testing.T) {
+	e := newCartEnv(t)
+
+	var cart Contract
+	e.as("u1").mustInvoke(&cart, "makeCartPurchase", "u1", testCart)
+	e.createSeller("s3", "", 0, 0)
+	e.as("s3").mustFail(ERR_UNAUTHORIZED, "transactPurchase", "s3", cart.Id, STATE_DECLINED)
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "transactPurchase", "s1", cart.Id, STATE_ACCEPTED)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", cart.Id, STATE_DECLINED)
+	e.as("s2").mustInvoke(nil, "transactPurchase", "s2", cart.Id, STATE_DECLINED)
+	if e.contract(cart.Id).State != STATE_DECLINED {
+		t.Fatalf("declined cart is %s", e.contract(cart.Id).State)
+	}
+	e.checkBalance("u1", 20)
+}
+
+func TestCartRequestsAreChecked(t *testing.T) {
+	e := newCartEnv(t)
+
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "makeCartPurchase", "u1", `[]`)
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "makeCartPurchase", "u1", `[{"sellerId":"s1","productId":"p1","quantity":1},{"sellerId":"s1","productId":"p1","quantity":1}]`)
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "makeCartPurchase", "u1", `[{"sellerId":"s1","productId":"p1","quantity":0}]`)
+	e.as("u1").mustFail(ERR_PRODUCT_NOT_FOUND, "makeCartPurchase", "u1", `[{"sellerId":"s2","productId":"p1","quantity":1}]`)
+	e.as("u1").mustFail(ERR_INSUFFICIENT_FUNDS, "makeCartPurchase", "u1", `[{"sellerId":"s2","productId":"p2","quantity":4}]`)
+}
+
+func TestMembersWithContractLikeIdsAreNotContracts(t *testing.T) {
+	e := newCartEnv(t)
+	e.createUser("c0ffee", 0)
+
+	var cart Contract
+	e.as("u1").mustInvoke(&cart, "makeCartPurchase", "u1", testCart)
+
+	var contracts []Contract
+	e.as("u1").mustInvoke(&contracts, "getAllContracts")
+	if len(contracts) != 1 || contracts[0].Id != cart.Id {
+		t.Fatalf("contracts are %+v", contracts)
+	}
+}
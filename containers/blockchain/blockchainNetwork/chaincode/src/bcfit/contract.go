@@ -22,46 +22,138 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math/rand"
-	"strconv"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+//contract ids are "c" followed by the hex id of the transaction that made the contract, or by digits for contracts
+//made before, so contracts are stored between these keys, along with the members whose ids start the same way
+const CONTRACT_KEY_START = "c0"
+const CONTRACT_KEY_END = "cg"
+
+// contractTransitions is the state machine of single product contracts: for each state, the states it can
+// move to and whether the contract's user, seller or both may make the move. A seller may still complete
+// a pending contract directly when the order is handed over at once. Cart contracts are completed or
+// declined per seller instead, see cart.go.
+var contractTransitions = map[string]map[string][]string{
+	STATE_PENDING: {
+		STATE_ACCEPTED: {TYPE_SELLER},
+		STATE_COMPLETE: {TYPE_SELLER},
+		STATE_DECLINED: {TYPE_USER, TYPE_SELLER},
+	},
+	STATE_ACCEPTED: {
+		STATE_READY:     {TYPE_SELLER},
+		STATE_CANCELLED: {TYPE_USER, TYPE_SELLER},
+	},
+	STATE_READY: {
+		STATE_COMPLETE:  {TYPE_SELLER},
+		STATE_CANCELLED: {TYPE_USER, TYPE_SELLER},
+	},
+	STATE_COMPLETE:  {},
+	STATE_DECLINED:  {},
+	STATE_CANCELLED: {},
+}
+
+type makePurchaseRequest struct {
+	UserId    string `json:"userId" desc:"the buying user's id"`
+	SellerId  string `json:"sellerId" desc:"the seller's id"`
+	ProductId string `json:"productId" desc:"the id of the product with the seller"`
+	Quantity  int    `json:"quantity" desc:"the quantity to buy"`
+}
+
+func (r *makePurchaseRequest) validate() error {
+	if r.Quantity <= 0 {
+		return argError(3, "quantity", "must be positive")
+	}
+	return nil
+}
+
+type transactPurchaseRequest struct {
+	MemberId     string `json:"memberId" desc:"the id of the contract's user or seller"`
+	ContractId   string `json:"contractId" desc:"the contract id returned by makePurchase"`
+	NewState     string `json:"newState" desc:"accepted, ready, complete, declined or cancelled"`
+	PickupSecret string `json:"pickupSecret,omitempty" desc:"the pickup secret the user presented, required to complete a contract with a pickup hash"`
+}
+
+type getAllUserContractsRequest struct {
+	UserId string `json:"userId" desc:"the user's id"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "makePurchase",
+		Description: "Create a pending purchase contract",
+		Request:     makePurchaseRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).makePurchase,
+	})
+	registerFunction(Function{
+		Name:        "transactPurchase",
+		Description: "Move a contract to its next state; getContractTransitions lists who may make each move",
+		Request:     transactPurchaseRequest{},
+		Role:        ROLE_MEMBER,
+		handler:     (*SimpleChaincode).transactPurchase,
+	})
+	registerFunction(Function{
+		Name:        "getContractTransitions",
+		Description: "Get the contract state machine: for each state, the next states and who may move to them",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getContractTransitions,
+	})
+	registerFunction(Function{
+		Name:        "getAllUserContracts",
+		Description: "Get all contracts of a user",
+		Request:     getAllUserContractsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getAllUserContracts,
+	})
+	registerFunction(Function{
+		Name:        "getAllContracts",
+		Description: "Get all contracts",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getAllContracts,
+	})
+}
+
 // ============================================================================================================================
 // Make Purchase - creates purchase Contract
-// Inputs - userID, sellerID, productID, quantity
+// Inputs - userID, sellerID, productID, quantity, and optionally a couponCode in the transient map
 // ============================================================================================================================
 func (t *SimpleChaincode) makePurchase(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments")
+	var request makePurchaseRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var err error
 
 	//creates contract struct with properties, and get sellerID, userID, productID, quantity from args
 	var contract Contract
-	contract.Id = "c" + randomInts(6)
-	contract.UserId = args[0]
-	contract.SellerId = args[1]
-	contract.ProductId = args[2]
-	quantity, err := strconv.Atoi(args[3])
+	contract.Id, err = newContractId(stub)
 	if err != nil {
-		return shim.Error("4th argument 'quantity' must be a numeric string")
+		return errorResponse(err)
 	}
-	contract.Quantity = quantity
+	contract.Type = KIND_CONTRACT
+	contract.UserId = request.UserId
+	contract.SellerId = request.SellerId
+	contract.ProductId = request.ProductId
+	contract.Quantity = request.Quantity
 
 	//get seller
-	sellerAsBytes, err := stub.GetState(contract.SellerId)
+	seller, err := loadSeller(stub, contract.SellerId)
 	if err != nil {
-		return shim.Error("Failed to get seller")
+		return errorResponse(err)
 	}
-	var seller Seller
-	json.Unmarshal(sellerAsBytes, &seller)
-	if seller.Type != TYPE_SELLER {
-		return shim.Error("Not seller type")
+	err = checkActive(seller.Member)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//find the product
@@ -77,47 +169,66 @@ func (t *SimpleChaincode) makePurchase(stub shim.ChaincodeStubInterface, args []
 
 	//if product not found return error
 	if productFound != true {
-		return shim.Error("Product not found")
+		return errorResponse(productNotFoundError(contract.SellerId, contract.ProductId))
 	}
 
 	//calculates cost and assigns to contract
 	contract.Cost = product.Price * contract.Quantity
+	//apply coupon discount
+	couponCode, err := getCouponCode(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if couponCode != "" {
+		coupon, discount, err := redeemCoupon(stub, contract.SellerId, contract.UserId, couponCode, contract.Cost)
+		if err != nil {
+			return errorResponse(err)
+		}
+		contract.CouponHash = coupon.CodeHash
+		contract.Discount = discount
+		contract.Cost = contract.Cost - discount
+	}
 	//gets product name
 	contract.ProductName = product.Name
+	//store the hash of the pickup secret the user presents at hand-over
+	contract.PickupHash, err = newPickupHash(stub, "")
+	if err != nil {
+		return errorResponse(err)
+	}
 	//assign 'Pending' state
-	contract.State = STATE_PENDING
+	err = setContractState(stub, &contract, STATE_PENDING, contract.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	// get user's current state
-	var user User
-	userAsBytes, err := stub.GetState(contract.UserId)
+	user, err := loadUser(stub, contract.UserId)
 	if err != nil {
-		return shim.Error("Failed to get user")
+		return errorResponse(err)
 	}
-	json.Unmarshal(userAsBytes, &user)
-	if user.Type != TYPE_USER {
-		return shim.Error("Not user type")
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//check if user has enough Fitcoinsbalance
 	if user.FitcoinsBalance < contract.Cost {
-		return shim.Error("Insufficient funds")
+		return errorResponse(insufficientFundsError(user.Id, user.FitcoinsBalance, contract.Cost))
 	}
 
 	//store contract
-	contractAsBytes, _ := json.Marshal(contract)
-	err = stub.PutState(contract.Id, contractAsBytes)
+	contractAsBytes, err := writeRecord(stub, contract.Id, &contract)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	//append contractId
 	user.ContractIds = append(user.ContractIds, contract.Id)
 
 	//update user's state
-	updatedUserAsBytes, _ := json.Marshal(user)
-	err = stub.PutState(contract.UserId, updatedUserAsBytes)
+	_, err = writeRecord(stub, contract.UserId, &user)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	//return contract info
@@ -128,112 +239,229 @@ func (t *SimpleChaincode) makePurchase(stub shim.ChaincodeStubInterface, args []
 }
 
 // ============================================================================================================================
-// Transact Purchase - update user account, update seller's account and product inventory, update contract state
-// Inputs - memberId, contractID, newState(complete or declined)
+// Transact Purchase - move the contract to its next state, completing it updates the user account, the seller's
+// account and product inventory
+// Inputs - memberId, contractID, newState(accepted, ready, complete, declined or cancelled), pickupSecret(to complete a contract with a pickup hash)
 // ============================================================================================================================
 func (t *SimpleChaincode) transactPurchase(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments")
+	var request transactPurchaseRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
+
 	//get contractID args
-	memberId := args[0]
-	contractId := args[1]
-	newState := args[2]
+	memberId := request.MemberId
+	contractId := request.ContractId
+	newState := request.NewState
 
 	// Get contract from the ledger
-	contractAsBytes, err := stub.GetState(contractId)
+	contract, err := loadContract(stub, contractId)
 	if err != nil {
-		return shim.Error("Failed to get contract")
+		return errorResponse(err)
+	}
+
+	//cart contracts are completed or declined per seller
+	if len(contract.Items) > 0 {
+		return transactCart(stub, contract, memberId, newState, request.PickupSecret)
+	}
+
+	//auction contracts are paid for at settlement
+	if contract.AuctionId != "" {
+		return transactAuctionContract(stub, contract, memberId, newState, request.PickupSecret)
 	}
-	var contract Contract
-	json.Unmarshal(contractAsBytes, &contract)
 
 	//ensure call is called by authorized user
 	if memberId != contract.SellerId && memberId != contract.UserId {
-		return shim.Error("Member not authorized to update contract")
+		return errorResponse(newError(ERR_UNAUTHORIZED, "Member not authorized to update contract").
+			withDetail("memberId", memberId).
+			withDetail("contractId", contract.Id))
 	}
 
-	//if current contract state is pending, then execute transaction
-	if contract.State == STATE_PENDING {
-		if newState == STATE_COMPLETE && memberId == contract.SellerId {
-			//get seller
-			var member Seller
-			memberAsBytes, err := stub.GetState(memberId)
-			if err != nil {
-				return shim.Error("Failed to get member")
-			}
-			json.Unmarshal(memberAsBytes, &member)
+	//ensure the calling member is active
+	_, caller, err := loadMember(stub, memberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(*caller)
+	if err != nil {
+		return errorResponse(err)
+	}
 
-			//get contract user's current state
-			var contractUser User
-			contractUserAsBytes, err := stub.GetState(contract.UserId)
-			if err != nil {
-				return shim.Error("Failed to get contract owner")
-			}
-			json.Unmarshal(contractUserAsBytes, &contractUser)
+	//the member must be allowed to move the contract to the new state
+	err = checkTransition(contract, memberId, newState)
+	if err != nil {
+		return errorResponse(err)
+	}
 
-			//update user's FitcoinsBalance
-			if (contractUser.FitcoinsBalance - contract.Cost) >= 0 {
-				contractUser.FitcoinsBalance = contractUser.FitcoinsBalance - contract.Cost
-			} else {
-				return shim.Error("Insufficient fitcoins")
-			}
+	if newState == STATE_COMPLETE {
+		//the seller proves the hand-over with the user's pickup secret
+		err = checkPickupSecret(contract, request.PickupSecret)
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		//get seller
+		member, err := loadSeller(stub, memberId)
+		if err != nil {
+			return errorResponse(err)
+		}
 
-			//update seller's product count
-			productFound := false
-			for h := 0; h < len(member.Products); h++ {
-				if member.Products[h].Id == contract.ProductId {
-					productFound = true
-					if member.Products[h].Count >= contract.Quantity {
-						member.Products[h].Count = member.Products[h].Count - contract.Quantity
-					}
-					break
+		//get contract user's current state
+		contractUser, err := loadUser(stub, contract.UserId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = checkActive(contractUser.Member)
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		//check user's FitcoinsBalance
+		if contractUser.FitcoinsBalance < contract.Cost {
+			return errorResponse(insufficientFundsError(contractUser.Id, contractUser.FitcoinsBalance, contract.Cost))
+		}
+
+		//update seller's product count
+		productFound := false
+		for h := 0; h < len(member.Products); h++ {
+			if member.Products[h].Id == contract.ProductId {
+				productFound = true
+				if member.Products[h].Count >= contract.Quantity {
+					member.Products[h].Count = member.Products[h].Count - contract.Quantity
 				}
+				break
 			}
-			//if product not found return error
-			if productFound == true {
-				//update seller's FitcoinsBalance
-				member.FitcoinsBalance = member.FitcoinsBalance + contract.Cost
-				//update user state
-				updatedUserAsBytes, _ := json.Marshal(contractUser)
-				err = stub.PutState(contract.UserId, updatedUserAsBytes)
-				if err != nil {
-					return shim.Error(err.Error())
-				}
-				//update seller state
-				updatedSellerAsBytes, _ := json.Marshal(member)
-				err = stub.PutState(contract.SellerId, updatedSellerAsBytes)
-				if err != nil {
-					return shim.Error(err.Error())
-				}
-				contract.State = STATE_COMPLETE
-
-			} else {
-				contract.State = STATE_DECLINED
-				declinedContractAsBytes, _ := json.Marshal(contract)
-				err = stub.PutState(contract.Id, declinedContractAsBytes)
+		}
+		//if product not found return error
+		if productFound == true {
+			//move the user's oldest coins to the seller
+			_, err = spendCoins(stub, &contractUser.Member, contract.Cost)
+			if err != nil {
+				return errorResponse(err)
+			}
+			member.FitcoinsBalance = member.FitcoinsBalance + contract.Cost
+			//update user state
+			_, err = writeRecord(stub, contract.UserId, &contractUser)
+			if err != nil {
+				return errorResponse(err)
+			}
+			//update seller state
+			_, err = writeRecord(stub, contract.SellerId, &member)
+			if err != nil {
+				return errorResponse(err)
+			}
+			if contract.PickupHash != "" {
+				txTime, err := getTxTime(stub)
 				if err != nil {
-					return shim.Error(err.Error())
+					return errorResponse(err)
 				}
-				return shim.Error("Product not available for sale. Cancelling contract.")
+				contract.RedeemedAt = txTime.Format(TIMESTAMP_FORMAT)
 			}
-		} else if newState == STATE_DECLINED {
-			contract.State = STATE_DECLINED
+
 		} else {
-			return shim.Error("Invalid new state")
+			contract.State = STATE_DECLINED
+			err = releaseCoupon(stub, contract)
+			if err != nil {
+				return errorResponse(err)
+			}
+			_, err = writeRecord(stub, contract.Id, &contract)
+			if err != nil {
+				return errorResponse(err)
+			}
+			return errorResponse(newError(ERR_PRODUCT_UNAVAILABLE, "Product not available for sale. Cancelling contract.").
+				withDetail("contractId", contract.Id).
+				withDetail("productId", contract.ProductId))
 		}
-
-		// update contract state on ledger
-		updatedContractAsBytes, _ := json.Marshal(contract)
-		err = stub.PutState(contract.Id, updatedContractAsBytes)
+	} else if newState == STATE_DECLINED || newState == STATE_CANCELLED {
+		err = releaseCoupon(stub, contract)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
+		}
+	}
+
+	//record the transition
+	err = setContractState(stub, &contract, newState, memberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// update contract state on ledger
+	updatedContractAsBytes, err := writeRecord(stub, contract.Id, &contract)
+	if err != nil {
+		return errorResponse(err)
+	}
+	//return contract info
+	return shim.Success(updatedContractAsBytes)
+}
+
+// checkTransition refuses state changes the contract's state machine does not allow to the member
+func checkTransition(contract Contract, memberId string, newState string) error {
+	if _, ok := contractTransitions[newState]; !ok {
+		return argError(2, "newState", "must be one of "+strings.Join(contractStates(), ", "))
+	}
+	actors, ok := contractTransitions[contract.State][newState]
+	if !ok {
+		var next []string
+		for _, state := range contractStates() {
+			if _, allowed := contractTransitions[contract.State][state]; allowed {
+				next = append(next, state)
+			}
 		}
-		//return contract info
-		return shim.Success(updatedContractAsBytes)
-	} else {
-		return shim.Error("Contract already Complete or Declined")
+		return newError(ERR_INVALID_STATE, "Contract cannot move from "+contract.State+" to "+newState).
+			withDetail("contractId", contract.Id).
+			withDetail("state", contract.State).
+			withDetail("nextStates", next)
+	}
+
+	//the member acts as the contract's user or seller
+	role := TYPE_USER
+	if memberId == contract.SellerId {
+		role = TYPE_SELLER
+	}
+	for _, actor := range actors {
+		if actor == role {
+			return nil
+		}
+	}
+	return newError(ERR_UNAUTHORIZED, "Member not allowed to move the contract to "+newState).
+		withDetail("memberId", memberId).
+		withDetail("contractId", contract.Id).
+		withDetail("allowed", actors)
+}
+
+// setContractState moves a contract to a state and appends the change to its history
+func setContractState(stub shim.ChaincodeStubInterface, contract *Contract, state string, memberId string) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
 	}
+	contract.State = state
+	contract.History = append(contract.History, StateChange{State: state, MemberId: memberId, Timestamp: txTime.Format(TIMESTAMP_FORMAT)})
+	return nil
+}
+
+// contractStates lists every contract state in a stable order
+func contractStates() []string {
+	var states []string
+	for state := range contractTransitions {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// ============================================================================================================================
+// Get contract transitions
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) getContractTransitions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	transitionsAsBytes, _ := json.Marshal(contractTransitions)
+	return shim.Success(transitionsAsBytes)
 }
 
 // ============================================================================================================================
@@ -241,35 +469,29 @@ func (t *SimpleChaincode) transactPurchase(stub shim.ChaincodeStubInterface, arg
 // Inputs - userID
 // ============================================================================================================================
 func (t *SimpleChaincode) getAllUserContracts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+	var request getAllUserContractsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var err error
 
 	//get userID from args
-	user_id := args[0]
+	user_id := request.UserId
 
 	//get user
-	userAsBytes, err := stub.GetState(user_id)
+	user, err := loadUser(stub, user_id)
 	if err != nil {
-		return shim.Error("Failed to get user")
-	}
-	var user User
-	json.Unmarshal(userAsBytes, &user)
-	if user.Type != TYPE_USER {
-		return shim.Error("Not user type")
+		return errorResponse(err)
 	}
 
 	//get user contracts
 	var contracts []Contract
 	for h := 0; h < len(user.ContractIds); h++ {
 		//get contract from the ledger
-		contractAsBytes, err := stub.GetState(user.ContractIds[h])
+		contract, err := loadContract(stub, user.ContractIds[h])
 		if err != nil {
-			return shim.Error("Failed to get contract")
+			return errorResponse(err)
 		}
-		var contract Contract
-		json.Unmarshal(contractAsBytes, &contract)
 		contracts = append(contracts, contract)
 	}
 	//change to array of bytes
@@ -283,26 +505,35 @@ func (t *SimpleChaincode) getAllUserContracts(stub shim.ChaincodeStubInterface,
 // Inputs - (none)
 // ============================================================================================================================
 func (t *SimpleChaincode) getAllContracts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
 	var contracts []Contract
 
 	// ---- Get All Contracts ---- //
-	resultsIterator, err := stub.GetStateByRange("c0", "c9999999999999999999")
+	resultsIterator, err := stub.GetStateByRange(CONTRACT_KEY_START, CONTRACT_KEY_END)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	defer resultsIterator.Close()
 
 	for resultsIterator.HasNext() {
 		aKeyValue, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 		queryKeyAsStr := aKeyValue.Key
 		queryValAsBytes := aKeyValue.Value
 		fmt.Println("on contract id - ", queryKeyAsStr)
+		if !isContractRecord(queryKeyAsStr, queryValAsBytes) {
+			continue
+		}
 		var contract Contract
-		json.Unmarshal(queryValAsBytes, &contract)
+		err = decodeRecord(queryKeyAsStr, queryValAsBytes, KIND_CONTRACT, &contract)
+		if err != nil {
+			return errorResponse(err)
+		}
 		contracts = append(contracts, contract)
 	}
 
@@ -312,22 +543,99 @@ func (t *SimpleChaincode) getAllContracts(stub shim.ChaincodeStubInterface, args
 
 }
 
-//generate an array of random ints
-func randomArray(len int) []int {
-	a := make([]int, len)
-	for i := 0; i <= len-1; i++ {
-		a[i] = rand.Intn(10)
+// ============================================================================================================================
+// Decline all pending contracts of a user or seller, and cancel its accepted or ready contracts
+// ============================================================================================================================
+func declinePendingContracts(stub shim.ChaincodeStubInterface, memberId string) error {
+	resultsIterator, err := stub.GetStateByRange(CONTRACT_KEY_START, CONTRACT_KEY_END)
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if !isContractRecord(aKeyValue.Key, aKeyValue.Value) {
+			continue
+		}
+		var contract Contract
+		err = decodeRecord(aKeyValue.Key, aKeyValue.Value, KIND_CONTRACT, &contract)
+		if err != nil {
+			return err
+		}
+		//auction contracts are paid for, the hand-over still takes place
+		if !isContractParty(contract, memberId) || contract.AuctionId != "" {
+			continue
+		}
+		newState := STATE_DECLINED
+		if contract.State == STATE_ACCEPTED || contract.State == STATE_READY {
+			newState = STATE_CANCELLED
+		} else if contract.State != STATE_PENDING {
+			continue
+		}
+
+		//a closing seller only declines its own items of a cart
+		if len(contract.Items) > 0 {
+			sellerId := memberId
+			if memberId == contract.UserId {
+				sellerId = ""
+			} else if !hasItemsInState(contract, sellerId, STATE_PENDING) {
+				continue
+			}
+			err = declineCartItems(stub, &contract, sellerId, memberId)
+			if err != nil {
+				return err
+			}
+			_, err = writeRecord(stub, contract.Id, &contract)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = setContractState(stub, &contract, newState, memberId)
+		if err != nil {
+			return err
+		}
+		err = releaseCoupon(stub, contract)
+		if err != nil {
+			return err
+		}
+		_, err = writeRecord(stub, contract.Id, &contract)
+		if err != nil {
+			return err
+		}
 	}
-	return a
+	return nil
 }
 
-// Generate a random string of ints with length len
-func randomInts(len int) string {
-	rand.Seed(time.Now().UnixNano())
-	intArray := randomArray(len)
-	var stringInt []string
-	for _, i := range intArray {
-		stringInt = append(stringInt, strconv.Itoa(i))
+// newContractId returns the id of the contract made by the transaction, refusing an id already in use
+func newContractId(stub shim.ChaincodeStubInterface) (string, error) {
+	contractId := "c" + stub.GetTxID()
+	existingAsBytes, err := stub.GetState(contractId)
+	if err != nil {
+		return "", err
+	}
+	if existingAsBytes != nil {
+		return "", newError(ERR_ALREADY_EXISTS, "Contract "+contractId+" already exists").withDetail("contractId", contractId)
+	}
+	return contractId, nil
+}
+
+// isContractKey tells whether key is in the key range of contract ids
+func isContractKey(key string) bool {
+	return key >= CONTRACT_KEY_START && key < CONTRACT_KEY_END
+}
+
+// isContractRecord tells whether a record of the contract key range is a contract rather than a member
+func isContractRecord(key string, recordAsBytes []byte) bool {
+	record, err := parseRecord(key, recordAsBytes)
+	if err != nil {
+		return true
 	}
-	return strings.Join(stringInt, "")
+	kind := recordKind(record)
+	return kind == KIND_CONTRACT || kind == ""
 }
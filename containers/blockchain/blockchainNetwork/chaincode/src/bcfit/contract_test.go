@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newContractEnv creates u1 holding 10 fitcoins, s1 selling p1 for 3, and a pending contract for 2 of them
+func newContractEnv(t *testing.T) (*testEnv, Contract) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createSeller("s1", "p1", 5, 3)
+	var contract Contract
+	e.as("u1").mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "2")
+	return e, contract
+}
+
+func TestContractIdIsTheTransactionId(t *testing.T) {
+	e, contract := newContractEnv(t)
+	if contract.Id != "c"+fmt.Sprintf("%064x", e.txCount) {
+		t.Fatalf("contract id is %s", contract.Id)
+	}
+
+	//an id already in use is not overwritten
+	key := "c" + e.nextTxId()
+	e.put(key, `{"id":"taken"}`)
+	e.as("u1").mustFail(ERR_ALREADY_EXISTS, "makePurchase", "u1", "s1", "p1", "1")
+	if e.get(key) != `{"id":"taken"}` {
+		t.Fatalf("existing record was overwritten")
+	}
+}
+
+func TestContractMovesThroughAcceptedAndReady(t *testing.T) {
+	e, contract := newContractEnv(t)
+
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "transactPurchase", "u1", contract.Id, STATE_ACCEPTED)
+	e.as("s1").mustFail(ERR_INVALID_STATE, "transactPurchase", "s1", contract.Id, STATE_READY)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_ACCEPTED)
+	e.as("s1").mustFail(ERR_INVALID_STATE, "transactPurchase", "s1", contract.Id, STATE_COMPLETE)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_READY)
+	e.checkBalance("u1", 10)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, e.pickupSecret(contract.Id, ""))
+	e.as("u1").mustFail(ERR_INVALID_STATE, "transactPurchase", "u1", contract.Id, STATE_CANCELLED)
+
+	completed := e.contract(contract.Id)
+	var states []string
+	for _, change := range completed.History {
+		states = append(states, change.State)
+	}
+	if completed.State != STATE_COMPLETE || len(states) != 4 || states[0] != STATE_PENDING || states[3] != STATE_COMPLETE || completed.History[1].MemberId != "s1" {
+		t.Fatalf("contract history is %+v", completed.History)
+	}
+	e.checkBalance("u1", 4)
+	e.checkBalance("s1", 6)
+	if e.seller("s1").Products[0].Count != 3 {
+		t.Fatalf("inventory is %+v", e.seller("s1").Products)
+	}
+}
+
+func TestContractStateMachineRefusesOtherMoves(t *testing.T) {
+	e, contract := newContractEnv(t)
+	e.createUser("u2", 0)
+
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "transactPurchase", "u2", contract.Id, STATE_DECLINED)
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "transactPurchase", "s1", contract.Id, "shipped")
+	failure := e.as("u1").mustFail(ERR_INVALID_STATE, "transactPurchase", "u1", contract.Id, STATE_CANCELLED)
+	if next, ok := failure.Details["nextStates"].([]interface{}); !ok || len(next) != 3 {
+		t.Fatalf("next states are %v", failure.Details["nextStates"])
+	}
+	e.as("s1").mustFail(ERR_CONTRACT_NOT_FOUND, "transactPurchase", "s1", "c9", STATE_ACCEPTED)
+
+	//an accepted contract is cancelled, not declined
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_ACCEPTED)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "transactPurchase", "u1", contract.Id, STATE_DECLINED)
+	e.as("u1").mustInvoke(nil, "transactPurchase", "u1", contract.Id, STATE_CANCELLED)
+	if e.contract(contract.Id).State != STATE_CANCELLED {
+		t.Fatalf("contract is %s", e.contract(contract.Id).State)
+	}
+	e.checkBalance("u1", 10)
+
+	var transitions map[string]map[string][]string
+	e.as("u1").mustInvoke(&transitions, "getContractTransitions")
+	if len(transitions) != 6 || len(transitions[STATE_READY][STATE_COMPLETE]) != 1 {
+		t.Fatalf("transitions are %+v", transitions)
+	}
+}
+
+func TestCompletingAWithdrawnProductFails(t *testing.T) {
+	e, contract := newContractEnv(t)
+	//no function removes a product, so the seller record is rewritten without it
+	e.inTx(func(stub shim.ChaincodeStubInterface) error {
+		seller := e.seller("s1")
+		seller.Products = nil
+		_, err := writeRecord(stub, seller.Id, &seller)
+		return err
+	})
+
+	//the failed call is not committed, so the contract stays pending
+	e.as("s1").mustFail(ERR_PRODUCT_UNAVAILABLE, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, e.pickupSecret(contract.Id, ""))
+	if e.contract(contract.Id).State != STATE_PENDING {
+		t.Fatalf("contract is %s", e.contract(contract.Id).State)
+	}
+	e.checkBalance("u1", 10)
+	e.checkBalance("s1", 0)
+}
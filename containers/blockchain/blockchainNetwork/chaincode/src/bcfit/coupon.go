@@ -0,0 +1,372 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//coupon discount type
+const DISCOUNT_PERCENT = "percent"
+const DISCOUNT_FIXED = "fixed"
+
+//transient map key of the coupon code of createCoupon and makePurchase
+const COUPON_CODE_KEY = "couponCode"
+
+//composite key object types of coupons, keyed by seller id and code hash, and of their redemptions per user
+const COUPON_INDEX = "coupon"
+const COUPON_USE_INDEX = "couponUse"
+
+// Coupon codes travel in the transient map, which reaches the chaincode but is not written to the ledger with the
+// transaction's arguments. A coupon is stored under the sha256 hash of its seller's id and code, so the same code
+// hashes differently for each seller and a hash found on the ledger cannot be looked up in a table of common codes.
+
+// Coupon of a seller. Only the salted hash of the code is stored, the code itself is never written to the ledger.
+type Coupon struct {
+	CodeHash      string `json:"codeHash"`
+	SellerId      string `json:"sellerId"`
+	DiscountType  string `json:"discountType"`
+	DiscountValue int    `json:"discountValue"`
+	ExpiresAt     string `json:"expiresAt,omitempty"`
+	MaxUses       int    `json:"maxUses"`
+	PerUserLimit  int    `json:"perUserLimit"`
+	Uses          int    `json:"uses"`
+	Version       int    `json:"schemaVersion"`
+}
+
+// CouponUse counts the redemptions of a coupon by one user
+type CouponUse struct {
+	UserId  string `json:"userId"`
+	Uses    int    `json:"uses"`
+	Version int    `json:"schemaVersion"`
+}
+
+type createCouponRequest struct {
+	SellerId      string `json:"sellerId" desc:"the seller's id"`
+	DiscountType  string `json:"discountType" desc:"percent or fixed"`
+	DiscountValue int    `json:"discountValue" desc:"the percentage off, or the fitcoins off the cost"`
+	ExpiresAt     string `json:"expiresAt,omitempty" desc:"RFC 3339 time after which the coupon cannot be redeemed"`
+	MaxUses       int    `json:"maxUses,omitempty" desc:"the number of redemptions across all users, 0 for no limit"`
+	PerUserLimit  int    `json:"perUserLimit,omitempty" desc:"the number of redemptions per user, 0 for no limit"`
+}
+
+func (r *createCouponRequest) validate() error {
+	if r.DiscountType == DISCOUNT_PERCENT {
+		if r.DiscountValue <= 0 || r.DiscountValue > 100 {
+			return argError(2, "discountValue", "must be between 1 and 100 for a percent discount")
+		}
+	} else if r.DiscountType == DISCOUNT_FIXED {
+		if r.DiscountValue <= 0 {
+			return argError(2, "discountValue", "must be positive")
+		}
+	} else {
+		return argError(1, "discountType", "must be percent or fixed")
+	}
+	if r.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, r.ExpiresAt); err != nil {
+			return argError(3, "expiresAt", "must be an RFC 3339 time")
+		}
+	}
+	if r.MaxUses < 0 {
+		return argError(4, "maxUses", "must not be negative")
+	}
+	if r.PerUserLimit < 0 {
+		return argError(5, "perUserLimit", "must not be negative")
+	}
+	return nil
+}
+
+type getSellerCouponsRequest struct {
+	SellerId string `json:"sellerId" desc:"the seller's id"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createCoupon",
+		Description: "Create a discount coupon for the products of a seller",
+		Request:     createCouponRequest{},
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).createCoupon,
+	})
+	registerFunction(Function{
+		Name:        "getSellerCoupons",
+		Description: "Get the coupons of a seller with their redemption counts",
+		Request:     getSellerCouponsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).getSellerCoupons,
+	})
+}
+
+// hashCouponCode returns the hex sha256 hash under which a seller's coupon code is stored
+func hashCouponCode(sellerId string, code string) string {
+	hash := sha256.Sum256([]byte(sellerId + "\x00" + code))
+	return hex.EncodeToString(hash[:])
+}
+
+// getCouponCode reads the coupon code from the transient map, "" when there is none
+func getCouponCode(stub shim.ChaincodeStubInterface) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", err
+	}
+	return string(transient[COUPON_CODE_KEY]), nil
+}
+
+// ============================================================================================================================
+// Create coupon
+// Inputs - sellerId, discountType, discountValue, expiresAt (optional), maxUses (optional), perUserLimit (optional),
+// and the code in the transient map
+// ============================================================================================================================
+func (t *SimpleChaincode) createCoupon(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request createCouponRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get seller
+	seller, err := loadSeller(stub, request.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(seller.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//refuse codes the seller already uses
+	code, err := getCouponCode(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if code == "" {
+		return errorResponse(newError(ERR_INVALID_ARGUMENT, "transient "+COUPON_CODE_KEY+" is required").
+			withDetail("name", COUPON_CODE_KEY))
+	}
+	codeHash := hashCouponCode(seller.Id, code)
+	key, err := stub.CreateCompositeKey(COUPON_INDEX, []string{seller.Id, codeHash})
+	if err != nil {
+		return errorResponse(err)
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingAsBytes != nil {
+		return errorResponse(newError(ERR_ALREADY_EXISTS, "Coupon already exists").
+			withDetail("sellerId", seller.Id).
+			withDetail("codeHash", codeHash))
+	}
+
+	//create coupon
+	var coupon Coupon
+	coupon.CodeHash = codeHash
+	coupon.SellerId = seller.Id
+	coupon.DiscountType = request.DiscountType
+	coupon.DiscountValue = request.DiscountValue
+	if request.ExpiresAt != "" {
+		expiresAt, _ := time.Parse(time.RFC3339, request.ExpiresAt)
+		coupon.ExpiresAt = expiresAt.UTC().Format(TIMESTAMP_FORMAT)
+	}
+	coupon.MaxUses = request.MaxUses
+	coupon.PerUserLimit = request.PerUserLimit
+
+	//store coupon
+	couponAsBytes, err := writeRecord(stub, key, &coupon)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(couponAsBytes)
+}
+
+// ============================================================================================================================
+// Get seller coupons
+// Inputs - sellerId
+// ============================================================================================================================
+func (t *SimpleChaincode) getSellerCoupons(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getSellerCouponsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(COUPON_INDEX, []string{request.SellerId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	coupons := []Coupon{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var coupon Coupon
+		err = decodeStrict(aKeyValue.Value, &coupon)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not a coupon: "+err.Error()))
+		}
+		coupons = append(coupons, coupon)
+	}
+
+	couponsAsBytes, _ := json.Marshal(coupons)
+	return shim.Success(couponsAsBytes)
+}
+
+// ============================================================================================================================
+// Redeem coupon - check a coupon code for a purchase, count the redemption and return the discount off cost
+// ============================================================================================================================
+func redeemCoupon(stub shim.ChaincodeStubInterface, sellerId string, userId string, code string, cost int) (Coupon, int, error) {
+	codeHash := hashCouponCode(sellerId, code)
+	coupon, key, err := loadCoupon(stub, sellerId, codeHash)
+	if err != nil {
+		return coupon, 0, err
+	}
+
+	//check expiry against the transaction time, the same on every peer
+	if coupon.ExpiresAt != "" {
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return coupon, 0, err
+		}
+		if txTime.Format(TIMESTAMP_FORMAT) > coupon.ExpiresAt {
+			return coupon, 0, newError(ERR_COUPON_EXPIRED, "Coupon expired").
+				withDetail("sellerId", sellerId).
+				withDetail("expiresAt", coupon.ExpiresAt)
+		}
+	}
+
+	//check usage limits
+	if coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses {
+		return coupon, 0, newError(ERR_COUPON_EXHAUSTED, "Coupon has no redemptions left").
+			withDetail("sellerId", sellerId).
+			withDetail("maxUses", coupon.MaxUses)
+	}
+	couponUse, useKey, err := loadCouponUse(stub, sellerId, codeHash, userId)
+	if err != nil {
+		return coupon, 0, err
+	}
+	if coupon.PerUserLimit > 0 && couponUse.Uses >= coupon.PerUserLimit {
+		return coupon, 0, newError(ERR_COUPON_EXHAUSTED, "Coupon redemption limit reached for user").
+			withDetail("sellerId", sellerId).
+			withDetail("userId", userId).
+			withDetail("perUserLimit", coupon.PerUserLimit)
+	}
+
+	//count the redemption
+	coupon.Uses++
+	couponUse.Uses++
+	_, err = writeRecord(stub, key, &coupon)
+	if err != nil {
+		return coupon, 0, err
+	}
+	_, err = writeRecord(stub, useKey, &couponUse)
+	if err != nil {
+		return coupon, 0, err
+	}
+
+	//calculate discount, never more than the cost
+	discount := coupon.DiscountValue
+	if coupon.DiscountType == DISCOUNT_PERCENT {
+		discount = cost * coupon.DiscountValue / 100
+	}
+	if discount > cost {
+		discount = cost
+	}
+	return coupon, discount, nil
+}
+
+// ============================================================================================================================
+// Release coupon - give back the redemption of a contract that is declined
+// ============================================================================================================================
+func releaseCoupon(stub shim.ChaincodeStubInterface, contract Contract) error {
+	if contract.CouponHash == "" {
+		return nil
+	}
+	coupon, key, err := loadCoupon(stub, contract.SellerId, contract.CouponHash)
+	if err != nil {
+		return err
+	}
+	couponUse, useKey, err := loadCouponUse(stub, contract.SellerId, contract.CouponHash, contract.UserId)
+	if err != nil {
+		return err
+	}
+	if coupon.Uses > 0 {
+		coupon.Uses--
+	}
+	if couponUse.Uses > 0 {
+		couponUse.Uses--
+	}
+	_, err = writeRecord(stub, key, &coupon)
+	if err != nil {
+		return err
+	}
+	_, err = writeRecord(stub, useKey, &couponUse)
+	return err
+}
+
+// loadCoupon reads a coupon by seller and code hash, returning its key
+func loadCoupon(stub shim.ChaincodeStubInterface, sellerId string, codeHash string) (Coupon, string, error) {
+	var coupon Coupon
+	key, err := stub.CreateCompositeKey(COUPON_INDEX, []string{sellerId, codeHash})
+	if err != nil {
+		return coupon, "", err
+	}
+	couponAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return coupon, "", err
+	}
+	if couponAsBytes == nil {
+		return coupon, "", newError(ERR_COUPON_NOT_FOUND, "Coupon not found").withDetail("sellerId", sellerId)
+	}
+	err = decodeStrict(couponAsBytes, &coupon)
+	if err != nil {
+		return coupon, "", corruptRecordError(key, "is not a coupon: "+err.Error())
+	}
+	return coupon, key, nil
+}
+
+// loadCouponUse reads the redemptions of a coupon by a user, returning its key
+func loadCouponUse(stub shim.ChaincodeStubInterface, sellerId string, codeHash string, userId string) (CouponUse, string, error) {
+	couponUse := CouponUse{UserId: userId}
+	key, err := stub.CreateCompositeKey(COUPON_USE_INDEX, []string{sellerId, codeHash, userId})
+	if err != nil {
+		return couponUse, "", err
+	}
+	couponUseAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return couponUse, "", err
+	}
+	if couponUseAsBytes != nil {
+		err = decodeStrict(couponUseAsBytes, &couponUse)
+		if err != nil {
+			return couponUse, "", corruptRecordError(key, "is not a coupon redemption count: "+err.Error())
+		}
+	}
+	return couponUse, key, nil
+}
@@ -0,0 +1,96 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCouponCodesStayOffTheLedger(t *testing.T) {
+	e := newTestEnv(t)
+	e.createSeller("s1", "", 0, 0)
+	e.createSeller("s2", "", 0, 0)
+
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "createCoupon", "s1", DISCOUNT_PERCENT, "20")
+	e.as("s1").with(COUPON_CODE_KEY, "SPRING").mustInvoke(nil, "createCoupon", "s1", DISCOUNT_PERCENT, "20")
+	e.as("s1").with(COUPON_CODE_KEY, "SPRING").mustFail(ERR_ALREADY_EXISTS, "createCoupon", "s1", DISCOUNT_FIXED, "5")
+	e.as("s2").with(COUPON_CODE_KEY, "SPRING").mustInvoke(nil, "createCoupon", "s2", DISCOUNT_FIXED, "5")
+	e.as("s1").with(COUPON_CODE_KEY, "BIG").mustFail(ERR_INVALID_ARGUMENT, "createCoupon", "s1", DISCOUNT_PERCENT, "101")
+
+	var coupons []Coupon
+	e.as("s1").mustInvoke(&coupons, "getSellerCoupons", "s1")
+	if len(coupons) != 1 || coupons[0].DiscountValue != 20 {
+		t.Fatalf("coupons of s1 are %+v", coupons)
+	}
+	//the same code is stored under a different hash for each seller
+	e.as("s2").mustInvoke(&coupons, "getSellerCoupons", "s2")
+	if len(coupons) != 1 || coupons[0].CodeHash == hashCouponCode("s1", "SPRING") {
+		t.Fatalf("coupons of s2 are %+v", coupons)
+	}
+
+	for key, value := range e.stub.State {
+		if strings.Contains(key, "SPRING") || strings.Contains(string(value), "SPRING") {
+			t.Fatalf("coupon code stored under %q", key)
+		}
+	}
+}
+
+func TestCouponRedemption(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 5000)
+	e.createUser("u2", 5000)
+	e.createSeller("s1", "p1", 10, 10)
+	e.as("s1").with(COUPON_CODE_KEY, "SPRING").mustInvoke(nil, "createCoupon", "s1", DISCOUNT_PERCENT, "25", "", "2", "1")
+
+	var contract Contract
+	e.as("u1").with(COUPON_CODE_KEY, "SPRING").mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "2")
+	if contract.Discount != 5 || contract.Cost != 15 {
+		t.Fatalf("discounted contract is %+v", contract)
+	}
+	e.as("u1").with(COUPON_CODE_KEY, "SPRING").mustFail(ERR_COUPON_EXHAUSTED, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("u1").with(COUPON_CODE_KEY, "AUTUMN").mustFail(ERR_COUPON_NOT_FOUND, "makePurchase", "u1", "s1", "p1", "1")
+
+	//a declined contract gives its redemption back
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_DECLINED)
+	e.as("u1").with(COUPON_CODE_KEY, "SPRING").mustInvoke(nil, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("u2").with(COUPON_CODE_KEY, "SPRING").mustInvoke(nil, "makePurchase", "u2", "s1", "p1", "1")
+
+	//the coupon has no redemptions left for anyone
+	e.createUser("u3", 5000)
+	e.as("u3").with(COUPON_CODE_KEY, "SPRING").mustFail(ERR_COUPON_EXHAUSTED, "makePurchase", "u3", "s1", "p1", "1")
+}
+
+func TestExpiredCouponsAreRefused(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 5000)
+	e.createSeller("s1", "p1", 10, 10)
+	e.as("s1").with(COUPON_CODE_KEY, "TODAY").mustInvoke(nil, "createCoupon", "s1", DISCOUNT_FIXED, "30", "2018-03-01T23:59:59Z")
+
+	//a fixed discount is never more than the cost
+	var contract Contract
+	e.as("u1").with(COUPON_CODE_KEY, "TODAY").mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "1")
+	if contract.Discount != 10 || contract.Cost != 0 {
+		t.Fatalf("discounted contract is %+v", contract)
+	}
+	e.advance(24 * time.Hour)
+	e.as("u1").with(COUPON_CODE_KEY, "TODAY").mustFail(ERR_COUPON_EXPIRED, "makePurchase", "u1", "s1", "p1", "1")
+}
@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//error codes, part of the chaincode API and must never change meaning
+const ERR_UNKNOWN_FUNCTION = "UNKNOWN_FUNCTION"
+const ERR_INVALID_ARGUMENT_COUNT = "INVALID_ARGUMENT_COUNT"
+const ERR_INVALID_ARGUMENT = "INVALID_ARGUMENT"
+const ERR_RECORD_NOT_FOUND = "RECORD_NOT_FOUND"
+const ERR_MEMBER_NOT_FOUND = "MEMBER_NOT_FOUND"
+const ERR_CONTRACT_NOT_FOUND = "CONTRACT_NOT_FOUND"
+const ERR_WRONG_RECORD_TYPE = "WRONG_RECORD_TYPE"
+const ERR_CORRUPT_RECORD = "CORRUPT_RECORD"
+const ERR_UNSUPPORTED_SCHEMA = "UNSUPPORTED_SCHEMA_VERSION"
+const ERR_PRODUCT_NOT_FOUND = "PRODUCT_NOT_FOUND"
+const ERR_PRODUCT_UNAVAILABLE = "PRODUCT_UNAVAILABLE"
+const ERR_INSUFFICIENT_FUNDS = "INSUFFICIENT_FUNDS"
+const ERR_UNAUTHORIZED = "UNAUTHORIZED"
+const ERR_INVALID_STATE = "INVALID_STATE"
+const ERR_ALREADY_EXISTS = "ALREADY_EXISTS"
+const ERR_MEMBER_INACTIVE = "MEMBER_INACTIVE"
+const ERR_MEMBER_FROZEN = "MEMBER_FROZEN"
+const ERR_COUPON_NOT_FOUND = "COUPON_NOT_FOUND"
+const ERR_COUPON_EXPIRED = "COUPON_EXPIRED"
+const ERR_COUPON_EXHAUSTED = "COUPON_EXHAUSTED"
+const ERR_AUCTION_NOT_FOUND = "AUCTION_NOT_FOUND"
+const ERR_AUCTION_CLOSED = "AUCTION_CLOSED"
+const ERR_BID_TOO_LOW = "BID_TOO_LOW"
+const ERR_RAFFLE_NOT_FOUND = "RAFFLE_NOT_FOUND"
+const ERR_RAFFLE_CLOSED = "RAFFLE_CLOSED"
+const ERR_BADGE_NOT_FOUND = "BADGE_NOT_FOUND"
+const ERR_TEAM_NOT_FOUND = "TEAM_NOT_FOUND"
+const ERR_CHALLENGE_NOT_FOUND = "CHALLENGE_NOT_FOUND"
+const ERR_SEASON_NOT_FOUND = "SEASON_NOT_FOUND"
+const ERR_PAYOUT_NOT_FOUND = "PAYOUT_NOT_FOUND"
+const ERR_INVALID_PICKUP_SECRET = "INVALID_PICKUP_SECRET"
+const ERR_RATE_LIMITED = "RATE_LIMITED"
+const ERR_IDEMPOTENCY_CONFLICT = "IDEMPOTENCY_CONFLICT"
+const ERR_ACTIVITY_TYPE_NOT_FOUND = "ACTIVITY_TYPE_NOT_FOUND"
+const ERR_INTERNAL = "INTERNAL_ERROR"
+
+// ChaincodeError is the error envelope returned, as JSON, in the message of every failed call
+type ChaincodeError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+// newError creates an error with a stable code and a human readable message
+func newError(code string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: message}
+}
+
+// withDetail adds a detail to the error and returns it
+func (e *ChaincodeError) withDetail(key string, value interface{}) *ChaincodeError {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// argCountError reports a call with the wrong number of arguments
+func argCountError(received int) *ChaincodeError {
+	return newError(ERR_INVALID_ARGUMENT_COUNT, "Incorrect number of arguments").withDetail("received", received)
+}
+
+// argError reports an invalid argument by its index and name
+func argError(index int, name string, message string) *ChaincodeError {
+	return newError(ERR_INVALID_ARGUMENT, "argument "+name+" "+message).
+		withDetail("argIndex", index).
+		withDetail("argName", name)
+}
+
+// ============================================================================================================================
+// errorResponse - turn an error into a failed response carrying the JSON error envelope
+// Errors that are not ChaincodeErrors come from the ledger or encoding and are reported as internal errors
+// ============================================================================================================================
+func errorResponse(err error) pb.Response {
+	chaincodeError, ok := err.(*ChaincodeError)
+	if !ok {
+		chaincodeError = newError(ERR_INTERNAL, err.Error())
+	}
+	errorAsBytes, marshalErr := json.Marshal(chaincodeError)
+	if marshalErr != nil {
+		errorAsBytes, _ = json.Marshal(newError(ERR_INTERNAL, chaincodeError.Message))
+	}
+	return shim.Error(string(errorAsBytes))
+}
+
+// productNotFoundError reports a product missing from a seller's inventory
+func productNotFoundError(sellerId string, productId string) *ChaincodeError {
+	return newError(ERR_PRODUCT_NOT_FOUND, "Product not found").
+		withDetail("sellerId", sellerId).
+		withDetail("productId", productId)
+}
+
+// insufficientFundsError reports a member whose balance does not cover a cost
+func insufficientFundsError(memberId string, balance int, cost int) *ChaincodeError {
+	return newError(ERR_INSUFFICIENT_FUNDS, "Insufficient funds").
+		withDetail("memberId", memberId).
+		withDetail("balance", balance).
+		withDetail("cost", cost)
+}
@@ -0,0 +1,74 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestErrorResponseCarriesTheEnvelope(t *testing.T) {
+	response := errorResponse(insufficientFundsError("u1", 3, 5))
+	if response.Status != shim.ERROR {
+		t.Fatalf("error response has status %d", response.Status)
+	}
+	var envelope ChaincodeError
+	err := json.Unmarshal([]byte(response.Message), &envelope)
+	if err != nil {
+		t.Fatalf("error message is not an envelope: %s", response.Message)
+	}
+	if envelope.Code != ERR_INSUFFICIENT_FUNDS || envelope.Details["memberId"] != "u1" || envelope.Details["balance"] != float64(3) || envelope.Details["cost"] != float64(5) {
+		t.Fatalf("envelope is %+v", envelope)
+	}
+
+	//ledger and encoding errors are internal errors
+	response = errorResponse(errors.New("GetState failed"))
+	envelope = ChaincodeError{}
+	json.Unmarshal([]byte(response.Message), &envelope)
+	if envelope.Code != ERR_INTERNAL || envelope.Message != "GetState failed" || envelope.Details != nil {
+		t.Fatalf("internal error envelope is %+v", envelope)
+	}
+}
+
+func TestFailedCallsReportCodeAndDetails(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 300)
+	e.createSeller("s1", "p1", 5, 2)
+
+	failure := e.as("u1").mustFail(ERR_INSUFFICIENT_FUNDS, "makePurchase", "u1", "s1", "p1", "2")
+	if failure.Details["balance"] != float64(3) || failure.Details["cost"] != float64(4) {
+		t.Fatalf("insufficient funds details are %+v", failure.Details)
+	}
+	failure = e.as("u1").mustFail(ERR_PRODUCT_NOT_FOUND, "makePurchase", "u1", "s1", "p2", "1")
+	if failure.Details["productId"] != "p2" {
+		t.Fatalf("product not found details are %+v", failure.Details)
+	}
+	failure = e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "makePurchase", "u1", "s1", "p1", "two")
+	if failure.Details["argIndex"] != float64(3) || failure.Details["argName"] != "quantity" {
+		t.Fatalf("invalid argument details are %+v", failure.Details)
+	}
+	failure = e.as("u1").mustFail(ERR_INVALID_ARGUMENT_COUNT, "makePurchase", "u1", "s1")
+	if failure.Details["received"] != float64(2) {
+		t.Fatalf("argument count details are %+v", failure.Details)
+	}
+}
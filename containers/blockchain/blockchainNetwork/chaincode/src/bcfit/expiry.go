@@ -0,0 +1,737 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//key of the expiry policy applied to newly minted fitcoins
+const EXPIRY_POLICY_KEY = "expiryPolicy"
+
+//composite key object types of coin batches, keyed by member id, mint time and batch id, of seasons, keyed by
+//season id, and of the final balances archived by a season rollover, keyed by season and user id
+const COIN_BATCH_INDEX = "coinBatch"
+const SEASON_INDEX = "season"
+const SEASON_BALANCE_INDEX = "seasonBalance"
+
+// Every mint to a member is tracked as a coin batch holding the mint time and, when an expiry policy is
+// set, the time the batch expires. Spending consumes the oldest coins first: coins without a batch, which
+// were minted before batches existed or received from other members, then the batches by mint time.
+// Expired coins stay spendable until expireBalances burns them. Coins escrowed by an auction keep their
+// batches and get them back when refunded. rolloverSeason ends an event: it archives the final balance
+// of every user, burns their coins and gives back the carried over percentage as a new batch.
+
+// ExpiryPolicy applied to newly minted fitcoins
+type ExpiryPolicy struct {
+	LifetimeHours int      `json:"lifetimeHours"`
+	UpdatedAt     string   `json:"updatedAt"`
+	UpdatedBy     Identity `json:"updatedBy"`
+	Version       int      `json:"schemaVersion"`
+}
+
+// CoinBatch of fitcoins minted to a member by one transaction, Amount is what is left of it
+type CoinBatch struct {
+	Id        string `json:"id"`
+	MemberId  string `json:"memberId"`
+	MintedAt  string `json:"mintedAt"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Amount    int    `json:"amount"`
+	Version   int    `json:"schemaVersion"`
+}
+
+// CoinSlice of a batch taken by a spend
+type CoinSlice struct {
+	BatchId   string `json:"batchId"`
+	MintedAt  string `json:"mintedAt"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Amount    int    `json:"amount"`
+}
+
+// Season ended by a rollover
+type Season struct {
+	Id               string `json:"id"`
+	EndedAt          string `json:"endedAt"`
+	CarryOverPercent int    `json:"carryOverPercent"`
+	Users            int    `json:"users"`
+	FinalBalances    int    `json:"finalBalances"`
+	CarriedOver      int    `json:"carriedOver"`
+	Version          int    `json:"schemaVersion"`
+}
+
+// SeasonBalance of a user archived by a season rollover
+type SeasonBalance struct {
+	SeasonId     string `json:"seasonId"`
+	UserId       string `json:"userId"`
+	FinalBalance int    `json:"finalBalance"`
+	CarriedOver  int    `json:"carriedOver"`
+	Version      int    `json:"schemaVersion"`
+}
+
+type setExpiryPolicyRequest struct {
+	LifetimeHours int `json:"lifetimeHours" desc:"hours after minting that fitcoins expire, 0 for never"`
+}
+
+func (r *setExpiryPolicyRequest) validate() error {
+	if r.LifetimeHours < 0 {
+		return argError(0, "lifetimeHours", "must not be negative")
+	}
+	return nil
+}
+
+type getCoinBatchesRequest struct {
+	MemberId string `json:"memberId" desc:"the user's or seller's id"`
+}
+
+type rolloverSeasonRequest struct {
+	SeasonId         string `json:"seasonId" desc:"the id of the season being ended"`
+	CarryOverPercent int    `json:"carryOverPercent,omitempty" desc:"the percentage of each user's balance kept for the next season, 0 by default"`
+}
+
+func (r *rolloverSeasonRequest) validate() error {
+	if r.CarryOverPercent < 0 || r.CarryOverPercent > 100 {
+		return argError(1, "carryOverPercent", "must be between 0 and 100")
+	}
+	return nil
+}
+
+type getSeasonRequest struct {
+	SeasonId string `json:"seasonId" desc:"the season id"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "setExpiryPolicy",
+		Description: "Set the lifetime of fitcoins minted from now on",
+		Request:     setExpiryPolicyRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).setExpiryPolicy,
+	})
+	registerFunction(Function{
+		Name:        "getExpiryPolicy",
+		Description: "Get the lifetime of newly minted fitcoins",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getExpiryPolicy,
+	})
+	registerFunction(Function{
+		Name:        "getCoinBatches",
+		Description: "Get the coin batches making up a member's balance, oldest first",
+		Request:     getCoinBatchesRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getCoinBatches,
+	})
+	registerFunction(Function{
+		Name:        "expireBalances",
+		Description: "Burn every expired coin batch",
+		Request:     emptyRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).expireBalances,
+	})
+	registerFunction(Function{
+		Name:        "rolloverSeason",
+		Description: "End a season, archiving the users' final balances and burning all but the carried over percentage",
+		Request:     rolloverSeasonRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).rolloverSeason,
+	})
+	registerFunction(Function{
+		Name:        "getSeason",
+		Description: "Get a season with the archived final balances",
+		Request:     getSeasonRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getSeason,
+	})
+}
+
+// ============================================================================================================================
+// Set expiry policy
+// Inputs - lifetimeHours
+// ============================================================================================================================
+func (t *SimpleChaincode) setExpiryPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request setExpiryPolicyRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	admin, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var policy ExpiryPolicy
+	policy.LifetimeHours = request.LifetimeHours
+	policy.UpdatedAt = txTime.Format(TIMESTAMP_FORMAT)
+	policy.UpdatedBy = admin
+
+	policyAsBytes, err := writeRecord(stub, EXPIRY_POLICY_KEY, &policy)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================================================================================
+// Get expiry policy
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) getExpiryPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	policy, err := readExpiryPolicy(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	policyAsBytes, _ := json.Marshal(policy)
+	return shim.Success(policyAsBytes)
+}
+
+// ============================================================================================================================
+// Get coin batches
+// Inputs - memberId
+// ============================================================================================================================
+func (t *SimpleChaincode) getCoinBatches(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getCoinBatchesRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	_, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	batches, _, err := readCoinBatches(stub, member.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//return the balance with the coins not in a batch and the Batches
+	type ReturnBatches struct {
+		MemberId        string      `json:"memberId"`
+		FitcoinsBalance int         `json:"fitcoinsBalance"`
+		Untracked       int         `json:"untracked"`
+		Batches         []CoinBatch `json:"batches"`
+	}
+	var returnBatches ReturnBatches
+	returnBatches.MemberId = member.Id
+	returnBatches.FitcoinsBalance = member.FitcoinsBalance
+	returnBatches.Untracked = untrackedCoins(*member, batches)
+	returnBatches.Batches = batches
+
+	returnBatchesBytes, _ := json.Marshal(returnBatches)
+	return shim.Success(returnBatchesBytes)
+}
+
+// ============================================================================================================================
+// Expire balances - burn the coin batches whose expiry time has passed
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) expireBalances(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	now := txTime.Format(TIMESTAMP_FORMAT)
+
+	//collect the expired coins of every member, batches are listed per member
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(COIN_BATCH_INDEX, []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	type ExpiredCoins struct {
+		MemberId string `json:"memberId"`
+		Amount   int    `json:"amount"`
+	}
+	type ExpiryResult struct {
+		Expired int            `json:"expired"`
+		Members []ExpiredCoins `json:"members"`
+	}
+	result := ExpiryResult{Members: []ExpiredCoins{}}
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var batch CoinBatch
+		err = decodeStrict(aKeyValue.Value, &batch)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not a coin batch: "+err.Error()))
+		}
+		if batch.ExpiresAt == "" || batch.ExpiresAt > now {
+			continue
+		}
+		err = stub.DelState(aKeyValue.Key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		last := len(result.Members) - 1
+		if last < 0 || result.Members[last].MemberId != batch.MemberId {
+			result.Members = append(result.Members, ExpiredCoins{MemberId: batch.MemberId})
+			last++
+		}
+		result.Members[last].Amount = result.Members[last].Amount + batch.Amount
+	}
+
+	//burn the expired coins, frozen and closed members included
+	for _, expired := range result.Members {
+		record, member, err := loadMember(stub, expired.MemberId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		amount := expired.Amount
+		if amount > member.FitcoinsBalance {
+			return errorResponse(corruptRecordError(member.Id, "holds fewer fitcoins than its coin batches"))
+		}
+		member.FitcoinsBalance = member.FitcoinsBalance - amount
+		_, err = writeRecord(stub, member.Id, record)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = writeAuditRecord(stub, ACTION_EXPIRE, member.Id, amount, "coins expired")
+		if err != nil {
+			return errorResponse(err)
+		}
+		result.Expired = result.Expired + amount
+	}
+
+	//one supply record for the whole sweep
+	err = recordSupply(stub, 0, result.Expired)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// ============================================================================================================================
+// Rollover season - archive the final balance of every user and burn all but the carried over percentage,
+// which is given back as a new batch. Sellers keep their balances.
+// Inputs - seasonId, carryOverPercent (optional)
+// ============================================================================================================================
+func (t *SimpleChaincode) rolloverSeason(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request rolloverSeasonRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//refuse season ids in use
+	key, err := stub.CreateCompositeKey(SEASON_INDEX, []string{request.SeasonId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingAsBytes != nil {
+		return errorResponse(newError(ERR_ALREADY_EXISTS, "Season "+request.SeasonId+" already exists").withDetail("seasonId", request.SeasonId))
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var season Season
+	season.Id = request.SeasonId
+	season.EndedAt = txTime.Format(TIMESTAMP_FORMAT)
+	season.CarryOverPercent = request.CarryOverPercent
+
+	err = forEachMember(stub, func(record versioned, member *Member) error {
+		if member.Type != TYPE_USER {
+			return nil
+		}
+
+		//archive the final balance
+		var seasonBalance SeasonBalance
+		seasonBalance.SeasonId = season.Id
+		seasonBalance.UserId = member.Id
+		seasonBalance.FinalBalance = member.FitcoinsBalance
+		seasonBalance.CarriedOver = member.FitcoinsBalance * season.CarryOverPercent / 100
+		balanceKey, err := stub.CreateCompositeKey(SEASON_BALANCE_INDEX, []string{season.Id, member.Id})
+		if err != nil {
+			return err
+		}
+		_, err = writeRecord(stub, balanceKey, &seasonBalance)
+		if err != nil {
+			return err
+		}
+		season.Users++
+		season.FinalBalances = season.FinalBalances + seasonBalance.FinalBalance
+		season.CarriedOver = season.CarriedOver + seasonBalance.CarriedOver
+
+		//replace the user's batches with a new batch of the carried over coins
+		_, keys, err := readCoinBatches(stub, member.Id)
+		if err != nil {
+			return err
+		}
+		for _, batchKey := range keys {
+			err = stub.DelState(batchKey)
+			if err != nil {
+				return err
+			}
+		}
+		member.FitcoinsBalance = 0
+		err = issueCoins(stub, member, seasonBalance.CarriedOver)
+		if err != nil {
+			return err
+		}
+		_, err = writeRecord(stub, member.Id, record)
+		if err != nil {
+			return err
+		}
+		burned := seasonBalance.FinalBalance - seasonBalance.CarriedOver
+		if burned == 0 {
+			return nil
+		}
+		return writeAuditRecord(stub, ACTION_ROLLOVER, member.Id, burned, "season "+season.Id+" ended")
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//one supply record for the whole rollover
+	err = recordSupply(stub, 0, season.FinalBalances-season.CarriedOver)
+	if err != nil {
+		return errorResponse(err)
+	}
+	seasonAsBytes, err := writeRecord(stub, key, &season)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(seasonAsBytes)
+}
+
+// ============================================================================================================================
+// Get season
+// Inputs - seasonId
+// ============================================================================================================================
+func (t *SimpleChaincode) getSeason(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getSeasonRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	key, err := stub.CreateCompositeKey(SEASON_INDEX, []string{request.SeasonId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	seasonAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if seasonAsBytes == nil {
+		return errorResponse(newError(ERR_SEASON_NOT_FOUND, "Season "+request.SeasonId+" not found").withDetail("seasonId", request.SeasonId))
+	}
+	var season Season
+	err = decodeStrict(seasonAsBytes, &season)
+	if err != nil {
+		return errorResponse(corruptRecordError(key, "is not a season: "+err.Error()))
+	}
+
+	//return season with the archived Balances
+	type ReturnSeason struct {
+		Season
+		Balances []SeasonBalance `json:"balances"`
+	}
+	var returnSeason ReturnSeason
+	returnSeason.Season = season
+	returnSeason.Balances = []SeasonBalance{}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SEASON_BALANCE_INDEX, []string{season.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var seasonBalance SeasonBalance
+		err = decodeStrict(aKeyValue.Value, &seasonBalance)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not a season balance: "+err.Error()))
+		}
+		returnSeason.Balances = append(returnSeason.Balances, seasonBalance)
+	}
+
+	returnSeasonBytes, _ := json.Marshal(returnSeason)
+	return shim.Success(returnSeasonBytes)
+}
+
+// issueCoins credits fitcoins to a member as a new batch stamped with the expiry policy, without recording
+// them in the supply
+func issueCoins(stub shim.ChaincodeStubInterface, member *Member, amount int) error {
+	if amount == 0 {
+		return nil
+	}
+	policy, err := readExpiryPolicy(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	//a transaction issuing to a member more than once adds to the batch it already wrote
+	var batch CoinBatch
+	batch.Id = stub.GetTxID()
+	batch.MemberId = member.Id
+	batch.MintedAt = txTime.Format(TIMESTAMP_FORMAT)
+	key, err := stub.CreateCompositeKey(COIN_BATCH_INDEX, []string{batch.MemberId, batch.MintedAt, batch.Id})
+	if err != nil {
+		return err
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingAsBytes != nil {
+		err = decodeStrict(existingAsBytes, &batch)
+		if err != nil {
+			return corruptRecordError(key, "is not a coin batch: "+err.Error())
+		}
+	}
+	if policy.LifetimeHours > 0 {
+		batch.ExpiresAt = txTime.Add(time.Duration(policy.LifetimeHours) * time.Hour).Format(TIMESTAMP_FORMAT)
+	}
+	batch.Amount = batch.Amount + amount
+	member.FitcoinsBalance = member.FitcoinsBalance + amount
+	return writeCoinBatch(stub, &batch)
+}
+
+// spendCoins debits fitcoins from a member, oldest first, returning the slices taken from its batches
+func spendCoins(stub shim.ChaincodeStubInterface, member *Member, amount int) ([]CoinSlice, error) {
+	if member.FitcoinsBalance < amount {
+		return nil, insufficientFundsError(member.Id, member.FitcoinsBalance, amount)
+	}
+	batches, keys, err := readCoinBatches(stub, member.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	//coins without a batch are the oldest
+	left := amount - untrackedCoins(*member, batches)
+	var slices []CoinSlice
+	for i := 0; i < len(batches) && left > 0; i++ {
+		batch := batches[i]
+		var slice CoinSlice
+		slice.BatchId = batch.Id
+		slice.MintedAt = batch.MintedAt
+		slice.ExpiresAt = batch.ExpiresAt
+		slice.Amount = batch.Amount
+		if slice.Amount > left {
+			slice.Amount = left
+		}
+		slices = append(slices, slice)
+		left = left - slice.Amount
+
+		batch.Amount = batch.Amount - slice.Amount
+		if batch.Amount == 0 {
+			err = stub.DelState(keys[i])
+		} else {
+			_, err = writeRecord(stub, keys[i], &batch)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	member.FitcoinsBalance = member.FitcoinsBalance - amount
+	return slices, nil
+}
+
+// restoreCoins credits fitcoins back to a member, returning the slices to the batches they were taken from
+func restoreCoins(stub shim.ChaincodeStubInterface, member *Member, amount int, slices []CoinSlice) error {
+	//merge slices of the same batch, each batch is written once
+	var merged []CoinSlice
+	for _, slice := range slices {
+		found := false
+		for i := range merged {
+			if merged[i].BatchId == slice.BatchId && merged[i].MintedAt == slice.MintedAt {
+				merged[i].Amount = merged[i].Amount + slice.Amount
+				found = true
+			}
+		}
+		if !found {
+			merged = append(merged, slice)
+		}
+	}
+
+	for _, slice := range merged {
+		var batch CoinBatch
+		key, err := stub.CreateCompositeKey(COIN_BATCH_INDEX, []string{member.Id, slice.MintedAt, slice.BatchId})
+		if err != nil {
+			return err
+		}
+		existingAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if existingAsBytes != nil {
+			err = decodeStrict(existingAsBytes, &batch)
+			if err != nil {
+				return corruptRecordError(key, "is not a coin batch: "+err.Error())
+			}
+		}
+		batch.Id = slice.BatchId
+		batch.MemberId = member.Id
+		batch.MintedAt = slice.MintedAt
+		batch.ExpiresAt = slice.ExpiresAt
+		batch.Amount = batch.Amount + slice.Amount
+		_, err = writeRecord(stub, key, &batch)
+		if err != nil {
+			return err
+		}
+	}
+	member.FitcoinsBalance = member.FitcoinsBalance + amount
+	return nil
+}
+
+// untrackedCoins is the part of a member's balance not held in batches
+func untrackedCoins(member Member, batches []CoinBatch) int {
+	untracked := member.FitcoinsBalance
+	for _, batch := range batches {
+		untracked = untracked - batch.Amount
+	}
+	if untracked < 0 {
+		return 0
+	}
+	return untracked
+}
+
+// readCoinBatches reads the batches of a member, oldest first, with their keys, including the batches
+// written earlier in the transaction
+func readCoinBatches(stub shim.ChaincodeStubInterface, memberId string) ([]CoinBatch, []string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(COIN_BATCH_INDEX, []string{memberId})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	values := map[string][]byte{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, aKeyValue.Key)
+		values[aKeyValue.Key] = aKeyValue.Value
+	}
+
+	//the transaction's own writes replace the ledger values, keys stay in ledger key order
+	prefix, err := stub.CreateCompositeKey(COIN_BATCH_INDEX, []string{memberId})
+	if err != nil {
+		return nil, nil, err
+	}
+	pendingKeys, pending := pendingWrites(stub, prefix)
+	for _, key := range pendingKeys {
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = pending[key]
+	}
+	sort.Strings(keys)
+
+	batches := []CoinBatch{}
+	var batchKeys []string
+	for _, key := range keys {
+		if values[key] == nil {
+			continue
+		}
+		var batch CoinBatch
+		err = decodeStrict(values[key], &batch)
+		if err != nil {
+			return nil, nil, corruptRecordError(key, "is not a coin batch: "+err.Error())
+		}
+		batches = append(batches, batch)
+		batchKeys = append(batchKeys, key)
+	}
+	return batches, batchKeys, nil
+}
+
+// writeCoinBatch stores a batch under its composite key
+func writeCoinBatch(stub shim.ChaincodeStubInterface, batch *CoinBatch) error {
+	key, err := stub.CreateCompositeKey(COIN_BATCH_INDEX, []string{batch.MemberId, batch.MintedAt, batch.Id})
+	if err != nil {
+		return err
+	}
+	_, err = writeRecord(stub, key, batch)
+	return err
+}
+
+// readExpiryPolicy reads the expiry policy, fitcoins never expire when none is set
+func readExpiryPolicy(stub shim.ChaincodeStubInterface) (ExpiryPolicy, error) {
+	var policy ExpiryPolicy
+	policyAsBytes, err := stub.GetState(EXPIRY_POLICY_KEY)
+	if err != nil || policyAsBytes == nil {
+		return policy, err
+	}
+	err = decodeStrict(policyAsBytes, &policy)
+	if err != nil {
+		return policy, corruptRecordError(EXPIRY_POLICY_KEY, "is not an expiry policy: "+err.Error())
+	}
+	return policy, nil
+}
+
+// coinBatchViolation reports a member whose batches hold more fitcoins than its balance, "" when there is none
+func coinBatchViolation(stub shim.ChaincodeStubInterface, member Member) (string, error) {
+	batches, _, err := readCoinBatches(stub, member.Id)
+	if err != nil {
+		return "", err
+	}
+	tracked := 0
+	for _, batch := range batches {
+		tracked = tracked + batch.Amount
+	}
+	if tracked > member.FitcoinsBalance {
+		return "coin batches hold " + strconv.Itoa(tracked) + " fitcoins, more than the balance", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,187 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// testCoinBatches is the result of getCoinBatches
+type testCoinBatches struct {
+	FitcoinsBalance int         `json:"fitcoinsBalance"`
+	Untracked       int         `json:"untracked"`
+	Batches         []CoinBatch `json:"batches"`
+}
+
+// coinBatches reads the coin batches of a member
+func coinBatches(e *testEnv, memberId string) testCoinBatches {
+	var batches testCoinBatches
+	e.as(memberId).mustInvoke(&batches, "getCoinBatches", memberId)
+	return batches
+}
+
+func TestCoinsAreSpentOldestFirst(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("u1", `{"id":"u1","memberType":"user","fitcoinsBalance":4,"totalSteps":400,"stepsUsedForConversion":400,"contractIds":[]}`)
+	e.createSeller("s1", "p1", 5, 6)
+	e.asAdmin().mustInvoke(nil, "setExpiryPolicy", "24")
+
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "1400")
+	e.advance(time.Hour)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "1900")
+	batches := coinBatches(e, "u1")
+	if batches.FitcoinsBalance != 19 || batches.Untracked != 4 || len(batches.Batches) != 2 || batches.Batches[0].Amount != 10 || batches.Batches[0].ExpiresAt == "" {
+		t.Fatalf("coin batches are %+v", batches)
+	}
+
+	//the untracked coins go first, then the oldest batch
+	var contract Contract
+	e.as("u1").mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "2")
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, e.pickupSecret(contract.Id, ""))
+	batches = coinBatches(e, "u1")
+	if batches.FitcoinsBalance != 7 || batches.Untracked != 0 || len(batches.Batches) != 2 || batches.Batches[0].Amount != 2 || batches.Batches[1].Amount != 5 {
+		t.Fatalf("coin batches after spending are %+v", batches)
+	}
+}
+
+func TestIssuingTwiceInATransactionMergesTheBatch(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	e.inTx(func(stub shim.ChaincodeStubInterface) error {
+		user, err := loadUser(stub, "u1")
+		if err != nil {
+			return err
+		}
+		for _, amount := range []int{3, 4} {
+			err = mintFitcoins(stub, &user.Member, amount)
+			if err != nil {
+				return err
+			}
+		}
+		//spending later in the transaction sees the batch
+		err = burnFitcoins(stub, &user.Member, 5)
+		if err != nil {
+			return err
+		}
+		_, err = writeRecord(stub, user.Id, &user)
+		return err
+	})
+
+	batches := coinBatches(e, "u1")
+	if batches.FitcoinsBalance != 2 || batches.Untracked != 0 || len(batches.Batches) != 1 || batches.Batches[0].Amount != 2 {
+		t.Fatalf("coin batches are %+v", batches)
+	}
+	var supply Supply
+	e.as("u1").mustInvoke(&supply, "getSupply")
+	if supply.Minted != 7 || supply.Burned != 5 {
+		t.Fatalf("supply is %+v", supply)
+	}
+}
+
+func TestExpiredCoinsAreBurnedBySweep(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.asAdmin().mustInvoke(nil, "setExpiryPolicy", "24")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "1000")
+	e.advance(12 * time.Hour)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "1500")
+	e.advance(13 * time.Hour)
+
+	//expired coins are spendable until the sweep
+	e.checkBalance("u1", 15)
+	type expiryResult struct {
+		Expired int `json:"expired"`
+	}
+	var result expiryResult
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "expireBalances")
+	e.asAdmin().mustInvoke(&result, "expireBalances")
+	if result.Expired != 10 {
+		t.Fatalf("expiry is %+v", result)
+	}
+	e.checkBalance("u1", 5)
+	e.asAdmin().mustInvoke(&result, "expireBalances")
+	if result.Expired != 0 {
+		t.Fatalf("second expiry is %+v", result)
+	}
+
+	var auditLog []AuditRecord
+	e.asAdmin().mustInvoke(&auditLog, "getAuditLog", "u1")
+	if len(auditLog) != 1 || auditLog[0].Action != ACTION_EXPIRE || auditLog[0].Amount != 10 {
+		t.Fatalf("audit log is %+v", auditLog)
+	}
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Burned != 10 {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
+
+func TestOutbidCoinsGetTheirBatchesBack(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+	before := coinBatches(e, "u1")
+	e.as("u1").mustInvoke(nil, "placeBid", "u1", auction.Id, "20")
+	e.as("u2").mustInvoke(nil, "placeBid", "u2", auction.Id, "30")
+
+	after := coinBatches(e, "u1")
+	if len(after.Batches) != 1 || after.Batches[0].Amount != 50 || after.Batches[0].MintedAt != before.Batches[0].MintedAt {
+		t.Fatalf("coin batches are %+v, were %+v", after, before)
+	}
+}
+
+func TestRolloverSeasonCarriesOverPartOfUserBalances(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 2000)
+	e.createUser("u2", 1100)
+	e.createSeller("s1", "", 0, 0)
+	e.asAdmin().mustInvoke(nil, "adminMint", "s1", "8", "opening")
+
+	var season Season
+	e.asAdmin().mustInvoke(&season, "rolloverSeason", "2018-spring", "50")
+	if season.Users != 2 || season.FinalBalances != 31 || season.CarriedOver != 10+5 {
+		t.Fatalf("season is %+v", season)
+	}
+	e.asAdmin().mustFail(ERR_ALREADY_EXISTS, "rolloverSeason", "2018-spring")
+	e.checkBalance("u1", 10)
+	e.checkBalance("u2", 5)
+	e.checkBalance("s1", 8)
+	if batches := coinBatches(e, "u2"); len(batches.Batches) != 1 || batches.Batches[0].Amount != 5 {
+		t.Fatalf("coin batches are %+v", batches)
+	}
+
+	var archived struct {
+		Season
+		Balances []SeasonBalance `json:"balances"`
+	}
+	e.as("u1").mustInvoke(&archived, "getSeason", "2018-spring")
+	if len(archived.Balances) != 2 || archived.Balances[0].FinalBalance != 20 || archived.Balances[1].CarriedOver != 5 {
+		t.Fatalf("archived season is %+v", archived)
+	}
+	e.as("u1").mustFail(ERR_SEASON_NOT_FOUND, "getSeason", "2018-summer")
+
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Circulating != 15+8 {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
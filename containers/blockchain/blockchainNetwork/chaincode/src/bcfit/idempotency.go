@@ -0,0 +1,167 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//transient map key of the client's idempotency key, and its longest accepted length in characters
+const IDEMPOTENCY_KEY = "idempotencyKey"
+const MAX_IDEMPOTENCY_KEY_LENGTH = 128
+
+//composite key object type of idempotent calls, keyed by MSP id, certificate subject and idempotency key
+const IDEMPOTENT_CALL_INDEX = "idempotentCall"
+
+// A client retrying an invoke call passes the same idempotency key in the transient map of every attempt.
+// The first successful call stores its result under the key, scoped to the caller's certificate, and later
+// calls with the key return that result without running the function again. Reusing a key for another
+// function or other arguments fails, so a key cannot replay the wrong result. Keys are kept for good, so
+// clients should use a new random key, such as a UUID, for each call they mean to make.
+
+// IdempotentCall is the first successful call made with an idempotency key
+type IdempotentCall struct {
+	Key       string   `json:"key"`
+	Caller    Identity `json:"caller"`
+	Function  string   `json:"function"`
+	ArgsHash  string   `json:"argsHash"`
+	TxId      string   `json:"txId"`
+	Result    string   `json:"result"`
+	CreatedAt string   `json:"createdAt"`
+	Version   int      `json:"schemaVersion"`
+}
+
+type getIdempotentCallRequest struct {
+	IdempotencyKey string `json:"idempotencyKey" desc:"the idempotency key the caller passed"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "getIdempotentCall",
+		Description: "Get the call the caller first made with an idempotency key, with its transaction id and result",
+		Request:     getIdempotentCallRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getIdempotentCall,
+	})
+}
+
+// ============================================================================================================================
+// Get idempotent call - look up the caller's call by idempotency key, its txId is the transaction that applied it
+// Inputs - idempotencyKey
+// ============================================================================================================================
+func (t *SimpleChaincode) getIdempotentCall(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getIdempotentCallRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	key, err := stub.CreateCompositeKey(IDEMPOTENT_CALL_INDEX, []string{caller.MspId, caller.Subject, request.IdempotencyKey})
+	if err != nil {
+		return errorResponse(err)
+	}
+	callAsBytes, err := getRecordBytes(stub, key, ERR_RECORD_NOT_FOUND)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(callAsBytes)
+}
+
+// readIdempotentCall returns the stored call for the transient idempotency key, which has a TxId when the call was
+// already made. Without a key it returns an empty call, a key used with other arguments fails.
+func readIdempotentCall(stub shim.ChaincodeStubInterface, function string, args []string) (IdempotentCall, error) {
+	var call IdempotentCall
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return call, err
+	}
+	idempotencyKey, ok := transient[IDEMPOTENCY_KEY]
+	if !ok {
+		return call, nil
+	}
+	if len(idempotencyKey) == 0 || utf8.RuneCount(idempotencyKey) > MAX_IDEMPOTENCY_KEY_LENGTH || !isPrintable(string(idempotencyKey)) {
+		return call, newError(ERR_INVALID_ARGUMENT, "transient "+IDEMPOTENCY_KEY+" must be 1 to "+strconv.Itoa(MAX_IDEMPOTENCY_KEY_LENGTH)+" printable characters").
+			withDetail("name", IDEMPOTENCY_KEY)
+	}
+
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return call, err
+	}
+	argsAsBytes, _ := json.Marshal(append([]string{function}, args...))
+	argsHash := sha256.Sum256(argsAsBytes)
+
+	key, err := stub.CreateCompositeKey(IDEMPOTENT_CALL_INDEX, []string{caller.MspId, caller.Subject, string(idempotencyKey)})
+	if err != nil {
+		return call, err
+	}
+	callAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return call, err
+	}
+	if callAsBytes == nil {
+		call.Key = string(idempotencyKey)
+		call.Caller = caller
+		call.Function = function
+		call.ArgsHash = hex.EncodeToString(argsHash[:])
+		return call, nil
+	}
+	err = decodeStrict(callAsBytes, &call)
+	if err != nil {
+		return call, corruptRecordError(key, "is not an idempotent call: "+err.Error())
+	}
+	if call.Function != function || call.ArgsHash != hex.EncodeToString(argsHash[:]) {
+		return call, newError(ERR_IDEMPOTENCY_CONFLICT, "Idempotency key "+call.Key+" was used for another call").
+			withDetail("idempotencyKey", call.Key).
+			withDetail("function", call.Function).
+			withDetail("txId", call.TxId)
+	}
+	return call, nil
+}
+
+// writeIdempotentCall stores the result of the first successful call made with an idempotency key
+func writeIdempotentCall(stub shim.ChaincodeStubInterface, call IdempotentCall, result []byte) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	call.TxId = stub.GetTxID()
+	call.Result = string(result)
+	call.CreatedAt = txTime.Format(TIMESTAMP_FORMAT)
+
+	key, err := stub.CreateCompositeKey(IDEMPOTENT_CALL_INDEX, []string{call.Caller.MspId, call.Caller.Subject, call.Key})
+	if err != nil {
+		return err
+	}
+	_, err = writeRecord(stub, key, &call)
+	return err
+}
@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//idempotency key of the tests
+const TEST_IDEMPOTENCY_KEY = "9b2f6c1e-5d4a-4e8b-a0c3-7f1d2e3b4a5c"
+
+func TestRetriedCallReplaysTheFirstResult(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createUser("u2", 1000)
+	e.createSeller("s1", "p1", 5, 3)
+	e.asAdmin().mustInvoke(nil, "setRateLimit", "makePurchase", "1", "60")
+
+	txId := e.nextTxId()
+	first := e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).invoke("makePurchase", "u1", "s1", "p1", "1")
+	//the replay is neither run again nor counted against the rate limit
+	replay := e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).invoke("makePurchase", "u1", "s1", "p1", "1")
+	if replay.Status != first.Status || string(replay.Payload) != string(first.Payload) {
+		t.Fatalf("replay is %d %s, first call was %d %s", replay.Status, replay.Payload, first.Status, first.Payload)
+	}
+	var contract Contract
+	json.Unmarshal(first.Payload, &contract)
+	if contractIds := e.user("u1").ContractIds; len(contractIds) != 1 || contractIds[0] != contract.Id {
+		t.Fatalf("contracts are %v", contractIds)
+	}
+	e.as("u1").mustFail(ERR_RATE_LIMITED, "makePurchase", "u1", "s1", "p1", "1")
+
+	var call IdempotentCall
+	e.as("u1").mustInvoke(&call, "getIdempotentCall", TEST_IDEMPOTENCY_KEY)
+	if call.TxId != txId || call.Function != "makePurchase" || call.Result != string(first.Payload) {
+		t.Fatalf("call is %+v", call)
+	}
+
+	//keys are scoped to the caller
+	e.as("u2").mustFail(ERR_RECORD_NOT_FOUND, "getIdempotentCall", TEST_IDEMPOTENCY_KEY)
+	e.as("u2").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustInvoke(nil, "makePurchase", "u2", "s1", "p1", "1")
+	if len(e.user("u2").ContractIds) != 1 {
+		t.Fatalf("u2 contracts are %v", e.user("u2").ContractIds)
+	}
+}
+
+func TestIdempotencyKeyCannotReplayAnotherCall(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	txId := e.nextTxId()
+	e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustInvoke(nil, "generateFitcoins", "u1", "100")
+	failure := e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustFail(ERR_IDEMPOTENCY_CONFLICT, "generateFitcoins", "u1", "200")
+	if failure.Details["txId"] != txId || failure.Details["function"] != "generateFitcoins" {
+		t.Fatalf("failure details are %v", failure.Details)
+	}
+	e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustFail(ERR_IDEMPOTENCY_CONFLICT, "deactivateMember", "u1")
+	e.checkBalance("u1", 1)
+
+	e.as("u1").with(IDEMPOTENCY_KEY, "").mustFail(ERR_INVALID_ARGUMENT, "generateFitcoins", "u1", "200")
+	e.as("u1").with(IDEMPOTENCY_KEY, strings.Repeat("k", MAX_IDEMPOTENCY_KEY_LENGTH+1)).mustFail(ERR_INVALID_ARGUMENT, "generateFitcoins", "u1", "200")
+}
+
+func TestFailedCallDoesNotUseItsIdempotencyKey(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.as("u1").mustInvoke(nil, "deactivateMember", "u1")
+
+	e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustFail(ERR_MEMBER_INACTIVE, "generateFitcoins", "u1", "100")
+	e.as("u1").mustFail(ERR_RECORD_NOT_FOUND, "getIdempotentCall", TEST_IDEMPOTENCY_KEY)
+
+	//the retry runs the call
+	e.asAdmin().mustInvoke(nil, "reactivateMember", "u1")
+	e.as("u1").with(IDEMPOTENCY_KEY, TEST_IDEMPOTENCY_KEY).mustInvoke(nil, "generateFitcoins", "u1", "100")
+	e.checkBalance("u1", 1)
+}
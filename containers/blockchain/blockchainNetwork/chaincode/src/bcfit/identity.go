@@ -0,0 +1,208 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+//certificate attribute granting the admin role, set by the CA as fitcoin.admin=true
+const ADMIN_ATTRIBUTE = "fitcoin.admin"
+
+//key of the list of admin MSP ids, whose identities are all admins, and admin identities as MSP id/certificate subject
+const ADMIN_MSPS_KEY = "adminMsps"
+
+//fixed width UTC timestamp format, timestamps in this format sort in time order
+const TIMESTAMP_FORMAT = "2006-01-02T15:04:05.000000000Z"
+
+// extension in which the Fabric CA stores the attributes of an enrollment certificate
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Identity of the client that signed the transaction proposal
+type Identity struct {
+	MspId   string `json:"mspId"`
+	Subject string `json:"subject"`
+}
+
+// ============================================================================================================================
+// Get caller - the MSP id and certificate of the transaction creator
+// ============================================================================================================================
+func getCaller(stub shim.ChaincodeStubInterface) (Identity, *x509.Certificate, error) {
+	var identity Identity
+	creatorAsBytes, err := stub.GetCreator()
+	if err != nil {
+		return identity, nil, err
+	}
+	var serializedIdentity msp.SerializedIdentity
+	err = proto.Unmarshal(creatorAsBytes, &serializedIdentity)
+	if err != nil {
+		return identity, nil, fmt.Errorf("Failed to decode creator: %s", err.Error())
+	}
+	identity.MspId = serializedIdentity.Mspid
+
+	block, _ := pem.Decode(serializedIdentity.IdBytes)
+	if block == nil {
+		return identity, nil, newError(ERR_UNAUTHORIZED, "Creator has no certificate").withDetail("mspId", identity.MspId)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return identity, nil, fmt.Errorf("Failed to parse creator certificate: %s", err.Error())
+	}
+	identity.Subject = certificate.Subject.CommonName
+	return identity, certificate, nil
+}
+
+// certificateAttribute reads an attribute the Fabric CA embedded in an enrollment certificate, "" when it has none
+func certificateAttribute(certificate *x509.Certificate, name string) string {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(attributesOID) {
+			continue
+		}
+		var attributes struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if json.Unmarshal(extension.Value, &attributes) != nil {
+			return ""
+		}
+		return attributes.Attrs[name]
+	}
+	return ""
+}
+
+// ============================================================================================================================
+// Check admin - the caller must belong to an admin MSP, be a listed admin identity or hold the admin certificate attribute
+// ============================================================================================================================
+func checkAdmin(stub shim.ChaincodeStubInterface) (Identity, error) {
+	identity, certificate, err := getCaller(stub)
+	if err != nil {
+		return identity, err
+	}
+	if certificateAttribute(certificate, ADMIN_ATTRIBUTE) == "true" {
+		return identity, nil
+	}
+	adminMsps, err := getAdminMsps(stub)
+	if err != nil {
+		return identity, err
+	}
+	for _, admin := range adminMsps {
+		if admin == identity.MspId || admin == identity.MspId+"/"+identity.Subject {
+			return identity, nil
+		}
+	}
+	return identity, newError(ERR_UNAUTHORIZED, "Caller is not an admin").
+		withDetail("mspId", identity.MspId).
+		withDetail("subject", identity.Subject)
+}
+
+// ============================================================================================================================
+// Check role - the caller must hold the function's role. A member role binds the caller to the member id in the
+// first field of the request: the app enrolls each member with its member id as certificate subject, so only that
+// member may act for it. The user and seller roles also require the member to be of that type, the member or
+// admin role lets admins act for any member too.
+// ============================================================================================================================
+func checkRole(stub shim.ChaincodeStubInterface, f Function, args []string) error {
+	if f.Role == ROLE_ANY {
+		return nil
+	}
+	if f.Role == ROLE_ADMIN {
+		_, err := checkAdmin(stub)
+		return err
+	}
+	if f.Role == ROLE_MEMBER_OR_ADMIN {
+		_, err := checkAdmin(stub)
+		if err == nil {
+			return nil
+		}
+		if chaincodeError, ok := err.(*ChaincodeError); !ok || chaincodeError.Code != ERR_UNAUTHORIZED {
+			return err
+		}
+	}
+
+	//read the member id the same way the handler will
+	request := reflect.New(reflect.TypeOf(f.Request))
+	err := decodeRequest(args, request.Interface())
+	if err != nil {
+		return err
+	}
+	memberId := request.Elem().Field(0).String()
+
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if caller.Subject != memberId {
+		return newError(ERR_UNAUTHORIZED, "Caller cannot act for member "+memberId).
+			withDetail("memberId", memberId).
+			withDetail("mspId", caller.MspId).
+			withDetail("subject", caller.Subject)
+	}
+	memberType, ok := roleMemberTypes[f.Role]
+	if !ok {
+		return nil
+	}
+	_, member, err := loadMember(stub, memberId)
+	if err != nil {
+		return err
+	}
+	if member.Type != memberType {
+		return newError(ERR_UNAUTHORIZED, "Only a "+memberType+" can call "+f.Name).
+			withDetail("memberId", memberId).
+			withDetail("memberType", member.Type)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Get the admin MSP ids and admin identities
+// ============================================================================================================================
+func getAdminMsps(stub shim.ChaincodeStubInterface) ([]string, error) {
+	adminMspsBytes, err := stub.GetState(ADMIN_MSPS_KEY)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get admin MSPs.")
+	}
+	var adminMsps []string
+	if adminMspsBytes == nil {
+		return adminMsps, nil
+	}
+	err = decodeStrict(adminMspsBytes, &adminMsps)
+	if err != nil {
+		return nil, fmt.Errorf("Admin MSP list is corrupt: %s", err.Error())
+	}
+	return adminMsps, nil
+}
+
+// getTxTime reads the timestamp of the transaction, the same on every endorsing peer
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
@@ -0,0 +1,121 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The loaders read a record from the ledger, upgrade it to the current schema and decode it.
+// A missing key, a key holding another kind of record and a record that cannot be decoded
+// are reported with distinct error codes:
+//
+//	MEMBER_NOT_FOUND / CONTRACT_NOT_FOUND - nothing is stored under the id
+//	WRONG_RECORD_TYPE                     - the id holds another kind of record, e.g. a seller where a user is expected
+//	CORRUPT_RECORD                        - the stored record does not decode in the current schema
+
+// ============================================================================================================================
+// Load user
+// ============================================================================================================================
+func loadUser(stub shim.ChaincodeStubInterface, id string) (User, error) {
+	var user User
+	err := loadRecord(stub, id, TYPE_USER, &user)
+	return user, err
+}
+
+// ============================================================================================================================
+// Load seller
+// ============================================================================================================================
+func loadSeller(stub shim.ChaincodeStubInterface, id string) (Seller, error) {
+	var seller Seller
+	err := loadRecord(stub, id, TYPE_SELLER, &seller)
+	return seller, err
+}
+
+// ============================================================================================================================
+// Load contract
+// ============================================================================================================================
+func loadContract(stub shim.ChaincodeStubInterface, id string) (Contract, error) {
+	var contract Contract
+	err := loadRecord(stub, id, KIND_CONTRACT, &contract)
+	return contract, err
+}
+
+// ============================================================================================================================
+// Load member - a user or seller, returning the record and its member fields
+// ============================================================================================================================
+func loadMember(stub shim.ChaincodeStubInterface, id string) (versioned, *Member, error) {
+	recordAsBytes, err := getRecordBytes(stub, id, ERR_MEMBER_NOT_FOUND)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err := parseRecord(id, recordAsBytes)
+	if err != nil {
+		if validJSON(recordAsBytes) {
+			return nil, nil, wrongTypeError(id, "member")
+		}
+		return nil, nil, err
+	}
+
+	kind := recordKind(record)
+	if kind == TYPE_USER {
+		var user User
+		err = decodeRecord(id, recordAsBytes, kind, &user)
+		return &user, &user.Member, err
+	} else if kind == TYPE_SELLER {
+		var seller Seller
+		err = decodeRecord(id, recordAsBytes, kind, &seller)
+		return &seller, &seller.Member, err
+	}
+	return nil, nil, wrongTypeError(id, "member")
+}
+
+// loadRecord reads the record of the given kind stored under key into v
+func loadRecord(stub shim.ChaincodeStubInterface, key string, kind string, v interface{}) error {
+	notFoundCode := ERR_RECORD_NOT_FOUND
+	if kind == TYPE_USER || kind == TYPE_SELLER {
+		notFoundCode = ERR_MEMBER_NOT_FOUND
+	} else if kind == KIND_CONTRACT {
+		notFoundCode = ERR_CONTRACT_NOT_FOUND
+	}
+
+	recordAsBytes, err := getRecordBytes(stub, key, notFoundCode)
+	if err != nil {
+		return err
+	}
+	return decodeRecord(key, recordAsBytes, kind, v)
+}
+
+// getRecordBytes reads the raw bytes stored under key, failing with notFoundCode when there are none
+func getRecordBytes(stub shim.ChaincodeStubInterface, key string, notFoundCode string) ([]byte, error) {
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if recordAsBytes == nil {
+		return nil, newError(notFoundCode, key+" not found").withDetail("key", key)
+	}
+	return recordAsBytes, nil
+}
+
+// wrongTypeError reports a key holding another kind of record than expected
+func wrongTypeError(key string, kind string) *ChaincodeError {
+	return newError(ERR_WRONG_RECORD_TYPE, "Not "+kind+" type").withDetail("key", key).withDetail("expectedType", kind)
+}
@@ -0,0 +1,83 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+// errorCode is the code of a ChaincodeError, or "" for other errors
+func errorCode(err error) string {
+	if chaincodeError, ok := err.(*ChaincodeError); ok {
+		return chaincodeError.Code
+	}
+	return ""
+}
+
+func TestLoadersReportDistinctErrors(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.createSeller("s1", "", 0, 0)
+	e.put("u2", `not json`)
+	e.put("u3", `["u1"]`)
+
+	_, err := loadUser(e.stub, "u9")
+	if errorCode(err) != ERR_MEMBER_NOT_FOUND {
+		t.Fatalf("loading a missing user returned %v", err)
+	}
+	_, err = loadContract(e.stub, "c9")
+	if errorCode(err) != ERR_CONTRACT_NOT_FOUND {
+		t.Fatalf("loading a missing contract returned %v", err)
+	}
+	_, err = loadUser(e.stub, "s1")
+	if errorCode(err) != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading a seller as user returned %v", err)
+	}
+	_, err = loadSeller(e.stub, "u1")
+	if errorCode(err) != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading a user as seller returned %v", err)
+	}
+	_, err = loadContract(e.stub, "u1")
+	if errorCode(err) != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading a user as contract returned %v", err)
+	}
+	_, err = loadUser(e.stub, "u2")
+	if errorCode(err) != ERR_CORRUPT_RECORD {
+		t.Fatalf("loading a corrupt user returned %v", err)
+	}
+
+	//sellerIds holds valid JSON that is no member
+	_, _, err = loadMember(e.stub, "sellerIds")
+	if errorCode(err) != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading the seller index as member returned %v", err)
+	}
+	_, _, err = loadMember(e.stub, "u3")
+	if errorCode(err) != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading a JSON array as member returned %v", err)
+	}
+
+	record, member, err := loadMember(e.stub, "s1")
+	if err != nil || member.Type != TYPE_SELLER {
+		t.Fatalf("loading a seller as member returned %v, %+v", err, member)
+	}
+	if _, ok := record.(*Seller); !ok {
+		t.Fatalf("seller member record is %T", record)
+	}
+}
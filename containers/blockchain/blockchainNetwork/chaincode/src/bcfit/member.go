@@ -21,26 +21,104 @@ package main
 
 import (
 	"encoding/json"
-	"strconv"
+	"fmt"
 	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+type createMemberRequest struct {
+	MemberId   string `json:"memberId" desc:"the id returned from enroll"`
+	MemberType string `json:"memberType" desc:"user or seller"`
+}
+
+func (r *createMemberRequest) validate() error {
+	memberType := strings.ToLower(r.MemberType)
+	if memberType != TYPE_USER && memberType != TYPE_SELLER {
+		return argError(1, "memberType", "must be user or seller")
+	}
+	return nil
+}
+
+type memberStatusRequest struct {
+	MemberId string `json:"memberId" desc:"the user's or seller's id"`
+}
+
+type generateFitcoinsRequest struct {
+	UserId       string `json:"userId" desc:"the user's id"`
+	TotalSteps   int    `json:"totalSteps" desc:"the total steps walked by the user, or the user's total of the activity in its unit"`
+	ActivityType string `json:"activityType,omitempty" desc:"a registered activity type, steps by default"`
+}
+
+func (r *generateFitcoinsRequest) validate() error {
+	if r.TotalSteps < 0 {
+		return argError(1, "totalSteps", "must not be negative")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createMember",
+		Description: "Create a user or seller",
+		Request:     createMemberRequest{},
+		Role:        ROLE_MEMBER,
+		handler:     (*SimpleChaincode).createMember,
+	})
+	registerFunction(Function{
+		Name:        "generateFitcoins",
+		Description: "Convert the user's new steps or units of an activity to fitcoins",
+		Request:     generateFitcoinsRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).generateFitcoins,
+	})
+	registerFunction(Function{
+		Name:        "deactivateMember",
+		Description: "Deactivate an active user or seller, inactive members cannot act until an admin reactivates them",
+		Request:     memberStatusRequest{},
+		Role:        ROLE_MEMBER_OR_ADMIN,
+		handler:     (*SimpleChaincode).deactivateMember,
+	})
+	registerFunction(Function{
+		Name:        "reactivateMember",
+		Description: "Reactivate an inactive user or seller",
+		Request:     memberStatusRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).reactivateMember,
+	})
+	registerFunction(Function{
+		Name:        "closeAccount",
+		Description: "Close the account of a user or seller for good, declining its pending contracts",
+		Request:     memberStatusRequest{},
+		Role:        ROLE_MEMBER,
+		handler:     (*SimpleChaincode).closeAccount,
+	})
+}
+
 // ============================================================================================================================
 // Create member
 // Inputs - id, type(user or seller)
 // ============================================================================================================================
 func (t *SimpleChaincode) createMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments")
+	var request createMemberRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//get id and type from args
-	member_id := args[0]
-	member_type := strings.ToLower(args[1])
+	member_id := request.MemberId
+	member_type := strings.ToLower(request.MemberType)
+
+	//refuse ids that are already in use, re-creating a member would reset its balance
+	existingAsBytes, err := stub.GetState(member_id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingAsBytes != nil {
+		return errorResponse(newError(ERR_ALREADY_EXISTS, "Member "+member_id+" already exists").withDetail("memberId", member_id))
+	}
 
 	//check if type is 'user'
 	if member_type == TYPE_USER {
@@ -50,14 +128,14 @@ func (t *SimpleChaincode) createMember(stub shim.ChaincodeStubInterface, args []
 		user.Id = member_id
 		user.Type = TYPE_USER
 		user.FitcoinsBalance = 0
+		user.Status = STATUS_ACTIVE
 		user.StepsUsedForConversion = 0
 		user.TotalSteps = 0
 
 		//store user
-		userAsBytes, _ := json.Marshal(user)
-		err = stub.PutState(user.Id, userAsBytes)
+		userAsBytes, err := writeRecord(stub, user.Id, &user)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 
 		//return user info
@@ -71,61 +149,64 @@ func (t *SimpleChaincode) createMember(stub shim.ChaincodeStubInterface, args []
 		seller.Id = member_id
 		seller.Type = TYPE_SELLER
 		seller.FitcoinsBalance = 0
+		seller.Status = STATUS_ACTIVE
 
 		// store seller
-		sellerAsBytes, _ := json.Marshal(seller)
-		err = stub.PutState(seller.Id, sellerAsBytes)
+		sellerAsBytes, err := writeRecord(stub, seller.Id, &seller)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 
 		//get and update sellerIDs
-		sellerIdsBytes, err := stub.GetState("sellerIds")
+		sellerIds, err := getSellerIds(stub)
 		if err != nil {
-			return shim.Error("Unable to get users.")
+			return errorResponse(err)
 		}
-		var sellerIds []string
 		// add sellerID to update sellers
-		json.Unmarshal(sellerIdsBytes, &sellerIds)
 		sellerIds = append(sellerIds, seller.Id)
 		updatedSellerIdsBytes, _ := json.Marshal(sellerIds)
 		err = stub.PutState("sellerIds", updatedSellerIdsBytes)
+		if err != nil {
+			return errorResponse(err)
+		}
 
 		//return seller info
 		return shim.Success(sellerAsBytes)
 
 	}
 
-	return shim.Success(nil)
+	return errorResponse(argError(1, "memberType", "must be user or seller"))
 
 }
 
 // ============================================================================================================================
 // Generate Fitcoins for the user
-// Inputs - userId, transactionSteps
+// Inputs - userId, transactionSteps, activityType(optional, steps by default)
 // ============================================================================================================================
 func (t *SimpleChaincode) generateFitcoins(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments")
+	var request generateFitcoinsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var err error
 
 	//get user_id and newSteps from args
-	user_id := args[0]
-	newTransactionSteps, err := strconv.Atoi(args[1])
-	if err != nil {
-		return shim.Error(err.Error())
-	}
+	user_id := request.UserId
+	newTransactionSteps := request.TotalSteps
 
 	//get user
-	var user User
-	userAsBytes, err := stub.GetState(user_id)
+	user, err := loadUser(stub, user_id)
 	if err != nil {
-		return shim.Error("Failed to get user")
+		return errorResponse(err)
 	}
-	json.Unmarshal(userAsBytes, &user)
-	if user.Type != TYPE_USER {
-		return shim.Error("Not user type")
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//activities other than steps convert by the rule of their activity type
+	if request.ActivityType != "" && request.ActivityType != ACTIVITY_STEPS {
+		return generateActivityFitcoins(stub, user, request.ActivityType, newTransactionSteps)
 	}
 
 	//update user account
@@ -134,34 +215,183 @@ func (t *SimpleChaincode) generateFitcoins(stub shim.ChaincodeStubInterface, arg
 	if newSteps >= STEPS_TO_FITCOIN {
 		newFitcoins = newSteps / STEPS_TO_FITCOIN
 		var remainderSteps = newSteps % STEPS_TO_FITCOIN
-		user.FitcoinsBalance = user.FitcoinsBalance + newFitcoins
+		err = mintFitcoins(stub, &user.Member, newFitcoins)
+		if err != nil {
+			return errorResponse(err)
+		}
 		user.StepsUsedForConversion = newTransactionSteps - remainderSteps
+	}
+
+	//steps count when they are reported, whether or not they convert yet
+	var reportedSteps = newTransactionSteps - user.TotalSteps
+	if reportedSteps > 0 {
+		//add the new steps to the user's team and its running challenges
+		err = addTeamSteps(stub, user, reportedSteps)
+		if err != nil {
+			return errorResponse(err)
+		}
+		//and to the user's steps of the day
+		err = addDailySteps(stub, user.Id, reportedSteps)
+		if err != nil {
+			return errorResponse(err)
+		}
 		user.TotalSteps = newTransactionSteps
+	}
 
-		//update users state
-		updatedUserAsBytes, _ := json.Marshal(user)
-		err = stub.PutState(user_id, updatedUserAsBytes)
+	//update users state
+	if newFitcoins > 0 || reportedSteps > 0 {
+		_, err = writeRecord(stub, user_id, &user)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 	}
 
 	//return updated user with GeneratedFitcoins and GeneratedSteps
 	type ReturnUser struct {
 		User
-		GeneratedFitcoins			 int      `json:"generatedFitcoins"`
+		GeneratedFitcoins int `json:"generatedFitcoins"`
 	}
 	var returnUser ReturnUser
 
-	returnUser.Id = user.Id
-	returnUser.Type = user.Type
-	returnUser.FitcoinsBalance = user.FitcoinsBalance
-	returnUser.TotalSteps = user.TotalSteps
-	returnUser.StepsUsedForConversion = user.StepsUsedForConversion
-	returnUser.ContractIds = user.ContractIds
+	returnUser.User = user
 	returnUser.GeneratedFitcoins = newFitcoins
 
 	returnUserBytes, _ := json.Marshal(returnUser)
 	return shim.Success(returnUserBytes)
 
 }
+
+// ============================================================================================================================
+// Get the ids of all sellers
+// ============================================================================================================================
+func getSellerIds(stub shim.ChaincodeStubInterface) ([]string, error) {
+	sellerIdsBytes, err := stub.GetState("sellerIds")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get sellers.")
+	}
+	var sellerIds []string
+	if sellerIdsBytes == nil {
+		return sellerIds, nil
+	}
+	err = decodeStrict(sellerIdsBytes, &sellerIds)
+	if err != nil {
+		return nil, fmt.Errorf("Seller index is corrupt: %s", err.Error())
+	}
+	return sellerIds, nil
+}
+
+// ============================================================================================================================
+// Deactivate member - by the member or an admin, only an admin can reactivate it
+// Inputs - memberId
+// ============================================================================================================================
+func (t *SimpleChaincode) deactivateMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return setMemberStatus(stub, args, STATUS_ACTIVE, STATUS_INACTIVE)
+}
+
+// ============================================================================================================================
+// Reactivate member
+// Inputs - memberId
+// ============================================================================================================================
+func (t *SimpleChaincode) reactivateMember(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return setMemberStatus(stub, args, STATUS_INACTIVE, STATUS_ACTIVE)
+}
+
+// ============================================================================================================================
+// Close account - closes an active or inactive member for good and declines its pending contracts
+// Inputs - memberId
+// ============================================================================================================================
+func (t *SimpleChaincode) closeAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request memberStatusRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get member
+	record, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if member.Status == STATUS_CLOSED {
+		return errorResponse(memberStatusError(member))
+	}
+	err = checkNotFrozen(*member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//pending contracts can no longer be completed
+	err = declinePendingContracts(stub, member.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//closed users leave their team
+	if user, ok := record.(*User); ok && user.TeamId != "" {
+		err = removeTeamMember(stub, user)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	//update member state
+	member.Status = STATUS_CLOSED
+	memberAsBytes, err := writeRecord(stub, member.Id, record)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(memberAsBytes)
+}
+
+// setMemberStatus moves a member from one status to another
+func setMemberStatus(stub shim.ChaincodeStubInterface, args []string, from string, to string) pb.Response {
+	var request memberStatusRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get member and check its current status
+	record, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if member.Status != from {
+		return errorResponse(memberStatusError(member))
+	}
+	err = checkNotFrozen(*member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//update member state
+	member.Status = to
+	memberAsBytes, err := writeRecord(stub, member.Id, record)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(memberAsBytes)
+}
+
+// checkActive refuses to act on deactivated, closed or frozen members
+func checkActive(member Member) error {
+	if member.Status != STATUS_ACTIVE {
+		return memberStatusError(&member)
+	}
+	return checkNotFrozen(member)
+}
+
+// checkNotFrozen refuses to act on members frozen by an admin
+func checkNotFrozen(member Member) error {
+	if member.Frozen {
+		return newError(ERR_MEMBER_FROZEN, "Member "+member.Id+" is frozen").withDetail("memberId", member.Id)
+	}
+	return nil
+}
+
+// memberStatusError reports a member whose status does not allow the call
+func memberStatusError(member *Member) *ChaincodeError {
+	return newError(ERR_MEMBER_INACTIVE, "Member "+member.Id+" is "+member.Status).
+		withDetail("memberId", member.Id).
+		withDetail("status", member.Status)
+}
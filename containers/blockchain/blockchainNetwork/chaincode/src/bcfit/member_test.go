@@ -0,0 +1,123 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCreatingAMemberTwiceIsRefused(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 550)
+	e.createSeller("s1", "", 0, 0)
+
+	e.as("u1").mustFail(ERR_ALREADY_EXISTS, "createMember", "u1", TYPE_USER)
+	e.as("s1").mustFail(ERR_ALREADY_EXISTS, "createMember", "s1", TYPE_SELLER)
+	e.as("u2").mustFail(ERR_INVALID_ARGUMENT, "createMember", "u2", "admin")
+	e.checkBalance("u1", 5)
+
+	sellerIds, _ := getSellerIds(e.stub)
+	if len(sellerIds) != 1 {
+		t.Fatalf("seller ids are %v", sellerIds)
+	}
+}
+
+func TestGenerateFitcoinsConvertsNewSteps(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	var result struct {
+		User
+		GeneratedFitcoins int `json:"generatedFitcoins"`
+	}
+	e.as("u1").mustInvoke(&result, "generateFitcoins", "u1", "250")
+	if result.GeneratedFitcoins != 2 || result.StepsUsedForConversion != 200 || result.TotalSteps != 250 {
+		t.Fatalf("first conversion is %+v", result)
+	}
+	e.as("u1").mustInvoke(&result, "generateFitcoins", "u1", "420")
+	if result.GeneratedFitcoins != 2 || result.StepsUsedForConversion != 400 || result.TotalSteps != 420 {
+		t.Fatalf("second conversion is %+v", result)
+	}
+	//a lower total converts nothing
+	e.as("u1").mustInvoke(&result, "generateFitcoins", "u1", "300")
+	if result.GeneratedFitcoins != 0 || result.TotalSteps != 420 {
+		t.Fatalf("lower total is %+v", result)
+	}
+	e.checkBalance("u1", 4)
+}
+
+func TestMemberLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+
+	//inactive members cannot act until an admin reactivates them
+	e.as("u1").mustInvoke(nil, "deactivateMember", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "deactivateMember", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "generateFitcoins", "u1", "2000")
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "reactivateMember", "u1")
+	e.asAdmin().mustInvoke(nil, "reactivateMember", "u1")
+	e.asAdmin().mustFail(ERR_MEMBER_INACTIVE, "reactivateMember", "u1")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "2000")
+	e.checkBalance("u1", 20)
+
+	//closing is for good
+	e.as("u1").mustInvoke(nil, "closeAccount", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "closeAccount", "u1")
+	e.asAdmin().mustFail(ERR_MEMBER_INACTIVE, "reactivateMember", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "generateFitcoins", "u1", "3000")
+	if e.user("u1").Status != STATUS_CLOSED {
+		t.Fatalf("closed user is %+v", e.user("u1"))
+	}
+}
+
+func TestMemberCannotUndoAnAdminDeactivation(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createUser("u2", 0)
+
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "deactivateMember", "u1")
+	e.asAdmin().mustInvoke(nil, "deactivateMember", "u1")
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "reactivateMember", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "generateFitcoins", "u1", "2000")
+	if e.user("u1").Status != STATUS_INACTIVE {
+		t.Fatalf("user is %+v", e.user("u1"))
+	}
+	e.asAdmin().mustInvoke(nil, "reactivateMember", "u1")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "2000")
+}
+
+func TestClosingAMemberDeclinesItsPendingContracts(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createSeller("s1", "p1", 5, 2)
+	e.createSeller("s2", "p2", 5, 2)
+
+	var first, second Contract
+	e.as("u1").mustInvoke(&first, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("u1").mustInvoke(&second, "makePurchase", "u1", "s2", "p2", "1")
+	e.as("s1").mustInvoke(nil, "closeAccount", "s1")
+
+	if e.contract(first.Id).State != STATE_DECLINED || e.contract(second.Id).State != STATE_PENDING {
+		t.Fatalf("contracts are %s and %s after closing the seller", e.contract(first.Id).State, e.contract(second.Id).State)
+	}
+	e.as("s2").mustInvoke(nil, "deactivateMember", "s2")
+	e.as("s2").mustFail(ERR_MEMBER_INACTIVE, "transactPurchase", "s2", second.Id, STATE_COMPLETE)
+	e.checkBalance("u1", 10)
+}
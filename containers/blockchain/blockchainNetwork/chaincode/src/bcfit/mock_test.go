@@ -0,0 +1,420 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MSP ids of the network's organizations, and the admin identity the tests instantiate the chaincode with
+const TEST_USER_MSP = "FitCoinOrgMSP"
+const TEST_SELLER_MSP = "ShopOrgMSP"
+const TEST_ADMIN = "admin"
+
+//transaction time of the first call of a test
+const TEST_START = "2018-03-01T09:00:00Z"
+
+//pickup nonce sent by the tests that need a pickup secret
+const TEST_PICKUP_NONCE = "0123456789abcdef0123456789abcdef"
+
+// testStub adds to the Fabric 1.0 MockStub the transaction context it leaves out: the arguments, the
+// creator, the transient map and the timestamp. Like a peer, it keeps the writes of a call apart and
+// commits them only when the call succeeds, and range queries do not see them.
+type testStub struct {
+	*shim.MockStub
+	args      [][]byte
+	creator   []byte
+	transient map[string][]byte
+	now       time.Time
+	writes    map[string][]byte
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *testStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.MockStub.GetState(key)
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	if s.TxID == "" {
+		return fmt.Errorf("PutState outside of a transaction")
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *testStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+// commit applies the writes of the call to the mock ledger, in key order
+func (s *testStub) commit() {
+	var keys []string
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if s.writes[key] == nil {
+			s.MockStub.DelState(key)
+		} else {
+			s.MockStub.PutState(key, s.writes[key])
+		}
+	}
+}
+
+// testEnv runs calls against a freshly instantiated chaincode, one transaction per call. The time
+// moves a second forward with every call. as and with set the caller and transient map of the next call.
+type testEnv struct {
+	t         *testing.T
+	cc        *SimpleChaincode
+	stub      *testStub
+	txCount   int
+	mspId     string
+	subject   string
+	transient map[string][]byte
+	key       *ecdsa.PrivateKey
+	creators  map[string][]byte
+}
+
+// newTestEnv instantiates the chaincode with TEST_ADMIN of the user MSP as admin
+func newTestEnv(t *testing.T) *testEnv {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse(time.RFC3339, TEST_START)
+	e := &testEnv{t: t, cc: new(SimpleChaincode), key: key, creators: map[string][]byte{}}
+	e.stub = &testStub{MockStub: shim.NewMockStub("bcfit", e.cc), now: now}
+	e.asAdmin()
+	response := e.run(true, "init", TEST_USER_MSP+"/"+TEST_ADMIN)
+	if response.Status != shim.OK {
+		t.Fatalf("init failed: %s", response.Message)
+	}
+	return e
+}
+
+// as makes the next calls with the certificate of subject, in the user MSP or the seller MSP for ids starting with "s"
+func (e *testEnv) as(subject string) *testEnv {
+	e.mspId = TEST_USER_MSP
+	if strings.HasPrefix(subject, "s") {
+		e.mspId = TEST_SELLER_MSP
+	}
+	e.subject = subject
+	return e
+}
+
+// asAdmin makes the next calls as the admin identity
+func (e *testEnv) asAdmin() *testEnv {
+	e.mspId = TEST_USER_MSP
+	e.subject = TEST_ADMIN
+	return e
+}
+
+// with adds an entry to the transient map of the next call
+func (e *testEnv) with(key string, value string) *testEnv {
+	if e.transient == nil {
+		e.transient = map[string][]byte{}
+	}
+	e.transient[key] = []byte(value)
+	return e
+}
+
+// without leaves an entry out of the transient map of the next call, such as the pickup nonce sent by default
+func (e *testEnv) without(key string) *testEnv {
+	if e.transient == nil {
+		e.transient = map[string][]byte{}
+	}
+	e.transient[key] = nil
+	return e
+}
+
+// advance moves the transaction time forward
+func (e *testEnv) advance(d time.Duration) {
+	e.stub.now = e.stub.now.Add(d)
+}
+
+// nextTxId is the transaction id the next call will get
+func (e *testEnv) nextTxId() string {
+	return fmt.Sprintf("%064x", e.txCount+1)
+}
+
+// invoke runs a call, committing its writes when it succeeds
+func (e *testEnv) invoke(args ...string) pb.Response {
+	return e.run(false, args...)
+}
+
+func (e *testEnv) run(init bool, args ...string) pb.Response {
+	e.txCount++
+	e.stub.now = e.stub.now.Add(time.Second)
+	e.stub.args = nil
+	for _, arg := range args {
+		e.stub.args = append(e.stub.args, []byte(arg))
+	}
+	e.stub.creator = e.creator(e.mspId, e.subject)
+	//like the backend, calls making contracts send TEST_PICKUP_NONCE unless the test sets or leaves out its own
+	transient := map[string][]byte{}
+	if len(args) > 0 && isPickupFunction(args[0]) {
+		transient[PICKUP_NONCE_KEY] = []byte(TEST_PICKUP_NONCE)
+	}
+	for key, value := range e.transient {
+		if value == nil {
+			delete(transient, key)
+		} else {
+			transient[key] = value
+		}
+	}
+	e.stub.transient = transient
+	e.transient = nil
+	e.stub.writes = map[string][]byte{}
+
+	e.stub.MockTransactionStart(fmt.Sprintf("%064x", e.txCount))
+	var response pb.Response
+	if init {
+		response = e.cc.Init(e.stub)
+	} else {
+		response = e.cc.Invoke(e.stub)
+	}
+	if response.Status == shim.OK {
+		e.stub.commit()
+	}
+	//the writes of a failed call are discarded, not left for later reads
+	e.stub.writes = map[string][]byte{}
+	e.stub.MockTransactionEnd(e.stub.TxID)
+	return response
+}
+
+// inTx runs f in a transaction of its own, with the stub Invoke would pass to a handler, and
+// commits its writes when it succeeds
+func (e *testEnv) inTx(f func(stub shim.ChaincodeStubInterface) error) {
+	e.txCount++
+	e.stub.now = e.stub.now.Add(time.Second)
+	e.stub.creator = e.creator(e.mspId, e.subject)
+	e.stub.writes = map[string][]byte{}
+	e.stub.MockTransactionStart(fmt.Sprintf("%064x", e.txCount))
+	err := f(newTxStub(e.stub))
+	if err != nil {
+		e.t.Fatalf("transaction failed: %s", err.Error())
+	}
+	e.stub.commit()
+	e.stub.writes = map[string][]byte{}
+	e.stub.MockTransactionEnd(e.stub.TxID)
+}
+
+// mustInvoke runs a call that must succeed and decodes its payload into v, unless v is nil
+func (e *testEnv) mustInvoke(v interface{}, args ...string) {
+	response := e.invoke(args...)
+	if response.Status != shim.OK {
+		e.t.Fatalf("%v failed: %s", args, response.Message)
+	}
+	if v != nil {
+		err := json.Unmarshal(response.Payload, v)
+		if err != nil {
+			e.t.Fatalf("%v returned %s: %s", args, response.Payload, err.Error())
+		}
+	}
+}
+
+// mustFail runs a call that must fail with the error code and returns the error
+func (e *testEnv) mustFail(code string, args ...string) ChaincodeError {
+	response := e.invoke(args...)
+	if response.Status == shim.OK {
+		e.t.Fatalf("%v succeeded, expected %s: %s", args, code, response.Payload)
+	}
+	var chaincodeError ChaincodeError
+	err := json.Unmarshal([]byte(response.Message), &chaincodeError)
+	if err != nil {
+		e.t.Fatalf("%v failed without an error envelope: %s", args, response.Message)
+	}
+	if chaincodeError.Code != code {
+		e.t.Fatalf("%v failed with %s, expected %s: %s", args, chaincodeError.Code, code, chaincodeError.Message)
+	}
+	return chaincodeError
+}
+
+// creator is the serialized identity of subject, with a certificate whose common name is subject
+func (e *testEnv) creator(mspId string, subject string) []byte {
+	if creator, ok := e.creators[mspId+"/"+subject]; ok {
+		return creator
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(e.creators) + 1)),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &e.key.PublicKey, e.key)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	certificatePem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspId, IdBytes: certificatePem})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.creators[mspId+"/"+subject] = creator
+	return creator
+}
+
+// put stores a raw record on the mock ledger, as an older chaincode version would have
+func (e *testEnv) put(key string, value string) {
+	e.stub.MockTransactionStart("put")
+	e.stub.MockStub.PutState(key, []byte(value))
+	e.stub.MockTransactionEnd("put")
+}
+
+// get reads a raw record from the mock ledger
+func (e *testEnv) get(key string) string {
+	value, _ := e.stub.MockStub.GetState(key)
+	return string(value)
+}
+
+// createUser creates a user holding the fitcoins of the steps
+func (e *testEnv) createUser(id string, steps int) {
+	e.as(id).mustInvoke(nil, "createMember", id, TYPE_USER)
+	if steps > 0 {
+		e.as(id).mustInvoke(nil, "generateFitcoins", id, fmt.Sprint(steps))
+	}
+}
+
+// createSeller creates a seller with a product of the count and price
+func (e *testEnv) createSeller(id string, productId string, count int, price int) {
+	e.as(id).mustInvoke(nil, "createMember", id, TYPE_SELLER)
+	if productId != "" {
+		e.as(id).mustInvoke(nil, "createProduct", id, productId, "Product "+productId, fmt.Sprint(count), fmt.Sprint(price))
+	}
+}
+
+// user reads a user from the ledger
+func (e *testEnv) user(id string) User {
+	user, err := loadUser(e.stub, id)
+	if err != nil {
+		e.t.Fatalf("loading user %s: %s", id, err.Error())
+	}
+	return user
+}
+
+// seller reads a seller from the ledger
+func (e *testEnv) seller(id string) Seller {
+	seller, err := loadSeller(e.stub, id)
+	if err != nil {
+		e.t.Fatalf("loading seller %s: %s", id, err.Error())
+	}
+	return seller
+}
+
+// contract reads a contract from the ledger
+func (e *testEnv) contract(id string) Contract {
+	contract, err := loadContract(e.stub, id)
+	if err != nil {
+		e.t.Fatalf("loading contract %s: %s", id, err.Error())
+	}
+	return contract
+}
+
+// auction loads an auction from the mock ledger
+func (e *testEnv) auction(id string) Auction {
+	auction, err := loadAuction(e.stub, id)
+	if err != nil {
+		e.t.Fatalf("loading auction %s: %s", id, err.Error())
+	}
+	return auction
+}
+
+// checkBalance fails the test unless the member holds the balance
+func (e *testEnv) checkBalance(id string, balance int) {
+	_, member, err := loadMember(e.stub, id)
+	if err != nil {
+		e.t.Fatalf("loading member %s: %s", id, err.Error())
+	}
+	if member.FitcoinsBalance != balance {
+		e.t.Fatalf("%s holds %d fitcoins, expected %d", id, member.FitcoinsBalance, balance)
+	}
+}
+
+// isPickupFunction tells whether the function makes contracts or bids handed over with a pickup secret
+func isPickupFunction(function string) bool {
+	return function == "makePurchase" || function == "makeCartPurchase" || function == "placeBid"
+}
+
+// pickupSecret is the pickup secret of a single purchase or, for the seller of its items, of a cart
+func (e *testEnv) pickupSecret(contractId string, sellerId string) string {
+	return testPickupSecret(strings.TrimPrefix(contractId, "c"), sellerId)
+}
+
+// testPickupSecret is the pickup secret of a call made with TEST_PICKUP_NONCE, for the seller of a cart's items or ""
+func testPickupSecret(txId string, sellerId string) string {
+	secret := sha256.Sum256([]byte(TEST_PICKUP_NONCE + txId + sellerId))
+	return hex.EncodeToString(secret[:])
+}
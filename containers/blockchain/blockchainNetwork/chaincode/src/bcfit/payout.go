@@ -0,0 +1,384 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//payout state
+const PAYOUT_REQUESTED = "requested"
+const PAYOUT_APPROVED = "approved"
+const PAYOUT_REJECTED = "rejected"
+
+//composite key object type of payouts, keyed by seller id and payout id
+const PAYOUT_INDEX = "payout"
+
+// Sellers cash out their fitcoins through payouts. A seller requests a payout of part of its balance, an
+// organizer (admin) approves it once the payment is made off the ledger, recording the payment's external
+// reference, and the paid out fitcoins are burned from the seller. Requested payouts reserve the amount,
+// so the requests of a seller never add up to more than its balance. The balance can still drop below the
+// reservations, e.g. by an admin burn, so approval checks again that the balance covers the payout on top
+// of the payouts requested before it.
+
+// Payout of fitcoins to a seller
+type Payout struct {
+	Id                string    `json:"id"`
+	SellerId          string    `json:"sellerId"`
+	Amount            int       `json:"amount"`
+	State             string    `json:"state"`
+	RequestedAt       string    `json:"requestedAt"`
+	ResolvedAt        string    `json:"resolvedAt,omitempty"`
+	ResolvedBy        *Identity `json:"resolvedBy,omitempty"`
+	ExternalReference string    `json:"externalReference,omitempty"`
+	Reason            string    `json:"reason,omitempty"`
+	Version           int       `json:"schemaVersion"`
+}
+
+type requestPayoutRequest struct {
+	SellerId string `json:"sellerId" desc:"the seller's id"`
+	Amount   int    `json:"amount" desc:"the number of fitcoins to cash out"`
+}
+
+func (r *requestPayoutRequest) validate() error {
+	if r.Amount <= 0 {
+		return argError(1, "amount", "must be positive")
+	}
+	return nil
+}
+
+type approvePayoutRequest struct {
+	SellerId          string `json:"sellerId" desc:"the seller's id"`
+	PayoutId          string `json:"payoutId" desc:"the payout id returned by requestPayout"`
+	ExternalReference string `json:"externalReference" desc:"the reference of the payment made off the ledger"`
+}
+
+type rejectPayoutRequest struct {
+	SellerId string `json:"sellerId" desc:"the seller's id"`
+	PayoutId string `json:"payoutId" desc:"the payout id returned by requestPayout"`
+	Reason   string `json:"reason" desc:"why the payout is rejected"`
+}
+
+type getSellerPayoutsRequest struct {
+	SellerId string `json:"sellerId" desc:"the seller's id"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "requestPayout",
+		Description: "Request a payout of part of the seller's balance",
+		Request:     requestPayoutRequest{},
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).requestPayout,
+	})
+	registerFunction(Function{
+		Name:        "approvePayout",
+		Description: "Approve a requested payout, burning the fitcoins from the seller",
+		Request:     approvePayoutRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).approvePayout,
+	})
+	registerFunction(Function{
+		Name:        "rejectPayout",
+		Description: "Reject a requested payout, releasing the reserved fitcoins",
+		Request:     rejectPayoutRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).rejectPayout,
+	})
+	registerFunction(Function{
+		Name:        "getSellerPayouts",
+		Description: "Get a seller's payouts with its unsettled balance",
+		Request:     getSellerPayoutsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getSellerPayouts,
+	})
+}
+
+// ============================================================================================================================
+// Request payout
+// Inputs - sellerId, amount
+// ============================================================================================================================
+func (t *SimpleChaincode) requestPayout(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request requestPayoutRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get seller
+	seller, err := loadSeller(stub, request.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(seller.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//the balance must cover the payout on top of the payouts already requested
+	payouts, err := readPayouts(stub, seller.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	available := seller.FitcoinsBalance - payoutTotal(payouts, PAYOUT_REQUESTED)
+	if available < request.Amount {
+		return errorResponse(insufficientFundsError(seller.Id, available, request.Amount))
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var payout Payout
+	payout.Id = stub.GetTxID()
+	payout.SellerId = seller.Id
+	payout.Amount = request.Amount
+	payout.State = PAYOUT_REQUESTED
+	payout.RequestedAt = txTime.Format(TIMESTAMP_FORMAT)
+
+	payoutAsBytes, err := writePayout(stub, &payout)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(payoutAsBytes)
+}
+
+// ============================================================================================================================
+// Approve payout
+// Inputs - sellerId, payoutId, externalReference
+// ============================================================================================================================
+func (t *SimpleChaincode) approvePayout(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request approvePayoutRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	payout, err := loadRequestedPayout(stub, request.SellerId, request.PayoutId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//burn the paid out fitcoins, frozen and closed sellers can still be paid
+	seller, err := loadSeller(stub, payout.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//payouts requested earlier keep their reservation
+	payouts, err := readPayouts(stub, seller.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	available := seller.FitcoinsBalance
+	for _, earlier := range payouts {
+		if earlier.Id == payout.Id {
+			break
+		}
+		if earlier.State == PAYOUT_REQUESTED {
+			available = available - earlier.Amount
+		}
+	}
+	if available < payout.Amount {
+		return errorResponse(insufficientFundsError(seller.Id, available, payout.Amount).withDetail("payoutId", payout.Id))
+	}
+
+	err = burnFitcoins(stub, &seller.Member, payout.Amount)
+	if err != nil {
+		return errorResponse(err)
+	}
+	_, err = writeRecord(stub, seller.Id, &seller)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = writeAuditRecord(stub, ACTION_PAYOUT, seller.Id, payout.Amount, request.ExternalReference)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	payout.ExternalReference = request.ExternalReference
+	return resolvePayout(stub, payout, PAYOUT_APPROVED)
+}
+
+// ============================================================================================================================
+// Reject payout
+// Inputs - sellerId, payoutId, reason
+// ============================================================================================================================
+func (t *SimpleChaincode) rejectPayout(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request rejectPayoutRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	payout, err := loadRequestedPayout(stub, request.SellerId, request.PayoutId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	payout.Reason = request.Reason
+	return resolvePayout(stub, payout, PAYOUT_REJECTED)
+}
+
+// ============================================================================================================================
+// Get seller payouts
+// Inputs - sellerId
+// ============================================================================================================================
+func (t *SimpleChaincode) getSellerPayouts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getSellerPayoutsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	seller, err := loadSeller(stub, request.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	payouts, err := readPayouts(stub, seller.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//return the Unsettled balance, the Requested and PaidOut totals and the Payouts
+	type ReturnPayouts struct {
+		SellerId  string   `json:"sellerId"`
+		Unsettled int      `json:"unsettled"`
+		Requested int      `json:"requested"`
+		PaidOut   int      `json:"paidOut"`
+		Payouts   []Payout `json:"payouts"`
+	}
+	var returnPayouts ReturnPayouts
+	returnPayouts.SellerId = seller.Id
+	returnPayouts.Unsettled = seller.FitcoinsBalance
+	returnPayouts.Requested = payoutTotal(payouts, PAYOUT_REQUESTED)
+	returnPayouts.PaidOut = payoutTotal(payouts, PAYOUT_APPROVED)
+	returnPayouts.Payouts = payouts
+
+	returnPayoutsBytes, _ := json.Marshal(returnPayouts)
+	return shim.Success(returnPayoutsBytes)
+}
+
+// resolvePayout moves a requested payout to its final state, stamped with the resolving admin
+func resolvePayout(stub shim.ChaincodeStubInterface, payout Payout, state string) pb.Response {
+	admin, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	payout.State = state
+	payout.ResolvedAt = txTime.Format(TIMESTAMP_FORMAT)
+	payout.ResolvedBy = &admin
+
+	payoutAsBytes, err := writePayout(stub, &payout)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(payoutAsBytes)
+}
+
+// payoutTotal sums the amounts of the payouts in a state
+func payoutTotal(payouts []Payout, state string) int {
+	total := 0
+	for _, payout := range payouts {
+		if payout.State == state {
+			total = total + payout.Amount
+		}
+	}
+	return total
+}
+
+// readPayouts reads the payouts of a seller, ordered by request time
+func readPayouts(stub shim.ChaincodeStubInterface, sellerId string) ([]Payout, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(PAYOUT_INDEX, []string{sellerId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	payouts := []Payout{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var payout Payout
+		err = decodeStrict(aKeyValue.Value, &payout)
+		if err != nil {
+			return nil, corruptRecordError(aKeyValue.Key, "is not a payout: "+err.Error())
+		}
+		payouts = append(payouts, payout)
+	}
+
+	//keys are ordered by transaction id
+	sort.Stable(payoutsByRequestTime(payouts))
+	return payouts, nil
+}
+
+// loadRequestedPayout reads a payout of a seller that is still waiting for approval
+func loadRequestedPayout(stub shim.ChaincodeStubInterface, sellerId string, payoutId string) (Payout, error) {
+	var payout Payout
+	key, err := stub.CreateCompositeKey(PAYOUT_INDEX, []string{sellerId, payoutId})
+	if err != nil {
+		return payout, err
+	}
+	payoutAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return payout, err
+	}
+	if payoutAsBytes == nil {
+		return payout, newError(ERR_PAYOUT_NOT_FOUND, "Payout "+payoutId+" not found").
+			withDetail("sellerId", sellerId).
+			withDetail("payoutId", payoutId)
+	}
+	err = decodeStrict(payoutAsBytes, &payout)
+	if err != nil {
+		return payout, corruptRecordError(key, "is not a payout: "+err.Error())
+	}
+	if payout.State != PAYOUT_REQUESTED {
+		return payout, newError(ERR_INVALID_STATE, "Payout already approved or rejected").
+			withDetail("payoutId", payout.Id).
+			withDetail("state", payout.State)
+	}
+	return payout, nil
+}
+
+// writePayout stores a payout under its composite key
+func writePayout(stub shim.ChaincodeStubInterface, payout *Payout) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(PAYOUT_INDEX, []string{payout.SellerId, payout.Id})
+	if err != nil {
+		return nil, err
+	}
+	return writeRecord(stub, key, payout)
+}
+
+// payoutsByRequestTime sorts payouts oldest request first
+type payoutsByRequestTime []Payout
+
+func (p payoutsByRequestTime) Len() int           { return len(p) }
+func (p payoutsByRequestTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p payoutsByRequestTime) Less(i, j int) bool { return p[i].RequestedAt < p[j].RequestedAt }
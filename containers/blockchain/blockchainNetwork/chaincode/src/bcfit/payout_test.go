@@ -0,0 +1,104 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+// testSellerPayouts is the result of getSellerPayouts
+type testSellerPayouts struct {
+	Unsettled int      `json:"unsettled"`
+	Requested int      `json:"requested"`
+	PaidOut   int      `json:"paidOut"`
+	Payouts   []Payout `json:"payouts"`
+}
+
+// newPayoutEnv creates s1 holding 30 fitcoins
+func newPayoutEnv(t *testing.T) *testEnv {
+	e := newTestEnv(t)
+	e.createSeller("s1", "", 0, 0)
+	e.asAdmin().mustInvoke(nil, "adminMint", "s1", "30", "sales")
+	return e
+}
+
+func TestPayoutsReserveTheSellerBalance(t *testing.T) {
+	e := newPayoutEnv(t)
+
+	var first, second Payout
+	e.as("s1").mustInvoke(&first, "requestPayout", "s1", "20")
+	failure := e.as("s1").mustFail(ERR_INSUFFICIENT_FUNDS, "requestPayout", "s1", "11")
+	if failure.Details["balance"] != float64(10) {
+		t.Fatalf("available balance is reported as %v", failure.Details["balance"])
+	}
+	e.as("s1").mustInvoke(&second, "requestPayout", "s1", "10")
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "requestPayout", "s1", "0")
+
+	//approval burns the payout, rejection releases it
+	e.as("s1").mustFail(ERR_UNAUTHORIZED, "approvePayout", "s1", first.Id, "wire-1")
+	e.asAdmin().mustInvoke(nil, "approvePayout", "s1", first.Id, "wire-1")
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "approvePayout", "s1", first.Id, "wire-1")
+	e.asAdmin().mustInvoke(nil, "rejectPayout", "s1", second.Id, "wrong account")
+	e.asAdmin().mustFail(ERR_PAYOUT_NOT_FOUND, "rejectPayout", "s1", "nope", "none")
+	e.checkBalance("s1", 10)
+
+	var payouts testSellerPayouts
+	e.as("s1").mustInvoke(&payouts, "getSellerPayouts", "s1")
+	if payouts.Unsettled != 10 || payouts.Requested != 0 || payouts.PaidOut != 20 || len(payouts.Payouts) != 2 {
+		t.Fatalf("payouts are %+v", payouts)
+	}
+	if payouts.Payouts[0].State != PAYOUT_APPROVED || payouts.Payouts[0].ExternalReference != "wire-1" || payouts.Payouts[0].ResolvedBy.Subject != TEST_ADMIN {
+		t.Fatalf("approved payout is %+v", payouts.Payouts[0])
+	}
+	if payouts.Payouts[1].State != PAYOUT_REJECTED || payouts.Payouts[1].Reason != "wrong account" {
+		t.Fatalf("rejected payout is %+v", payouts.Payouts[1])
+	}
+
+	var auditLog []AuditRecord
+	e.asAdmin().mustInvoke(&auditLog, "getAuditLog", "s1")
+	if len(auditLog) != 2 || auditLog[1].Action != ACTION_PAYOUT || auditLog[1].Amount != 20 {
+		t.Fatalf("audit log is %+v", auditLog)
+	}
+}
+
+func TestApprovalRechecksTheBalanceAgainstEarlierPayouts(t *testing.T) {
+	e := newPayoutEnv(t)
+
+	var first, second Payout
+	e.as("s1").mustInvoke(&first, "requestPayout", "s1", "20")
+	e.as("s1").mustInvoke(&second, "requestPayout", "s1", "10")
+	e.asAdmin().mustInvoke(nil, "adminBurn", "s1", "5", "chargeback")
+
+	//the later payout no longer fits next to the earlier one
+	failure := e.asAdmin().mustFail(ERR_INSUFFICIENT_FUNDS, "approvePayout", "s1", second.Id, "wire-2")
+	if failure.Details["payoutId"] != second.Id || failure.Details["balance"] != float64(5) {
+		t.Fatalf("failure details are %+v", failure.Details)
+	}
+	e.asAdmin().mustInvoke(nil, "approvePayout", "s1", first.Id, "wire-1")
+	e.asAdmin().mustFail(ERR_INSUFFICIENT_FUNDS, "approvePayout", "s1", second.Id, "wire-2")
+	e.asAdmin().mustInvoke(nil, "rejectPayout", "s1", second.Id, "balance too low")
+	e.checkBalance("s1", 5)
+
+	var audit testAuditResult
+	e.as("s1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Burned != 25 {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
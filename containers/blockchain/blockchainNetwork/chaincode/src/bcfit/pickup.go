@@ -0,0 +1,165 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//transient map key of the nonce the pickup secret is derived from, and its shortest accepted length in bytes
+const PICKUP_NONCE_KEY = "pickupNonce"
+const MIN_PICKUP_NONCE_LENGTH = 16
+
+// A purchase carries a one-time pickup secret the user shows the seller at hand-over. Chaincode responses are
+// written to the ledger with the transaction, so the secret is never returned: the backend sends a random nonce
+// in the transient map, which is not written to the ledger, and hands it to the user's app. Both the chaincode
+// and the app derive the secret as hex(sha256(nonce followed by the transaction id)), followed by the seller id
+// for the items of a cart, so every seller of a cart gets its own secret. The contract only stores
+// hex(sha256(secret)). The seller completes the contract, or its items of a cart, by presenting the secret,
+// which then becomes public, so it is only good once. An auction takes the nonce with the winning bid, and its
+// contract waits in the ready state for the hand-over. Purchases and bids without a nonce are refused; only
+// contracts made before pickup secrets were introduced have no pickup hash and are completed without a secret.
+
+type getPickupRedemptionsRequest struct {
+	SellerId string `json:"sellerId,omitempty" desc:"only return the contracts of this seller"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "getPickupRedemptions",
+		Description: "Get the contracts with a pickup secret and when each was redeemed",
+		Request:     getPickupRedemptionsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getPickupRedemptions,
+	})
+}
+
+// newPickupHash derives the pickup secret of the transaction from the transient nonce and returns its hash,
+// refusing a transaction without a nonce. sellerId is the seller of a cart's items, "" for other purchases.
+func newPickupHash(stub shim.ChaincodeStubInterface, sellerId string) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", err
+	}
+	nonce, ok := transient[PICKUP_NONCE_KEY]
+	if !ok {
+		return "", newError(ERR_INVALID_ARGUMENT, "transient "+PICKUP_NONCE_KEY+" is required").
+			withDetail("name", PICKUP_NONCE_KEY)
+	}
+	if len(nonce) < MIN_PICKUP_NONCE_LENGTH {
+		return "", newError(ERR_INVALID_ARGUMENT, "transient "+PICKUP_NONCE_KEY+" must have at least "+strconv.Itoa(MIN_PICKUP_NONCE_LENGTH)+" bytes").
+			withDetail("name", PICKUP_NONCE_KEY)
+	}
+	secret := sha256.Sum256([]byte(string(nonce) + stub.GetTxID() + sellerId))
+	return hashPickupSecret(hex.EncodeToString(secret[:])), nil
+}
+
+// hashPickupSecret returns the hex sha256 hash under which a pickup secret is stored
+func hashPickupSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// checkPickupSecret refuses to complete a contract with a pickup hash unless the secret matches it
+func checkPickupSecret(contract Contract, secret string) error {
+	return matchPickupSecret(contract.Id, contract.PickupHash, secret)
+}
+
+// matchPickupSecret refuses a secret that does not match a pickup hash of the contract, any secret matches no hash,
+// which only contracts made before pickup secrets were introduced lack
+func matchPickupSecret(contractId string, pickupHash string, secret string) error {
+	if pickupHash == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashPickupSecret(secret)), []byte(pickupHash)) != 1 {
+		return newError(ERR_INVALID_PICKUP_SECRET, "Pickup secret does not match the contract").
+			withDetail("contractId", contractId)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Get pickup redemptions - the contracts with a pickup secret, redeemed or not, once per seller for a cart
+// Inputs - (none) or sellerId
+// ============================================================================================================================
+func (t *SimpleChaincode) getPickupRedemptions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getPickupRedemptionsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByRange(CONTRACT_KEY_START, CONTRACT_KEY_END)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	type Redemption struct {
+		ContractId string `json:"contractId"`
+		UserId     string `json:"userId"`
+		SellerId   string `json:"sellerId"`
+		State      string `json:"state"`
+		RedeemedAt string `json:"redeemedAt,omitempty"`
+	}
+	redemptions := []Redemption{}
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !isContractRecord(aKeyValue.Key, aKeyValue.Value) {
+			continue
+		}
+		var contract Contract
+		err = decodeRecord(aKeyValue.Key, aKeyValue.Value, KIND_CONTRACT, &contract)
+		if err != nil {
+			return errorResponse(err)
+		}
+		for _, item := range contract.Items {
+			if item.PickupHash == "" || (request.SellerId != "" && item.SellerId != request.SellerId) {
+				continue
+			}
+			listed := false
+			for _, redemption := range redemptions {
+				listed = listed || (redemption.ContractId == contract.Id && redemption.SellerId == item.SellerId)
+			}
+			if !listed {
+				redemptions = append(redemptions, Redemption{contract.Id, contract.UserId, item.SellerId, item.State, item.RedeemedAt})
+			}
+		}
+		if contract.PickupHash == "" || (request.SellerId != "" && contract.SellerId != request.SellerId) {
+			continue
+		}
+		redemptions = append(redemptions, Redemption{contract.Id, contract.UserId, contract.SellerId, contract.State, contract.RedeemedAt})
+	}
+
+	redemptionsAsBytes, _ := json.Marshal(redemptions)
+	return shim.Success(redemptionsAsBytes)
+}
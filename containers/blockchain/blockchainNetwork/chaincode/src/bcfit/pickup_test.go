@@ -0,0 +1,164 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// testRedemption is a pickup redemption as getPickupRedemptions returns it
+type testRedemption struct {
+	ContractId string `json:"contractId"`
+	SellerId   string `json:"sellerId"`
+	State      string `json:"state"`
+	RedeemedAt string `json:"redeemedAt"`
+}
+
+func TestPurchaseIsCompletedWithItsPickupSecret(t *testing.T) {
+	e, _ := newContractEnv(t)
+
+	txId := e.nextTxId()
+	var contract Contract
+	e.as("u1").with(PICKUP_NONCE_KEY, TEST_PICKUP_NONCE).mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "1")
+	secret := testPickupSecret(txId, "")
+	if contract.PickupHash != hashPickupSecret(secret) {
+		t.Fatalf("pickup hash is %s", contract.PickupHash)
+	}
+
+	e.as("s1").mustFail(ERR_INVALID_PICKUP_SECRET, "transactPurchase", "s1", contract.Id, STATE_COMPLETE)
+	e.as("s1").mustFail(ERR_INVALID_PICKUP_SECRET, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, testPickupSecret(txId, "s1"))
+	e.checkBalance("u1", 10)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, secret)
+	redeemedAt := e.contract(contract.Id).RedeemedAt
+	if redeemedAt == "" {
+		t.Fatalf("redeemed contract has no redemption time")
+	}
+	e.checkBalance("u1", 7)
+
+	var redemptions []testRedemption
+	e.as("s1").mustInvoke(&redemptions, "getPickupRedemptions", "s1")
+	if len(redemptions) != 2 || redemptions[1].ContractId != contract.Id || redemptions[1].RedeemedAt != redeemedAt {
+		t.Fatalf("redemptions are %+v", redemptions)
+	}
+}
+
+func TestContractMadeBeforePickupSecretsNeedsNone(t *testing.T) {
+	e, contract := newContractEnv(t)
+	//contracts made before the upgrade have no pickup hash
+	e.inTx(func(stub shim.ChaincodeStubInterface) error {
+		old := e.contract(contract.Id)
+		old.PickupHash = ""
+		_, err := writeRecord(stub, old.Id, &old)
+		return err
+	})
+
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_COMPLETE)
+	var redemptions []testRedemption
+	e.as("s1").mustInvoke(&redemptions, "getPickupRedemptions", "s1")
+	if len(redemptions) != 0 {
+		t.Fatalf("redemptions are %+v", redemptions)
+	}
+}
+
+func TestCallsWithoutAPickupNonceAreRefused(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+	e.createUser("u3", 2000)
+	e.createSeller("s2", "p2", 5, 6)
+
+	for _, args := range [][]string{
+		{"makePurchase", "u3", "s2", "p2", "1"},
+		{"makeCartPurchase", "u3", `[{"sellerId":"s2","productId":"p2","quantity":1}]`},
+		{"placeBid", "u3", auction.Id, "20"},
+	} {
+		failure := e.as("u3").without(PICKUP_NONCE_KEY).mustFail(ERR_INVALID_ARGUMENT, args...)
+		if failure.Details["name"] != PICKUP_NONCE_KEY {
+			t.Fatalf("%s failure details are %v", args[0], failure.Details)
+		}
+	}
+	e.checkBalance("u3", 20)
+	if user := e.user("u3"); len(user.ContractIds) != 0 {
+		t.Fatalf("user is %+v", user)
+	}
+
+	//a raise without a nonce cannot clear the pickup hash of the highest bid
+	e.as("u1").mustInvoke(nil, "placeBid", "u1", auction.Id, "20")
+	pickupHash := e.auction(auction.Id).PickupHash
+	e.as("u1").without(PICKUP_NONCE_KEY).mustFail(ERR_INVALID_ARGUMENT, "placeBid", "u1", auction.Id, "30")
+	if highest := e.auction(auction.Id); highest.PickupHash != pickupHash || highest.HighestBid != 20 {
+		t.Fatalf("auction is %+v", highest)
+	}
+}
+
+func TestCartSellersHaveTheirOwnPickupSecrets(t *testing.T) {
+	e := newCartEnv(t)
+
+	txId := e.nextTxId()
+	var cart Contract
+	e.as("u1").with(PICKUP_NONCE_KEY, TEST_PICKUP_NONCE).mustInvoke(&cart, "makeCartPurchase", "u1", testCart)
+
+	//each seller can only complete its own items with its own secret
+	e.as("s2").mustFail(ERR_INVALID_PICKUP_SECRET, "transactPurchase", "s2", cart.Id, STATE_COMPLETE, testPickupSecret(txId, "s1"))
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", cart.Id, STATE_COMPLETE, testPickupSecret(txId, "s1"))
+	e.checkBalance("s1", 10)
+	e.checkBalance("s2", 0)
+
+	var redemptions []testRedemption
+	e.as("u1").mustInvoke(&redemptions, "getPickupRedemptions")
+	if len(redemptions) != 2 {
+		t.Fatalf("redemptions are %+v", redemptions)
+	}
+	for _, redemption := range redemptions {
+		if (redemption.SellerId == "s1") != (redemption.RedeemedAt != "") {
+			t.Fatalf("redemptions are %+v", redemptions)
+		}
+	}
+}
+
+func TestAuctionContractWaitsForThePickupSecret(t *testing.T) {
+	e, auction := newAuctionEnv(t)
+
+	txId := e.nextTxId()
+	e.as("u1").with(PICKUP_NONCE_KEY, TEST_PICKUP_NONCE).mustInvoke(nil, "placeBid", "u1", auction.Id, "20")
+	e.advance(time.Hour)
+	var settled Auction
+	e.as("u2").mustInvoke(&settled, "settleAuction", auction.Id)
+	if e.contract(settled.ContractId).State != STATE_READY {
+		t.Fatalf("auction contract is %+v", e.contract(settled.ContractId))
+	}
+
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "transactPurchase", "s1", settled.ContractId, STATE_CANCELLED)
+	e.as("s1").mustFail(ERR_INVALID_PICKUP_SECRET, "transactPurchase", "s1", settled.ContractId, STATE_COMPLETE, "nope")
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", settled.ContractId, STATE_COMPLETE, testPickupSecret(txId, ""))
+	if contract := e.contract(settled.ContractId); contract.State != STATE_COMPLETE || contract.RedeemedAt == "" {
+		t.Fatalf("auction contract is %+v", contract)
+	}
+}
+
+func TestShortPickupNonceIsRefused(t *testing.T) {
+	e, _ := newContractEnv(t)
+
+	failure := e.as("u1").with(PICKUP_NONCE_KEY, "0123456789").mustFail(ERR_INVALID_ARGUMENT, "makePurchase", "u1", "s1", "p1", "1")
+	if failure.Details["name"] != PICKUP_NONCE_KEY {
+		t.Fatalf("failure details are %v", failure.Details)
+	}
+}
@@ -21,12 +21,67 @@ package main
 
 import (
 	"encoding/json"
-	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+type updateProductRequest struct {
+	SellerId     string `json:"sellerId" desc:"the seller's id"`
+	ProductId    string `json:"productId" desc:"the id of the product with the seller"`
+	ProductName  string `json:"productName" desc:"the name of the product"`
+	ProductCount int    `json:"productCount" desc:"the count of the product"`
+	ProductPrice int    `json:"productPrice" desc:"the price of the product in fitcoins"`
+}
+
+type getProductByIDRequest struct {
+	SellerId  string `json:"sellerId" desc:"the seller's id"`
+	ProductId string `json:"productId" desc:"the id of the product with the seller"`
+}
+
+func (r *updateProductRequest) validate() error {
+	if r.ProductCount < 0 {
+		return argError(3, "productCount", "must not be negative")
+	}
+	if r.ProductPrice < 0 {
+		return argError(4, "productPrice", "must not be negative")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createProduct",
+		Description: "Create product inventory for a seller",
+		Request:     updateProductRequest{},
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).createProduct,
+	})
+	registerFunction(Function{
+		Name:        "updateProduct",
+		Description: "Update product inventory for a seller",
+		Request:     updateProductRequest{},
+		Role:        ROLE_SELLER,
+		handler:     (*SimpleChaincode).updateProduct,
+	})
+	registerFunction(Function{
+		Name:        "getProductByID",
+		Description: "Get a product of a seller",
+		Request:     getProductByIDRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getProductByID,
+	})
+	registerFunction(Function{
+		Name:        "getProductsForSale",
+		Description: "Get all products in stock, across all sellers",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getProductsForSale,
+	})
+}
+
 // ============================================================================================================================
 // Create product inventory for seller
 // Inputs - sellerId, productID, productName, productCount, productPrice
@@ -41,36 +96,30 @@ func (t *SimpleChaincode) createProduct(stub shim.ChaincodeStubInterface, args [
 // Inputs - sellerId, productID, newProductName, newProductCount, newProductPrice
 // ============================================================================================================================
 func (t *SimpleChaincode) updateProduct(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments")
+	var request updateProductRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var err error
 
 	//get sellerID from args
-	seller_id := args[0]
+	seller_id := request.SellerId
 	//get productID from args
-	product_id := args[1]
+	product_id := request.ProductId
 
 	//get new product properties from args
-	newProductName := args[2]
-	newProductCount, err := strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("3rd argument 'productCount' must be a numeric string")
-	}
-	newProductPrice, err := strconv.Atoi(args[4])
-	if err != nil {
-		return shim.Error("4th argument 'productPrice' must be a numeric string")
-	}
+	newProductName := request.ProductName
+	newProductCount := request.ProductCount
+	newProductPrice := request.ProductPrice
 
 	//get seller
-	sellerAsBytes, err := stub.GetState(seller_id)
+	seller, err := loadSeller(stub, seller_id)
 	if err != nil {
-		return shim.Error("Failed to get seller")
+		return errorResponse(err)
 	}
-	var seller Seller
-	json.Unmarshal(sellerAsBytes, &seller)
-	if seller.Type != TYPE_SELLER {
-		return shim.Error("Not seller type")
+	err = checkActive(seller.Member)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//find the product and update the properties
@@ -96,10 +145,9 @@ func (t *SimpleChaincode) updateProduct(stub shim.ChaincodeStubInterface, args [
 	}
 
 	//update seller's state
-	updatedSellerAsBytes, _ := json.Marshal(seller)
-	err = stub.PutState(seller_id, updatedSellerAsBytes)
+	updatedSellerAsBytes, err := writeRecord(stub, seller_id, &seller)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	//return seller info
@@ -112,24 +160,20 @@ func (t *SimpleChaincode) updateProduct(stub shim.ChaincodeStubInterface, args [
 // Inputs - sellerId, productID
 // ============================================================================================================================
 func (t *SimpleChaincode) getProductByID(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments")
+	var request getProductByIDRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
-	var err error
 
 	//get sellerID, productID from args
-	seller_id := args[0]
-	product_id := args[1]
+	seller_id := request.SellerId
+	product_id := request.ProductId
 
 	//get seller
-	sellerAsBytes, err := stub.GetState(seller_id)
+	seller, err := loadSeller(stub, seller_id)
 	if err != nil {
-		return shim.Error("Failed to get seller")
-	}
-	var seller Seller
-	json.Unmarshal(sellerAsBytes, &seller)
-	if seller.Type != TYPE_SELLER {
-		return shim.Error("Not seller type")
+		return errorResponse(err)
 	}
 
 	//find the product
@@ -145,7 +189,7 @@ func (t *SimpleChaincode) getProductByID(stub shim.ChaincodeStubInterface, args
 
 	//if product not found return error
 	if productFound != true {
-		return shim.Error("Product not found")
+		return errorResponse(productNotFoundError(seller_id, product_id))
 	}
 
 	//return product type
@@ -154,28 +198,41 @@ func (t *SimpleChaincode) getProductByID(stub shim.ChaincodeStubInterface, args
 
 }
 
+// findProduct returns the seller's product with the given id
+func findProduct(seller Seller, productId string) (Product, bool) {
+	for h := 0; h < len(seller.Products); h++ {
+		if seller.Products[h].Id == productId {
+			return seller.Products[h], true
+		}
+	}
+	return Product{}, false
+}
+
 // ============================================================================================================================
 // Get all products for sale
 // Inputs - (none)
 // ============================================================================================================================
 func (t *SimpleChaincode) getProductsForSale(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	//get sellers array
-	sellerIdsBytes, err := stub.GetState("sellerIds")
+	sellerIds, err := getSellerIds(stub)
 	if err != nil {
-		return shim.Error("Unable to get sellers.")
+		return errorResponse(err)
 	}
-	var sellerIds []string
-	json.Unmarshal(sellerIdsBytes, &sellerIds)
 
 	// create return object array
 	type ReturnProductSale struct {
-		SellerID  string `json:"sellerid"`
-		ProductId string `json:"productid"`
-		Name      string `json:"name"`
-		Count     int    `json:"count"`
-		Price     int    `json:"price"`
+		SellerID      string  `json:"sellerid"`
+		ProductId     string  `json:"productid"`
+		Name          string  `json:"name"`
+		Count         int     `json:"count"`
+		Price         int     `json:"price"`
+		RatingCount   int     `json:"ratingCount"`
+		AverageRating float64 `json:"averageRating"`
 	}
 	var returnProducts []ReturnProductSale
 
@@ -183,12 +240,15 @@ func (t *SimpleChaincode) getProductsForSale(stub shim.ChaincodeStubInterface, a
 	for g := 0; g < len(sellerIds); g++ {
 
 		//get seller
-		sellerAsBytes, err := stub.GetState(sellerIds[g])
+		seller, err := loadSeller(stub, sellerIds[g])
 		if err != nil {
-			return shim.Error("Failed to get seller")
+			return errorResponse(err)
+		}
+
+		//inactive and frozen sellers cannot sell
+		if checkActive(seller.Member) != nil {
+			continue
 		}
-		var seller Seller
-		json.Unmarshal(sellerAsBytes, &seller)
 
 		for h := 0; h < len(seller.Products); h++ {
 			if seller.Products[h].Count > 0 {
@@ -198,6 +258,8 @@ func (t *SimpleChaincode) getProductsForSale(stub shim.ChaincodeStubInterface, a
 				returnProduct.Name = seller.Products[h].Name
 				returnProduct.Count = seller.Products[h].Count
 				returnProduct.Price = seller.Products[h].Price
+				returnProduct.RatingCount = seller.Products[h].RatingCount
+				returnProduct.AverageRating = seller.Products[h].AverageRating
 				//append to array
 				returnProducts = append(returnProducts, returnProduct)
 			}
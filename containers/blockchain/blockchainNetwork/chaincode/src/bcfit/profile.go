@@ -0,0 +1,189 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//composite key object type of the display name index, keyed by lower case display name
+const DISPLAY_NAME_INDEX = "displayName"
+
+//length limits of profile fields, in characters
+const MIN_DISPLAY_NAME_LENGTH = 3
+const MAX_DISPLAY_NAME_LENGTH = 32
+const MAX_DESCRIPTION_LENGTH = 280
+const MAX_CONTACT_LENGTH = 100
+const MAX_BOOTH_ID_LENGTH = 64
+
+type updateProfileRequest struct {
+	MemberId    string `json:"memberId" desc:"the user's or seller's id"`
+	DisplayName string `json:"displayName,omitempty" desc:"the name shown for the member, unique regardless of case"`
+	AvatarHash  string `json:"avatarHash,omitempty" desc:"hex sha256 hash of the avatar image"`
+	Description string `json:"description,omitempty" desc:"sellers only, what the seller offers"`
+	Contact     string `json:"contact,omitempty" desc:"sellers only, how to reach the seller"`
+	BoothId     string `json:"boothId,omitempty" desc:"sellers only, the boothId of the seller's booth in the map api"`
+}
+
+func (r *updateProfileRequest) validate() error {
+	if r.DisplayName != "" {
+		length := utf8.RuneCountInString(r.DisplayName)
+		if length < MIN_DISPLAY_NAME_LENGTH || length > MAX_DISPLAY_NAME_LENGTH {
+			return argError(1, "displayName", "must have between "+strconv.Itoa(MIN_DISPLAY_NAME_LENGTH)+" and "+strconv.Itoa(MAX_DISPLAY_NAME_LENGTH)+" characters")
+		}
+		if !isDisplayName(r.DisplayName) {
+			return argError(1, "displayName", "must be letters, digits, single spaces, '.', '-' or '_', starting and ending with a letter or digit")
+		}
+	}
+	if r.AvatarHash != "" && !isSha256Hex(r.AvatarHash) {
+		return argError(2, "avatarHash", "must be a hex sha256 hash")
+	}
+	if utf8.RuneCountInString(r.Description) > MAX_DESCRIPTION_LENGTH || !isPrintable(r.Description) {
+		return argError(3, "description", "must be at most "+strconv.Itoa(MAX_DESCRIPTION_LENGTH)+" printable characters")
+	}
+	if utf8.RuneCountInString(r.Contact) > MAX_CONTACT_LENGTH || !isPrintable(r.Contact) {
+		return argError(4, "contact", "must be at most "+strconv.Itoa(MAX_CONTACT_LENGTH)+" printable characters")
+	}
+	if len(r.BoothId) > MAX_BOOTH_ID_LENGTH || strings.IndexFunc(r.BoothId, isNotIdRune) >= 0 {
+		return argError(5, "boothId", "must be at most "+strconv.Itoa(MAX_BOOTH_ID_LENGTH)+" letters, digits, '-' or '_'")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "updateProfile",
+		Description: "Replace the profile of a user or seller, omitted fields are cleared",
+		Request:     updateProfileRequest{},
+		Role:        ROLE_MEMBER,
+		handler:     (*SimpleChaincode).updateProfile,
+	})
+}
+
+// ============================================================================================================================
+// Update profile
+// Inputs - memberId, displayName, avatarHash, and for sellers description, contact, boothId, all but memberId optional
+// ============================================================================================================================
+func (t *SimpleChaincode) updateProfile(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request updateProfileRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get member
+	record, member, err := loadMember(stub, request.MemberId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(*member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//seller fields
+	seller, isSeller := record.(*Seller)
+	if isSeller {
+		seller.Description = request.Description
+		seller.Contact = request.Contact
+		seller.BoothId = request.BoothId
+	} else if request.Description != "" || request.Contact != "" || request.BoothId != "" {
+		return errorResponse(newError(ERR_INVALID_ARGUMENT, "Only sellers have a description, contact or boothId").
+			withDetail("memberId", member.Id))
+	}
+
+	//move the display name index entry to the new name
+	err = claimDisplayName(stub, member.Id, member.DisplayName, request.DisplayName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	member.DisplayName = request.DisplayName
+	member.AvatarHash = request.AvatarHash
+
+	//update member state
+	memberAsBytes, err := writeRecord(stub, member.Id, record)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(memberAsBytes)
+}
+
+// claimDisplayName releases a member's old display name and takes the new one, unless another member has it
+func claimDisplayName(stub shim.ChaincodeStubInterface, memberId string, oldName string, newName string) error {
+	if strings.ToLower(oldName) == strings.ToLower(newName) {
+		return nil
+	}
+	if newName != "" {
+		key, err := stub.CreateCompositeKey(DISPLAY_NAME_INDEX, []string{strings.ToLower(newName)})
+		if err != nil {
+			return err
+		}
+		ownerAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if ownerAsBytes != nil && string(ownerAsBytes) != memberId {
+			return newError(ERR_ALREADY_EXISTS, "Display name "+newName+" is taken").
+				withDetail("displayName", newName)
+		}
+		err = stub.PutState(key, []byte(memberId))
+		if err != nil {
+			return err
+		}
+	}
+	if oldName != "" {
+		key, err := stub.CreateCompositeKey(DISPLAY_NAME_INDEX, []string{strings.ToLower(oldName)})
+		if err != nil {
+			return err
+		}
+		return stub.DelState(key)
+	}
+	return nil
+}
+
+// isDisplayName tells whether name is letters and digits, separated by single spaces, '.', '-' or '_'
+func isDisplayName(name string) bool {
+	previous := ' '
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if !strings.ContainsRune(" .-_", r) || strings.ContainsRune(" .-_", previous) {
+				return false
+			}
+		}
+		previous = r
+	}
+	return !strings.ContainsRune(" .-_", previous)
+}
+
+// isPrintable tells whether value has no control characters
+func isPrintable(value string) bool {
+	return utf8.ValidString(value) && strings.IndexFunc(value, unicode.IsControl) < 0
+}
+
+// isNotIdRune tells whether r cannot appear in an id of letters, digits, '-' and '_', such as a map api boothId
+func isNotIdRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
@@ -0,0 +1,79 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisplayNamesAreUniqueRegardlessOfCase(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.createUser("u2", 0)
+	avatarHash := strings.Repeat("ab", 32)
+
+	e.as("u1").mustInvoke(nil, "updateProfile", "u1", "Fit Walker", avatarHash)
+	failure := e.as("u2").mustFail(ERR_ALREADY_EXISTS, "updateProfile", "u2", "fit walker")
+	if failure.Details["displayName"] != "fit walker" {
+		t.Fatalf("failure details are %v", failure.Details)
+	}
+
+	//changing the case keeps the name, renaming releases it
+	e.as("u1").mustInvoke(nil, "updateProfile", "u1", "FIT WALKER")
+	if user := e.user("u1"); user.DisplayName != "FIT WALKER" || user.AvatarHash != "" {
+		t.Fatalf("user is %+v", user.Member)
+	}
+	e.as("u1").mustInvoke(nil, "updateProfile", "u1", "Runner_1")
+	e.as("u2").mustInvoke(nil, "updateProfile", "u2", "Fit.Walker")
+	e.as("u2").mustInvoke(nil, "updateProfile", "u2", "fit walker")
+	e.as("u1").mustFail(ERR_ALREADY_EXISTS, "updateProfile", "u1", "Fit Walker")
+
+	//clearing the name releases it too
+	e.as("u2").mustInvoke(nil, "updateProfile", "u2")
+	e.as("u1").mustInvoke(nil, "updateProfile", "u1", "Fit Walker")
+}
+
+func TestProfileFieldsAreValidated(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.createSeller("s1", "p1", 1, 1)
+
+	for _, name := range []string{"ab", strings.Repeat("a", MAX_DISPLAY_NAME_LENGTH+1), "fit  walker", "-walker", "walker.", "fit\twalker"} {
+		failure := e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "u1", name)
+		if failure.Details["argName"] != "displayName" {
+			t.Fatalf("failure details for %q are %v", name, failure.Details)
+		}
+	}
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "u1", "walker", "not a hash")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "u1", "walker", "", "a user has no description")
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "s1", "Juice Bar", "", strings.Repeat("a", MAX_DESCRIPTION_LENGTH+1))
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "s1", "Juice Bar", "", "", "line\nbreak")
+	e.as("s1").mustFail(ERR_INVALID_ARGUMENT, "updateProfile", "s1", "Juice Bar", "", "", "", "booth 7")
+
+	e.as("s1").mustInvoke(nil, "updateProfile", "s1", "Juice Bar", "", "Fresh juice", "@juicebar", "booth-7")
+	if seller := e.seller("s1"); seller.DisplayName != "Juice Bar" || seller.Description != "Fresh juice" || seller.Contact != "@juicebar" || seller.BoothId != "booth-7" {
+		t.Fatalf("seller is %+v", seller)
+	}
+
+	//inactive members cannot change their profile
+	e.as("u1").mustInvoke(nil, "deactivateMember", "u1")
+	e.as("u1").mustFail(ERR_MEMBER_INACTIVE, "updateProfile", "u1", "walker")
+}
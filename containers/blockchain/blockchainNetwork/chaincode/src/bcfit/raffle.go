@@ -0,0 +1,601 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//raffle state
+const RAFFLE_OPEN = "open"
+const RAFFLE_DRAWN = "drawn"
+const RAFFLE_CANCELLED = "cancelled"
+
+//time after closesAt by which the organizer must reveal the seed, when the raffle sets no revealBy
+const RAFFLE_REVEAL_PERIOD = 24 * time.Hour
+
+//composite key object types of raffles, keyed by raffle id, and of ticket purchases, keyed by raffle id and transaction id
+const RAFFLE_INDEX = "raffle"
+const RAFFLE_TICKET_INDEX = "raffleTicket"
+
+// Raffle draws use commit-reveal so that every endorsing peer picks the same winners and anyone can check them:
+//
+//  1. the organizer picks a secret seed and creates the raffle with seedHash = hex(sha256(seed))
+//  2. users buy tickets until closesAt; the fitcoins paid for tickets are burned
+//  3. after closesAt the organizer reveals the seed; it must hash to seedHash
+//  4. drawHash = hex(sha256(seed + the transaction ids of all ticket purchases, in ledger key order))
+//  5. tickets are numbered 0..n-1 in the same order, a purchase of q tickets holding q consecutive numbers;
+//     draw i picks ticket number sha256(drawHash + ":" + i) mod n, read as a big-endian integer,
+//     skipping numbers already drawn, until the raffle's number of winners is reached
+//
+// The organizer cannot pick the winners because the seed is fixed before any ticket is bought, and
+// buyers cannot because the seed stays secret until sales close. The identity that committed the seed
+// and the other admins, who may learn it, cannot buy tickets. A raffle whose seed is not revealed by revealBy can be cancelled by anyone, which
+// refunds every ticket, so an organizer who withholds the seed cannot keep the fitcoins paid for tickets.
+
+// Raffle of an organizer's prize
+type Raffle struct {
+	Id          string         `json:"id"`
+	Prize       string         `json:"prize"`
+	TicketPrice int            `json:"ticketPrice"`
+	WinnerCount int            `json:"winnerCount"`
+	ClosesAt    string         `json:"closesAt"`
+	RevealBy    string         `json:"revealBy,omitempty"`
+	SeedHash    string         `json:"seedHash"`
+	Organizer   Identity       `json:"organizer"`
+	Seed        string         `json:"seed,omitempty"`
+	DrawHash    string         `json:"drawHash,omitempty"`
+	Winners     []RaffleWinner `json:"winners"`
+	State       string         `json:"state"`
+	Version     int            `json:"schemaVersion"`
+}
+
+// RaffleTicket purchase by a user, identified by the purchasing transaction
+type RaffleTicket struct {
+	Id       string `json:"id"`
+	RaffleId string `json:"raffleId"`
+	UserId   string `json:"userId"`
+	Quantity int    `json:"quantity"`
+	Version  int    `json:"schemaVersion"`
+}
+
+// RaffleWinner of a drawn ticket number
+type RaffleWinner struct {
+	TicketNumber int    `json:"ticketNumber"`
+	TicketId     string `json:"ticketId"`
+	UserId       string `json:"userId"`
+}
+
+type createRaffleRequest struct {
+	Prize       string `json:"prize" desc:"description of the prize"`
+	TicketPrice int    `json:"ticketPrice" desc:"the price of a ticket in fitcoins"`
+	ClosesAt    string `json:"closesAt" desc:"RFC 3339 time from which tickets are no longer sold and the raffle can be drawn"`
+	SeedHash    string `json:"seedHash" desc:"hex sha256 hash of the secret seed revealed at the draw"`
+	WinnerCount int    `json:"winnerCount,omitempty" desc:"the number of winning tickets, 1 when omitted"`
+	RevealBy    string `json:"revealBy,omitempty" desc:"RFC 3339 time by which the seed must be revealed, closesAt plus a day when omitted"`
+}
+
+func (r *createRaffleRequest) validate() error {
+	if r.TicketPrice <= 0 {
+		return argError(1, "ticketPrice", "must be positive")
+	}
+	if _, err := time.Parse(time.RFC3339, r.ClosesAt); err != nil {
+		return argError(2, "closesAt", "must be an RFC 3339 time")
+	}
+	if !isSha256Hex(r.SeedHash) {
+		return argError(3, "seedHash", "must be a hex sha256 hash")
+	}
+	if r.WinnerCount < 0 {
+		return argError(4, "winnerCount", "must not be negative")
+	}
+	if r.RevealBy != "" {
+		revealBy, err := time.Parse(time.RFC3339, r.RevealBy)
+		if err != nil {
+			return argError(5, "revealBy", "must be an RFC 3339 time")
+		}
+		closesAt, _ := time.Parse(time.RFC3339, r.ClosesAt)
+		if !revealBy.After(closesAt) {
+			return argError(5, "revealBy", "must be after closesAt")
+		}
+	}
+	return nil
+}
+
+type buyRaffleTicketsRequest struct {
+	UserId   string `json:"userId" desc:"the buying user's id"`
+	RaffleId string `json:"raffleId" desc:"the raffle id returned by createRaffle"`
+	Quantity int    `json:"quantity" desc:"the number of tickets"`
+}
+
+func (r *buyRaffleTicketsRequest) validate() error {
+	if r.Quantity <= 0 {
+		return argError(2, "quantity", "must be positive")
+	}
+	return nil
+}
+
+type drawRaffleRequest struct {
+	RaffleId string `json:"raffleId" desc:"the raffle id returned by createRaffle"`
+	Seed     string `json:"seed" desc:"the secret seed whose hash was committed at creation"`
+}
+
+type raffleRequest struct {
+	RaffleId string `json:"raffleId" desc:"the raffle id returned by createRaffle"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createRaffle",
+		Description: "Create a raffle committing to the hash of a secret seed",
+		Request:     createRaffleRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).createRaffle,
+	})
+	registerFunction(Function{
+		Name:        "buyRaffleTickets",
+		Description: "Buy raffle tickets with fitcoins, which are burned",
+		Request:     buyRaffleTicketsRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).buyRaffleTickets,
+	})
+	registerFunction(Function{
+		Name:        "drawRaffle",
+		Description: "Reveal the seed of a closed raffle and draw its winners",
+		Request:     drawRaffleRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).drawRaffle,
+	})
+	registerFunction(Function{
+		Name:        "cancelRaffle",
+		Description: "Cancel a raffle whose seed was not revealed by its reveal deadline and refund its tickets",
+		Request:     raffleRequest{},
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).cancelRaffle,
+	})
+	registerFunction(Function{
+		Name:        "getRaffle",
+		Description: "Get a raffle with its ticket purchases",
+		Request:     raffleRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getRaffle,
+	})
+	registerFunction(Function{
+		Name:        "verifyRaffleDraw",
+		Description: "Recompute the draw of a raffle from its seed and tickets and compare it with the recorded winners",
+		Request:     raffleRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).verifyRaffleDraw,
+	})
+}
+
+// ============================================================================================================================
+// Create raffle
+// Inputs - prize, ticketPrice, closesAt, seedHash, winnerCount (optional), revealBy (optional)
+// ============================================================================================================================
+func (t *SimpleChaincode) createRaffle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request createRaffleRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//the committing identity knows the seed, so it is kept from buying tickets
+	organizer, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//create raffle, identified by the transaction id
+	var raffle Raffle
+	raffle.Id = stub.GetTxID()
+	raffle.Prize = request.Prize
+	raffle.TicketPrice = request.TicketPrice
+	raffle.WinnerCount = request.WinnerCount
+	if raffle.WinnerCount == 0 {
+		raffle.WinnerCount = 1
+	}
+	closesAt, _ := time.Parse(time.RFC3339, request.ClosesAt)
+	raffle.ClosesAt = closesAt.UTC().Format(TIMESTAMP_FORMAT)
+	revealBy := closesAt.Add(RAFFLE_REVEAL_PERIOD)
+	if request.RevealBy != "" {
+		revealBy, _ = time.Parse(time.RFC3339, request.RevealBy)
+	}
+	raffle.RevealBy = revealBy.UTC().Format(TIMESTAMP_FORMAT)
+	raffle.SeedHash = request.SeedHash
+	raffle.Organizer = organizer
+	raffle.Winners = []RaffleWinner{}
+	raffle.State = RAFFLE_OPEN
+
+	raffleAsBytes, err := writeRaffle(stub, &raffle)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(raffleAsBytes)
+}
+
+// ============================================================================================================================
+// Buy raffle tickets
+// Inputs - userId, raffleId, quantity
+// ============================================================================================================================
+func (t *SimpleChaincode) buyRaffleTickets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request buyRaffleTicketsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	raffle, err := loadRaffle(stub, request.RaffleId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if raffle.State != RAFFLE_OPEN || txTime.Format(TIMESTAMP_FORMAT) >= raffle.ClosesAt {
+		return errorResponse(newError(ERR_RAFFLE_CLOSED, "Raffle is not selling tickets").
+			withDetail("raffleId", raffle.Id).
+			withDetail("closesAt", raffle.ClosesAt))
+	}
+	//admins may know the committed seed, so neither the organizer nor any other admin buys tickets
+	caller, err := checkAdmin(stub)
+	if chaincodeError, ok := err.(*ChaincodeError); err != nil && (!ok || chaincodeError.Code != ERR_UNAUTHORIZED) {
+		return errorResponse(err)
+	}
+	if err == nil || caller == raffle.Organizer {
+		return errorResponse(newError(ERR_UNAUTHORIZED, "The raffle organizer and admins cannot buy tickets").
+			withDetail("raffleId", raffle.Id).
+			withDetail("mspId", caller.MspId).
+			withDetail("subject", caller.Subject))
+	}
+
+	//get user and pay for the tickets
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = burnFitcoins(stub, &user.Member, raffle.TicketPrice*request.Quantity)
+	if err != nil {
+		return errorResponse(err)
+	}
+	_, err = writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//store the purchase under its own key, the raffle record is not updated so purchases do not conflict
+	var ticket RaffleTicket
+	ticket.Id = stub.GetTxID()
+	ticket.RaffleId = raffle.Id
+	ticket.UserId = user.Id
+	ticket.Quantity = request.Quantity
+	key, err := stub.CreateCompositeKey(RAFFLE_TICKET_INDEX, []string{raffle.Id, ticket.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	ticketAsBytes, err := writeRecord(stub, key, &ticket)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// Draw raffle
+// Inputs - raffleId, seed
+// ============================================================================================================================
+func (t *SimpleChaincode) drawRaffle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request drawRaffleRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	raffle, err := loadRaffle(stub, request.RaffleId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if raffle.State != RAFFLE_OPEN {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle already "+raffle.State).withDetail("raffleId", raffle.Id))
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if txTime.Format(TIMESTAMP_FORMAT) < raffle.ClosesAt {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle is still selling tickets").
+			withDetail("raffleId", raffle.Id).
+			withDetail("closesAt", raffle.ClosesAt))
+	}
+	if txTime.Format(TIMESTAMP_FORMAT) >= raffleRevealBy(raffle) {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle reveal deadline has passed").
+			withDetail("raffleId", raffle.Id).
+			withDetail("revealBy", raffleRevealBy(raffle)))
+	}
+
+	//the revealed seed must be the committed one
+	seedHash := sha256.Sum256([]byte(request.Seed))
+	if hex.EncodeToString(seedHash[:]) != raffle.SeedHash {
+		return errorResponse(argError(1, "seed", "does not match the committed seed hash"))
+	}
+
+	tickets, err := readRaffleTickets(stub, raffle.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	raffle.Seed = request.Seed
+	raffle.DrawHash, raffle.Winners = drawWinners(raffle.Seed, tickets, raffle.WinnerCount)
+	raffle.State = RAFFLE_DRAWN
+
+	raffleAsBytes, err := writeRaffle(stub, &raffle)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(raffleAsBytes)
+}
+
+// ============================================================================================================================
+// Cancel raffle - refund the tickets of a raffle whose seed was not revealed by its reveal deadline
+// Inputs - raffleId
+// ============================================================================================================================
+func (t *SimpleChaincode) cancelRaffle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request raffleRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	raffle, err := loadRaffle(stub, request.RaffleId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if raffle.State != RAFFLE_OPEN {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle is "+raffle.State).withDetail("raffleId", raffle.Id))
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if txTime.Format(TIMESTAMP_FORMAT) < raffleRevealBy(raffle) {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle seed can still be revealed").
+			withDetail("raffleId", raffle.Id).
+			withDetail("revealBy", raffleRevealBy(raffle)))
+	}
+
+	//refund each user once for all of their purchases, in ledger key order
+	tickets, err := readRaffleTickets(stub, raffle.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var userIds []string
+	refunds := map[string]int{}
+	for _, ticket := range tickets {
+		if _, ok := refunds[ticket.UserId]; !ok {
+			userIds = append(userIds, ticket.UserId)
+		}
+		refunds[ticket.UserId] = refunds[ticket.UserId] + raffle.TicketPrice*ticket.Quantity
+	}
+	for _, userId := range userIds {
+		user, err := loadUser(stub, userId)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = mintFitcoins(stub, &user.Member, refunds[userId])
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = writeRecord(stub, user.Id, &user)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	raffle.State = RAFFLE_CANCELLED
+	raffleAsBytes, err := writeRaffle(stub, &raffle)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(raffleAsBytes)
+}
+
+// raffleRevealBy is the time by which the seed of a raffle must be revealed, raffles created before
+// reveal deadlines existed get the default period after closesAt
+func raffleRevealBy(raffle Raffle) string {
+	if raffle.RevealBy != "" {
+		return raffle.RevealBy
+	}
+	closesAt, _ := time.Parse(TIMESTAMP_FORMAT, raffle.ClosesAt)
+	return closesAt.Add(RAFFLE_REVEAL_PERIOD).Format(TIMESTAMP_FORMAT)
+}
+
+// drawWinners picks the winning tickets from the seed and the ticket purchases, in ledger key order
+func drawWinners(seed string, tickets []RaffleTicket, winnerCount int) (string, []RaffleWinner) {
+	hash := sha256.New()
+	hash.Write([]byte(seed))
+	total := 0
+	for _, ticket := range tickets {
+		hash.Write([]byte(ticket.Id))
+		total = total + ticket.Quantity
+	}
+	drawHash := hex.EncodeToString(hash.Sum(nil))
+
+	winners := []RaffleWinner{}
+	drawn := map[int]bool{}
+	for i := 0; len(winners) < winnerCount && len(winners) < total; i++ {
+		numberHash := sha256.Sum256([]byte(drawHash + ":" + strconv.Itoa(i)))
+		number := int(new(big.Int).Mod(new(big.Int).SetBytes(numberHash[:]), big.NewInt(int64(total))).Int64())
+		if drawn[number] {
+			continue
+		}
+		drawn[number] = true
+
+		//find the purchase holding the ticket number
+		first := 0
+		for _, ticket := range tickets {
+			if number < first+ticket.Quantity {
+				winners = append(winners, RaffleWinner{TicketNumber: number, TicketId: ticket.Id, UserId: ticket.UserId})
+				break
+			}
+			first = first + ticket.Quantity
+		}
+	}
+	return drawHash, winners
+}
+
+// ============================================================================================================================
+// Get raffle
+// Inputs - raffleId
+// ============================================================================================================================
+func (t *SimpleChaincode) getRaffle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request raffleRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	raffle, err := loadRaffle(stub, request.RaffleId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	tickets, err := readRaffleTickets(stub, raffle.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	type ReturnRaffle struct {
+		Raffle
+		Tickets []RaffleTicket `json:"tickets"`
+	}
+	returnRaffleAsBytes, _ := json.Marshal(ReturnRaffle{raffle, tickets})
+	return shim.Success(returnRaffleAsBytes)
+}
+
+// ============================================================================================================================
+// Verify raffle draw
+// Inputs - raffleId
+// ============================================================================================================================
+func (t *SimpleChaincode) verifyRaffleDraw(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request raffleRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	raffle, err := loadRaffle(stub, request.RaffleId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if raffle.State != RAFFLE_DRAWN {
+		return errorResponse(newError(ERR_INVALID_STATE, "Raffle not drawn yet").withDetail("raffleId", raffle.Id))
+	}
+	tickets, err := readRaffleTickets(stub, raffle.Id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	type Verification struct {
+		Verified    bool           `json:"verified"`
+		SeedMatches bool           `json:"seedMatches"`
+		DrawHash    string         `json:"drawHash"`
+		Winners     []RaffleWinner `json:"winners"`
+	}
+	var verification Verification
+	seedHash := sha256.Sum256([]byte(raffle.Seed))
+	verification.SeedMatches = hex.EncodeToString(seedHash[:]) == raffle.SeedHash
+	verification.DrawHash, verification.Winners = drawWinners(raffle.Seed, tickets, raffle.WinnerCount)
+
+	//the recomputed draw must match the recorded one
+	recorded, _ := json.Marshal(raffle.Winners)
+	recomputed, _ := json.Marshal(verification.Winners)
+	verification.Verified = verification.SeedMatches &&
+		verification.DrawHash == raffle.DrawHash &&
+		string(recorded) == string(recomputed)
+
+	verificationAsBytes, _ := json.Marshal(verification)
+	return shim.Success(verificationAsBytes)
+}
+
+// readRaffleTickets reads the ticket purchases of a raffle in ledger key order
+func readRaffleTickets(stub shim.ChaincodeStubInterface, raffleId string) ([]RaffleTicket, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RAFFLE_TICKET_INDEX, []string{raffleId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	tickets := []RaffleTicket{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var ticket RaffleTicket
+		err = decodeStrict(aKeyValue.Value, &ticket)
+		if err != nil {
+			return nil, corruptRecordError(aKeyValue.Key, "is not a raffle ticket: "+err.Error())
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, nil
+}
+
+// loadRaffle reads a raffle by id
+func loadRaffle(stub shim.ChaincodeStubInterface, id string) (Raffle, error) {
+	var raffle Raffle
+	key, err := stub.CreateCompositeKey(RAFFLE_INDEX, []string{id})
+	if err != nil {
+		return raffle, err
+	}
+	raffleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return raffle, err
+	}
+	if raffleAsBytes == nil {
+		return raffle, newError(ERR_RAFFLE_NOT_FOUND, "Raffle "+id+" not found").withDetail("raffleId", id)
+	}
+	err = decodeStrict(raffleAsBytes, &raffle)
+	if err != nil {
+		return raffle, corruptRecordError(key, "is not a raffle: "+err.Error())
+	}
+	return raffle, nil
+}
+
+// writeRaffle stores a raffle under its composite key
+func writeRaffle(stub shim.ChaincodeStubInterface, raffle *Raffle) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(RAFFLE_INDEX, []string{raffle.Id})
+	if err != nil {
+		return nil, err
+	}
+	return writeRecord(stub, key, raffle)
+}
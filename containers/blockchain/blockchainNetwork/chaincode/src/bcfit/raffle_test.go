@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+//seed the tests' raffles commit to
+const TEST_RAFFLE_SEED = "correct horse battery staple"
+
+// testRaffleVerification is the result of verifyRaffleDraw
+type testRaffleVerification struct {
+	Verified    bool           `json:"verified"`
+	SeedMatches bool           `json:"seedMatches"`
+	DrawHash    string         `json:"drawHash"`
+	Winners     []RaffleWinner `json:"winners"`
+}
+
+// newRaffleEnv creates a raffle of two winners closing in an hour, and the tickets of u1 and u2
+func newRaffleEnv(t *testing.T) (*testEnv, Raffle, []string) {
+	e := newTestEnv(t)
+	e.createUser("u1", 5000)
+	e.createUser("u2", 5000)
+
+	seedHash := sha256.Sum256([]byte(TEST_RAFFLE_SEED))
+	var raffle Raffle
+	e.asAdmin().mustInvoke(&raffle, "createRaffle", "Bike", "5", "2018-03-01T10:00:00Z", hex.EncodeToString(seedHash[:]), "2")
+
+	var ticketIds []string
+	for _, purchase := range [][]string{{"u1", "2"}, {"u2", "3"}, {"u1", "1"}} {
+		ticketIds = append(ticketIds, e.nextTxId())
+		e.as(purchase[0]).mustInvoke(nil, "buyRaffleTickets", purchase[0], raffle.Id, purchase[1])
+	}
+	return e, raffle, ticketIds
+}
+
+func TestRaffleTicketsAreBurned(t *testing.T) {
+	e, raffle, _ := newRaffleEnv(t)
+	e.checkBalance("u1", 35)
+	e.checkBalance("u2", 35)
+
+	var supply Supply
+	e.as("u1").mustInvoke(&supply, "getSupply")
+	if supply.Burned != 30 || supply.Circulating != 70 {
+		t.Fatalf("supply is %+v", supply)
+	}
+
+	e.as("u1").mustFail(ERR_INSUFFICIENT_FUNDS, "buyRaffleTickets", "u1", raffle.Id, "8")
+	e.as("u1").mustFail(ERR_RAFFLE_NOT_FOUND, "buyRaffleTickets", "u1", "nope", "1")
+	e.advance(time.Hour)
+	e.as("u1").mustFail(ERR_RAFFLE_CLOSED, "buyRaffleTickets", "u1", raffle.Id, "1")
+}
+
+func TestRaffleOrganizerAndAdminsCannotBuyTickets(t *testing.T) {
+	e := newTestEnv(t)
+	e.asAdmin()
+	e.run(true, "init", TEST_USER_MSP+"/"+TEST_ADMIN, TEST_USER_MSP+"/u9", TEST_USER_MSP+"/u8")
+	e.createUser("u9", 5000)
+	e.createUser("u8", 5000)
+
+	var raffle Raffle
+	e.as("u9").mustInvoke(&raffle, "createRaffle", "Bike", "5", "2018-03-01T10:00:00Z", hex.EncodeToString(make([]byte, 32)))
+	revealBy, _ := time.Parse(time.RFC3339, "2018-03-02T10:00:00Z")
+	if raffle.Organizer.Subject != "u9" || raffle.WinnerCount != 1 || raffle.RevealBy != revealBy.Format(TIMESTAMP_FORMAT) {
+		t.Fatalf("raffle is %+v", raffle)
+	}
+	e.as("u9").mustFail(ERR_UNAUTHORIZED, "buyRaffleTickets", "u9", raffle.Id, "1")
+	e.as("u8").mustFail(ERR_UNAUTHORIZED, "buyRaffleTickets", "u8", raffle.Id, "1")
+
+	//the organizer stays barred after leaving the admins, and a former admin may buy
+	e.asAdmin()
+	e.run(true, "init", TEST_USER_MSP+"/"+TEST_ADMIN)
+	e.as("u9").mustFail(ERR_UNAUTHORIZED, "buyRaffleTickets", "u9", raffle.Id, "1")
+	e.as("u8").mustInvoke(nil, "buyRaffleTickets", "u8", raffle.Id, "1")
+	e.checkBalance("u9", 50)
+	e.checkBalance("u8", 45)
+}
+
+func TestRaffleDrawFollowsTheCommittedSeed(t *testing.T) {
+	e, raffle, ticketIds := newRaffleEnv(t)
+
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "verifyRaffleDraw", raffle.Id)
+	e.advance(time.Hour)
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "drawRaffle", raffle.Id, "another seed")
+
+	var drawn Raffle
+	e.asAdmin().mustInvoke(&drawn, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "cancelRaffle", raffle.Id)
+
+	//the draw hash covers the seed and the purchases in the order they were made
+	drawHash := sha256.Sum256([]byte(TEST_RAFFLE_SEED + ticketIds[0] + ticketIds[1] + ticketIds[2]))
+	if drawn.State != RAFFLE_DRAWN || drawn.DrawHash != hex.EncodeToString(drawHash[:]) || len(drawn.Winners) != 2 {
+		t.Fatalf("drawn raffle is %+v", drawn)
+	}
+	firstHash := sha256.Sum256([]byte(drawn.DrawHash + ":0"))
+	first := new(big.Int).Mod(new(big.Int).SetBytes(firstHash[:]), big.NewInt(6)).Int64()
+	if int64(drawn.Winners[0].TicketNumber) != first || drawn.Winners[0].TicketNumber == drawn.Winners[1].TicketNumber {
+		t.Fatalf("winners are %+v, expected ticket %d first", drawn.Winners, first)
+	}
+	owners := []string{"u1", "u1", "u2", "u2", "u2", "u1"}
+	purchases := []string{ticketIds[0], ticketIds[0], ticketIds[1], ticketIds[1], ticketIds[1], ticketIds[2]}
+	for _, winner := range drawn.Winners {
+		if winner.UserId != owners[winner.TicketNumber] || winner.TicketId != purchases[winner.TicketNumber] {
+			t.Fatalf("winner %+v does not hold the ticket", winner)
+		}
+	}
+
+	var verification testRaffleVerification
+	e.as("u2").mustInvoke(&verification, "verifyRaffleDraw", raffle.Id)
+	if !verification.Verified || !verification.SeedMatches {
+		t.Fatalf("verification is %+v", verification)
+	}
+}
+
+func TestRaffleVerificationDetectsAlteredWinners(t *testing.T) {
+	e, raffle, _ := newRaffleEnv(t)
+	e.advance(time.Hour)
+	e.asAdmin().mustInvoke(nil, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+
+	key, _ := e.stub.CreateCompositeKey(RAFFLE_INDEX, []string{raffle.Id})
+	var stored Raffle
+	json.Unmarshal([]byte(e.get(key)), &stored)
+	stored.Winners[0].TicketNumber = (stored.Winners[0].TicketNumber + 1) % 6
+	storedAsBytes, _ := json.Marshal(stored)
+	e.put(key, string(storedAsBytes))
+
+	var verification testRaffleVerification
+	e.as("u2").mustInvoke(&verification, "verifyRaffleDraw", raffle.Id)
+	if verification.Verified || !verification.SeedMatches {
+		t.Fatalf("verification of altered winners is %+v", verification)
+	}
+}
+
+func TestUnrevealedRaffleIsCancelledAndRefunded(t *testing.T) {
+	e, raffle, _ := newRaffleEnv(t)
+
+	e.advance(time.Hour)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "cancelRaffle", raffle.Id)
+	e.advance(RAFFLE_REVEAL_PERIOD)
+	e.asAdmin().mustFail(ERR_INVALID_STATE, "drawRaffle", raffle.Id, TEST_RAFFLE_SEED)
+
+	var cancelled Raffle
+	e.as("u2").mustInvoke(&cancelled, "cancelRaffle", raffle.Id)
+	if cancelled.State != RAFFLE_CANCELLED {
+		t.Fatalf("cancelled raffle is %+v", cancelled)
+	}
+	e.checkBalance("u1", 50)
+	e.checkBalance("u2", 50)
+	e.as("u2").mustFail(ERR_INVALID_STATE, "cancelRaffle", raffle.Id)
+
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Circulating != 100 {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//composite key object types of rate limits, keyed by function name, and of the calls counted against them,
+//keyed by function name, MSP id and certificate subject
+const RATE_LIMIT_INDEX = "rateLimit"
+const RATE_WINDOW_INDEX = "rateWindow"
+
+// An admin can limit how often each identity may call an invoke function: at most MaxCalls calls in any
+// WindowSeconds, measured with the transaction timestamps. Calls are counted per caller certificate, whose
+// subject is the member id the app enrolled the member with. The check runs before the function, so a call
+// over the limit fails without touching the world state. Only successful calls are counted, since a failed
+// call does not commit its count. Calls of one identity in the same block also conflict on the count, so
+// only one of them commits.
+
+// RateLimit of calls to a function by one identity
+type RateLimit struct {
+	Function      string   `json:"function"`
+	MaxCalls      int      `json:"maxCalls"`
+	WindowSeconds int      `json:"windowSeconds"`
+	UpdatedAt     string   `json:"updatedAt"`
+	UpdatedBy     Identity `json:"updatedBy"`
+	Version       int      `json:"schemaVersion"`
+}
+
+// RateWindow holds the times of an identity's calls to a function within the current window
+type RateWindow struct {
+	Function string   `json:"function"`
+	Caller   Identity `json:"caller"`
+	Calls    []string `json:"calls"`
+	Version  int      `json:"schemaVersion"`
+}
+
+type setRateLimitRequest struct {
+	FunctionName  string `json:"functionName" desc:"the invoke function to limit"`
+	MaxCalls      int    `json:"maxCalls" desc:"the calls allowed per identity in a window, 0 removes the limit"`
+	WindowSeconds int    `json:"windowSeconds,omitempty" desc:"the length of the window in seconds, required with a limit"`
+}
+
+func (r *setRateLimitRequest) validate() error {
+	f, ok := functions[r.FunctionName]
+	if !ok {
+		return argError(0, "functionName", "must be a chaincode function")
+	}
+	if f.ReadOnly {
+		return argError(0, "functionName", "must be an invoke function, queries do not change the ledger")
+	}
+	if r.MaxCalls < 0 {
+		return argError(1, "maxCalls", "must not be negative")
+	}
+	if r.MaxCalls > 0 && r.WindowSeconds <= 0 {
+		return argError(2, "windowSeconds", "must be positive")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "setRateLimit",
+		Description: "Limit the calls each identity can make to a function in a time window",
+		Request:     setRateLimitRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).setRateLimit,
+	})
+	registerFunction(Function{
+		Name:        "getRateLimits",
+		Description: "Get the rate limits of all limited functions",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getRateLimits,
+	})
+}
+
+// ============================================================================================================================
+// Set rate limit - maxCalls 0 removes the function's limit
+// Inputs - functionName, maxCalls, windowSeconds
+// ============================================================================================================================
+func (t *SimpleChaincode) setRateLimit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request setRateLimitRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	key, err := stub.CreateCompositeKey(RATE_LIMIT_INDEX, []string{request.FunctionName})
+	if err != nil {
+		return errorResponse(err)
+	}
+	if request.MaxCalls == 0 {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(nil)
+	}
+
+	admin, _, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var limit RateLimit
+	limit.Function = request.FunctionName
+	limit.MaxCalls = request.MaxCalls
+	limit.WindowSeconds = request.WindowSeconds
+	limit.UpdatedAt = txTime.Format(TIMESTAMP_FORMAT)
+	limit.UpdatedBy = admin
+
+	limitAsBytes, err := writeRecord(stub, key, &limit)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(limitAsBytes)
+}
+
+// ============================================================================================================================
+// Get rate limits
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) getRateLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(RATE_LIMIT_INDEX, []string{})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	limits := []RateLimit{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var limit RateLimit
+		err = decodeStrict(aKeyValue.Value, &limit)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not a rate limit: "+err.Error()))
+		}
+		limits = append(limits, limit)
+	}
+
+	limitsAsBytes, _ := json.Marshal(limits)
+	return shim.Success(limitsAsBytes)
+}
+
+// checkRateLimit counts the call against the function's rate limit, refusing it when the caller is over the limit
+func checkRateLimit(stub shim.ChaincodeStubInterface, function string) error {
+	limitKey, err := stub.CreateCompositeKey(RATE_LIMIT_INDEX, []string{function})
+	if err != nil {
+		return err
+	}
+	limitAsBytes, err := stub.GetState(limitKey)
+	if err != nil {
+		return err
+	}
+	if limitAsBytes == nil {
+		return nil
+	}
+	var limit RateLimit
+	err = decodeStrict(limitAsBytes, &limit)
+	if err != nil {
+		return corruptRecordError(limitKey, "is not a rate limit: "+err.Error())
+	}
+
+	//read the caller's calls
+	caller, _, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	windowKey, err := stub.CreateCompositeKey(RATE_WINDOW_INDEX, []string{function, caller.MspId, caller.Subject})
+	if err != nil {
+		return err
+	}
+	windowAsBytes, err := stub.GetState(windowKey)
+	if err != nil {
+		return err
+	}
+	var window RateWindow
+	if windowAsBytes != nil {
+		err = decodeStrict(windowAsBytes, &window)
+		if err != nil {
+			return corruptRecordError(windowKey, "is not a rate window: "+err.Error())
+		}
+	}
+
+	//keep the calls still within the window, oldest first
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	windowLength := time.Duration(limit.WindowSeconds) * time.Second
+	windowStart := txTime.Add(-windowLength).Format(TIMESTAMP_FORMAT)
+	calls := []string{}
+	for _, call := range window.Calls {
+		if call > windowStart {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) >= limit.MaxCalls {
+		//the call is allowed again once enough calls left the window
+		oldest, _ := time.Parse(TIMESTAMP_FORMAT, calls[len(calls)-limit.MaxCalls])
+		return newError(ERR_RATE_LIMITED, "Too many calls to "+function).
+			withDetail("function", function).
+			withDetail("maxCalls", limit.MaxCalls).
+			withDetail("windowSeconds", limit.WindowSeconds).
+			withDetail("retryAt", oldest.Add(windowLength).Format(TIMESTAMP_FORMAT))
+	}
+
+	window.Function = function
+	window.Caller = caller
+	window.Calls = append(calls, txTime.Format(TIMESTAMP_FORMAT))
+	_, err = writeRecord(stub, windowKey, &window)
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitCountsSuccessfulCallsPerIdentity(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.createUser("u2", 0)
+	var limit RateLimit
+	e.asAdmin().mustInvoke(&limit, "setRateLimit", "generateFitcoins", "2", "10")
+	setAt, _ := time.Parse(TIMESTAMP_FORMAT, limit.UpdatedAt)
+
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "100")
+	//a failed call does not commit its count
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "generateFitcoins", "u1", "-1")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "200")
+	failure := e.as("u1").mustFail(ERR_RATE_LIMITED, "generateFitcoins", "u1", "300")
+	retryAt := setAt.Add(11 * time.Second).Format(TIMESTAMP_FORMAT)
+	if failure.Details["retryAt"] != retryAt || failure.Details["maxCalls"] != float64(2) {
+		t.Fatalf("failure details are %v, expected retry at %s", failure.Details, retryAt)
+	}
+
+	//other identities and functions are counted on their own
+	e.as("u2").mustInvoke(nil, "generateFitcoins", "u2", "100")
+	e.as("u1").mustInvoke(nil, "deactivateMember", "u1")
+	e.asAdmin().mustInvoke(nil, "reactivateMember", "u1")
+
+	//the oldest call leaves the window at retryAt
+	e.advance(3 * time.Second)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "300")
+	e.as("u1").mustFail(ERR_RATE_LIMITED, "generateFitcoins", "u1", "400")
+	e.checkBalance("u1", 3)
+}
+
+func TestRateLimitsAreSetByAdmins(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "setRateLimit", "generateFitcoins", "2", "10")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "setRateLimit", "mintAll", "2", "10")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "setRateLimit", "getRateLimits", "2", "10")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "setRateLimit", "generateFitcoins", "-1", "10")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "setRateLimit", "generateFitcoins", "2")
+
+	e.asAdmin().mustInvoke(nil, "setRateLimit", "generateFitcoins", "1", "60")
+	e.asAdmin().mustInvoke(nil, "setRateLimit", "makePurchase", "5", "60")
+	var limits []RateLimit
+	e.as("u1").mustInvoke(&limits, "getRateLimits")
+	if len(limits) != 2 || limits[0].Function != "generateFitcoins" || limits[0].UpdatedBy.Subject != TEST_ADMIN {
+		t.Fatalf("limits are %+v", limits)
+	}
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "100")
+	e.as("u1").mustFail(ERR_RATE_LIMITED, "generateFitcoins", "u1", "200")
+
+	//maxCalls 0 removes the limit
+	e.asAdmin().mustInvoke(nil, "setRateLimit", "generateFitcoins", "0")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "200")
+	e.as("u1").mustInvoke(&limits, "getRateLimits")
+	if len(limits) != 1 {
+		t.Fatalf("limits are %+v", limits)
+	}
+}
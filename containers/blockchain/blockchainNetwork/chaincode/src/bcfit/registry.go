@@ -0,0 +1,136 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//argument types
+const ARG_STRING = "string"
+const ARG_INT = "int"
+const ARG_JSON = "json"
+
+//roles allowed to call a function, member roles are bound to the member id in the request's first field
+const ROLE_ANY = "any"
+const ROLE_MEMBER = "member"
+const ROLE_MEMBER_OR_ADMIN = "memberOrAdmin"
+const ROLE_USER = "user"
+const ROLE_SELLER = "seller"
+const ROLE_ADMIN = "admin"
+
+// roleMemberTypes maps the roles of a single member type to that type
+var roleMemberTypes = map[string]string{
+	ROLE_USER:   TYPE_USER,
+	ROLE_SELLER: TYPE_SELLER,
+}
+
+// Arg describes one argument of a chaincode function, derived from a field of its request struct.
+// Optional args may only be omitted from the end of the positional argument list.
+type Arg struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Optional    bool   `json:"optional,omitempty"`
+}
+
+// Function describes a chaincode function and the handler implementing it.
+// Args is derived from Request, the zero value of the struct the handler decodes its arguments into.
+// Role is checked before the handler runs, see checkRole.
+type Function struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Args        []Arg       `json:"args"`
+	ReadOnly    bool        `json:"readOnly"`
+	Role        string      `json:"role"`
+	Request     interface{} `json:"-"`
+	handler     func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response
+}
+
+// functions registered by name, filled by the init function of each file
+var functions = map[string]Function{}
+
+// registerFunction adds a function to the registry, panicking on duplicate names
+func registerFunction(function Function) {
+	if _, exists := functions[function.Name]; exists {
+		panic("function " + function.Name + " registered twice")
+	}
+	function.Args = requestArgs(function.Request)
+	functions[function.Name] = function
+}
+
+type describeRequest struct {
+	FunctionName string `json:"functionName,omitempty" desc:"name of the function to describe"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "describe",
+		Description: "Describe the chaincode functions, or a single function by name",
+		Request:     describeRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).describe,
+	})
+}
+
+// unknownFunctionError reports a call to a function that is not registered
+func unknownFunctionError(name string) *ChaincodeError {
+	return newError(ERR_UNKNOWN_FUNCTION, "Function with the name "+name+" does not exist.").withDetail("function", name)
+}
+
+// ============================================================================================================================
+// Describe - return the metadata of all registered functions
+// Inputs - (none) or functionName
+// ============================================================================================================================
+func (t *SimpleChaincode) describe(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request describeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//describe a single function
+	if request.FunctionName != "" {
+		function, ok := functions[request.FunctionName]
+		if !ok {
+			return errorResponse(unknownFunctionError(request.FunctionName))
+		}
+		functionAsBytes, _ := json.Marshal(function)
+		return shim.Success(functionAsBytes)
+	}
+
+	//describe all functions, sorted by name
+	var names []string
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var described []Function
+	for _, name := range names {
+		described = append(described, functions[name])
+	}
+	describedAsBytes, _ := json.Marshal(described)
+	return shim.Success(describedAsBytes)
+}
@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEveryFunctionIsDescribed(t *testing.T) {
+	for name, function := range functions {
+		if function.handler == nil || function.Args == nil || function.Description == "" {
+			t.Fatalf("function %s is registered without a handler, args or description", name)
+		}
+		if _, ok := map[string]bool{ROLE_ANY: true, ROLE_MEMBER: true, ROLE_MEMBER_OR_ADMIN: true, ROLE_USER: true, ROLE_SELLER: true, ROLE_ADMIN: true}[function.Role]; !ok {
+			t.Fatalf("function %s has role %q", name, function.Role)
+		}
+		//member roles bind the caller to the first argument
+		if function.Role != ROLE_ANY && function.Role != ROLE_ADMIN && (len(function.Args) == 0 || function.Args[0].Type != ARG_STRING || function.Args[0].Optional) {
+			t.Fatalf("function %s has role %s but no member id argument", name, function.Role)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	e := newTestEnv(t)
+
+	var described []Function
+	e.as("u1").mustInvoke(&described, "describe")
+	if len(described) != len(functions) {
+		t.Fatalf("described %d of %d functions", len(described), len(functions))
+	}
+	for i := 1; i < len(described); i++ {
+		if described[i-1].Name >= described[i].Name {
+			t.Fatalf("functions are not sorted by name: %s before %s", described[i-1].Name, described[i].Name)
+		}
+	}
+
+	var function Function
+	e.as("u1").mustInvoke(&function, "describe", "makePurchase")
+	if function.Role != ROLE_USER || len(function.Args) != 4 || function.Args[3].Name != "quantity" || function.Args[3].Type != ARG_INT {
+		t.Fatalf("makePurchase is described as %+v", function)
+	}
+
+	e.as("u1").mustFail(ERR_UNKNOWN_FUNCTION, "describe", "makePurchases")
+	e.as("u1").mustFail(ERR_UNKNOWN_FUNCTION, "makePurchases")
+}
+
+func TestArgsAreCheckedBeforeTheHandler(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 2000)
+
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT_COUNT, "generateFitcoins", "u1")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT_COUNT, "generateFitcoins", "u1", "3000", "steps", "4000")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "generateFitcoins", "u1", "many")
+	e.checkBalance("u1", 20)
+}
+
+func TestRolesAreCheckedBeforeTheHandler(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 2000)
+	e.createUser("u2", 0)
+	e.createSeller("s1", "p1", 5, 1)
+
+	//a caller only acts for the member of its certificate
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "createMember", "u3", TYPE_USER)
+
+	//and only through functions of its member type
+	e.as("s1").mustFail(ERR_UNAUTHORIZED, "generateFitcoins", "s1", "1000")
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "createProduct", "u1", "p2", "Mug", "1", "1")
+
+	//admin functions need an admin identity
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "adminMint", "u1", "100", "bonus")
+
+	//an unknown member is reported once the caller is bound to it
+	e.as("u9").mustFail(ERR_MEMBER_NOT_FOUND, "makePurchase", "u9", "s1", "p1", "1")
+	e.checkBalance("u1", 20)
+}
@@ -0,0 +1,287 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//composite key object type of reviews, keyed by seller id, product id and contract id
+const REVIEW_INDEX = "review"
+
+//rating range and the longest review text, in characters
+const MIN_RATING = 1
+const MAX_RATING = 5
+const MAX_REVIEW_LENGTH = 500
+
+// Users rate and review what they bought once the contract is complete. Each completed contract gets one
+// review, or for a cart contract one per completed line item. The seller's product keeps the rating count,
+// total and average, so products can be listed with their ratings without reading the reviews.
+
+// Review of a product by the user of a completed contract
+type Review struct {
+	ContractId string `json:"contractId"`
+	UserId     string `json:"userId"`
+	SellerId   string `json:"sellerId"`
+	ProductId  string `json:"productId"`
+	Rating     int    `json:"rating"`
+	Text       string `json:"text,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+	Version    int    `json:"schemaVersion"`
+}
+
+type reviewProductRequest struct {
+	UserId     string `json:"userId" desc:"the user's id"`
+	ContractId string `json:"contractId" desc:"the completed contract the product was bought with"`
+	Rating     int    `json:"rating" desc:"1 to 5"`
+	Text       string `json:"text,omitempty" desc:"a short review"`
+	SellerId   string `json:"sellerId,omitempty" desc:"cart contracts only, the seller of the reviewed line item"`
+	ProductId  string `json:"productId,omitempty" desc:"cart contracts only, the product of the reviewed line item"`
+}
+
+func (r *reviewProductRequest) validate() error {
+	if r.Rating < MIN_RATING || r.Rating > MAX_RATING {
+		return argError(2, "rating", "must be between "+strconv.Itoa(MIN_RATING)+" and "+strconv.Itoa(MAX_RATING))
+	}
+	if utf8.RuneCountInString(r.Text) > MAX_REVIEW_LENGTH || !isPrintable(r.Text) {
+		return argError(3, "text", "must be at most "+strconv.Itoa(MAX_REVIEW_LENGTH)+" printable characters")
+	}
+	return nil
+}
+
+type getProductReviewsRequest struct {
+	SellerId  string `json:"sellerId" desc:"the seller's id"`
+	ProductId string `json:"productId" desc:"the id of the product with the seller"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "reviewProduct",
+		Description: "Rate and review the product of a completed contract",
+		Request:     reviewProductRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).reviewProduct,
+	})
+	registerFunction(Function{
+		Name:        "getProductReviews",
+		Description: "Get the reviews of a product, newest first",
+		Request:     getProductReviewsRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getProductReviews,
+	})
+}
+
+// ============================================================================================================================
+// Review product
+// Inputs - userId, contractId, rating, text, and for cart contracts sellerId, productId
+// ============================================================================================================================
+func (t *SimpleChaincode) reviewProduct(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request reviewProductRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get user
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//only the buyer reviews, once the contract is complete
+	contract, err := loadContract(stub, request.ContractId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if contract.UserId != user.Id {
+		return errorResponse(newError(ERR_UNAUTHORIZED, "Only the contract's user can review it").
+			withDetail("memberId", user.Id).
+			withDetail("contractId", contract.Id))
+	}
+	if contract.State != STATE_COMPLETE {
+		return errorResponse(newError(ERR_INVALID_STATE, "Only complete contracts can be reviewed").
+			withDetail("contractId", contract.Id).
+			withDetail("state", contract.State))
+	}
+	sellerId, productId, err := reviewedProduct(contract, request.SellerId, request.ProductId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//one review per contract and product
+	key, err := stub.CreateCompositeKey(REVIEW_INDEX, []string{sellerId, productId, contract.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingAsBytes != nil {
+		return errorResponse(newError(ERR_ALREADY_EXISTS, "Contract "+contract.Id+" is already reviewed").
+			withDetail("contractId", contract.Id).
+			withDetail("productId", productId))
+	}
+
+	//add the rating to the product's running average
+	seller, err := loadSeller(stub, sellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	productIndex := -1
+	for h := 0; h < len(seller.Products); h++ {
+		if seller.Products[h].Id == productId {
+			productIndex = h
+			break
+		}
+	}
+	if productIndex < 0 {
+		return errorResponse(productNotFoundError(sellerId, productId))
+	}
+	addRating(&seller.Products[productIndex], request.Rating)
+	_, err = writeRecord(stub, seller.Id, &seller)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var review Review
+	review.ContractId = contract.Id
+	review.UserId = user.Id
+	review.SellerId = sellerId
+	review.ProductId = productId
+	review.Rating = request.Rating
+	review.Text = request.Text
+	review.CreatedAt = txTime.Format(TIMESTAMP_FORMAT)
+
+	reviewAsBytes, err := writeRecord(stub, key, &review)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(reviewAsBytes)
+}
+
+// ============================================================================================================================
+// Get product reviews
+// Inputs - sellerId, productId
+// ============================================================================================================================
+func (t *SimpleChaincode) getProductReviews(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getProductReviewsRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	seller, err := loadSeller(stub, request.SellerId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	product, found := findProduct(seller, request.ProductId)
+	if !found {
+		return errorResponse(productNotFoundError(seller.Id, request.ProductId))
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(REVIEW_INDEX, []string{seller.Id, product.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	reviews := []Review{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		var review Review
+		err = decodeStrict(aKeyValue.Value, &review)
+		if err != nil {
+			return errorResponse(corruptRecordError(aKeyValue.Key, "is not a review: "+err.Error()))
+		}
+		reviews = append(reviews, review)
+	}
+
+	//keys are ordered by contract id
+	sort.Stable(reviewsNewestFirst(reviews))
+
+	//return the product with its Reviews
+	type ReturnReviews struct {
+		Product
+		SellerId string   `json:"sellerId"`
+		Reviews  []Review `json:"reviews"`
+	}
+	var returnReviews ReturnReviews
+	returnReviews.Product = product
+	returnReviews.SellerId = seller.Id
+	returnReviews.Reviews = reviews
+
+	returnReviewsBytes, _ := json.Marshal(returnReviews)
+	return shim.Success(returnReviewsBytes)
+}
+
+// reviewedProduct returns the seller and product a review of the contract is about, a completed line item for carts
+func reviewedProduct(contract Contract, sellerId string, productId string) (string, string, error) {
+	if len(contract.Items) == 0 {
+		return contract.SellerId, contract.ProductId, nil
+	}
+	for _, item := range contract.Items {
+		if item.SellerId == sellerId && item.ProductId == productId {
+			if item.State != STATE_COMPLETE {
+				return "", "", newError(ERR_INVALID_STATE, "Only complete line items can be reviewed").
+					withDetail("contractId", contract.Id).
+					withDetail("productId", productId).
+					withDetail("state", item.State)
+			}
+			return sellerId, productId, nil
+		}
+	}
+	return "", "", newError(ERR_INVALID_ARGUMENT, "Cart contracts are reviewed per line item, sellerId and productId must name one").
+		withDetail("contractId", contract.Id).
+		withDetail("sellerId", sellerId).
+		withDetail("productId", productId)
+}
+
+// addRating adds a rating to the product's count, total and average, rounded to two decimals
+func addRating(product *Product, rating int) {
+	product.RatingCount = product.RatingCount + 1
+	product.RatingTotal = product.RatingTotal + rating
+	product.AverageRating = float64((product.RatingTotal*100+product.RatingCount/2)/product.RatingCount) / 100
+}
+
+// reviewsNewestFirst sorts reviews newest first
+type reviewsNewestFirst []Review
+
+func (r reviewsNewestFirst) Len() int           { return len(r) }
+func (r reviewsNewestFirst) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r reviewsNewestFirst) Less(i, j int) bool { return r[i].CreatedAt > r[j].CreatedAt }
@@ -0,0 +1,81 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+// testProductReviews is a product with its reviews as getProductReviews returns it
+type testProductReviews struct {
+	RatingCount   int      `json:"ratingCount"`
+	AverageRating float64  `json:"averageRating"`
+	Reviews       []Review `json:"reviews"`
+}
+
+func TestCompletedPurchasesAreReviewedOnce(t *testing.T) {
+	e, first := newContractEnv(t)
+	e.createUser("u2", 0)
+	var second Contract
+	e.as("u1").mustInvoke(&second, "makePurchase", "u1", "s1", "p1", "1")
+
+	e.as("u1").mustFail(ERR_INVALID_STATE, "reviewProduct", "u1", first.Id, "5")
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", first.Id, STATE_COMPLETE, e.pickupSecret(first.Id, ""))
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", second.Id, STATE_COMPLETE, e.pickupSecret(second.Id, ""))
+
+	e.as("u2").mustFail(ERR_UNAUTHORIZED, "reviewProduct", "u2", first.Id, "1")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "reviewProduct", "u1", first.Id, "6")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "reviewProduct", "u1", first.Id, "0")
+	e.as("u1").mustInvoke(nil, "reviewProduct", "u1", first.Id, "5", "Great")
+	e.as("u1").mustFail(ERR_ALREADY_EXISTS, "reviewProduct", "u1", first.Id, "1")
+	e.as("u1").mustInvoke(nil, "reviewProduct", "u1", second.Id, "4")
+
+	var reviews testProductReviews
+	e.as("u2").mustInvoke(&reviews, "getProductReviews", "s1", "p1")
+	if reviews.RatingCount != 2 || reviews.AverageRating != 4.5 || len(reviews.Reviews) != 2 {
+		t.Fatalf("reviews are %+v", reviews)
+	}
+	if reviews.Reviews[0].ContractId != second.Id || reviews.Reviews[1].Text != "Great" {
+		t.Fatalf("reviews are not newest first: %+v", reviews.Reviews)
+	}
+	e.as("u2").mustFail(ERR_PRODUCT_NOT_FOUND, "getProductReviews", "s1", "p9")
+}
+
+func TestCartItemsAreReviewedPerLineItem(t *testing.T) {
+	e := newCartEnv(t)
+	var cart Contract
+	e.as("u1").mustInvoke(&cart, "makeCartPurchase", "u1", testCart)
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", cart.Id, STATE_COMPLETE, e.pickupSecret(cart.Id, "s1"))
+
+	//a cart with a pending item is not complete yet
+	e.as("u1").mustFail(ERR_INVALID_STATE, "reviewProduct", "u1", cart.Id, "3", "", "s1", "p1")
+	e.as("s2").mustInvoke(nil, "transactPurchase", "s2", cart.Id, STATE_DECLINED)
+
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "reviewProduct", "u1", cart.Id, "3")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "reviewProduct", "u1", cart.Id, "3", "", "s2", "p1")
+	e.as("u1").mustFail(ERR_INVALID_STATE, "reviewProduct", "u1", cart.Id, "3", "", "s2", "p2")
+	e.as("u1").mustInvoke(nil, "reviewProduct", "u1", cart.Id, "3", "", "s1", "p1")
+	e.as("u1").mustInvoke(nil, "reviewProduct", "u1", cart.Id, "2", "", "s1", "p3")
+	e.as("u1").mustFail(ERR_ALREADY_EXISTS, "reviewProduct", "u1", cart.Id, "5", "", "s1", "p1")
+
+	if product, _ := findProduct(e.seller("s1"), "p1"); product.RatingCount != 1 || product.AverageRating != 3 {
+		t.Fatalf("product is %+v", product)
+	}
+}
@@ -0,0 +1,530 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//current schema version written on every stored record
+const SCHEMA_VERSION = 12
+
+//record kind for contracts, stored in their recordType field (members use their member type as kind)
+const KIND_CONTRACT = "contract"
+
+//schema version that added the recordType field, contracts stored before it carry no type
+const CONTRACT_TYPE_VERSION = 1
+
+//simple keys sort between these bounds, composite keys start with 0x00 and sort before them
+const FIRST_SIMPLE_KEY = "\x01"
+const LAST_SIMPLE_KEY = "\U0010FFFF"
+
+//keys scanned by one migrateState call, by default and at most
+const MIGRATION_PAGE_SIZE = 100
+const MAX_MIGRATION_PAGE_SIZE = 1000
+
+// migration upgrades a raw record from the previous schema version to the version it is registered under
+type migration func(record map[string]interface{}) error
+
+// migrations holds, per record kind, the migrations by the schema version they upgrade to. A version without
+// a migration did not change the kind's shape. Records stored before versioning was introduced have no
+// schemaVersion and are version 0.
+var migrations = map[string]map[int]migration{
+	TYPE_USER:     {2: migrateMemberStatus, 3: migrateMemberFrozen},
+	TYPE_SELLER:   {2: migrateMemberStatus, 3: migrateMemberFrozen},
+	KIND_CONTRACT: {CONTRACT_TYPE_VERSION: migrateContractType, 4: migrateContractDiscount},
+}
+
+type migrateStateRequest struct {
+	StartKey string `json:"startKey,omitempty" desc:"the key to continue from, the nextStartKey of the previous call"`
+	Limit    int    `json:"limit,omitempty" desc:"the most keys to scan, 100 when omitted"`
+}
+
+func (r *migrateStateRequest) validate() error {
+	if r.StartKey != "" && r.StartKey < FIRST_SIMPLE_KEY {
+		return argError(0, "startKey", "must be a simple key")
+	}
+	if r.Limit < 0 || r.Limit > MAX_MIGRATION_PAGE_SIZE {
+		return argError(1, "limit", "must be between 1 and "+strconv.Itoa(MAX_MIGRATION_PAGE_SIZE))
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "migrateState",
+		Description: "Upgrade a page of stored member and contract records to the current schema version",
+		Request:     migrateStateRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).migrateState,
+	})
+}
+
+// versioned is implemented by every record stored through writeRecord
+type versioned interface {
+	setSchemaVersion(version int)
+}
+
+func (m *Member) setSchemaVersion(version int) {
+	m.Version = version
+}
+
+func (c *Contract) setSchemaVersion(version int) {
+	c.Version = version
+}
+
+func (a *AuditRecord) setSchemaVersion(version int) {
+	a.Version = version
+}
+
+func (s *SupplyRecord) setSchemaVersion(version int) {
+	s.Version = version
+}
+
+func (c *Coupon) setSchemaVersion(version int) {
+	c.Version = version
+}
+
+func (u *CouponUse) setSchemaVersion(version int) {
+	u.Version = version
+}
+
+func (a *Auction) setSchemaVersion(version int) {
+	a.Version = version
+}
+
+func (r *Raffle) setSchemaVersion(version int) {
+	r.Version = version
+}
+
+func (r *RaffleTicket) setSchemaVersion(version int) {
+	r.Version = version
+}
+
+func (b *Badge) setSchemaVersion(version int) {
+	b.Version = version
+}
+
+func (t *Team) setSchemaVersion(version int) {
+	t.Version = version
+}
+
+func (t *TeamSteps) setSchemaVersion(version int) {
+	t.Version = version
+}
+
+func (c *Challenge) setSchemaVersion(version int) {
+	c.Version = version
+}
+
+func (p *ExpiryPolicy) setSchemaVersion(version int) {
+	p.Version = version
+}
+
+func (b *CoinBatch) setSchemaVersion(version int) {
+	b.Version = version
+}
+
+func (s *Season) setSchemaVersion(version int) {
+	s.Version = version
+}
+
+func (s *SeasonBalance) setSchemaVersion(version int) {
+	s.Version = version
+}
+
+func (p *Payout) setSchemaVersion(version int) {
+	p.Version = version
+}
+
+func (r *Review) setSchemaVersion(version int) {
+	r.Version = version
+}
+
+func (r *RateLimit) setSchemaVersion(version int) {
+	r.Version = version
+}
+
+func (w *RateWindow) setSchemaVersion(version int) {
+	w.Version = version
+}
+
+func (c *IdempotentCall) setSchemaVersion(version int) {
+	c.Version = version
+}
+
+func (d *DailySteps) setSchemaVersion(version int) {
+	d.Version = version
+}
+
+func (a *ActivityType) setSchemaVersion(version int) {
+	a.Version = version
+}
+
+// version 1 added the recordType field, stored contracts are typed as contracts
+func migrateContractType(record map[string]interface{}) error {
+	record["recordType"] = KIND_CONTRACT
+	return nil
+}
+
+// version 2 added the member status, every member created before it is active
+func migrateMemberStatus(record map[string]interface{}) error {
+	record["status"] = STATUS_ACTIVE
+	return nil
+}
+
+// version 3 added freezing by admins, no member was frozen before it
+func migrateMemberFrozen(record map[string]interface{}) error {
+	record["frozen"] = false
+	return nil
+}
+
+// version 4 added coupon discounts to contracts, no contract was discounted before it
+func migrateContractDiscount(record map[string]interface{}) error {
+	record["discount"] = 0
+	return nil
+}
+
+// decodeRecord upgrades raw record bytes stored under key and strictly decodes them into v
+func decodeRecord(key string, recordAsBytes []byte, kind string, v interface{}) error {
+	record, err := parseRecord(key, recordAsBytes)
+	if err != nil {
+		//valid JSON that is not an object, such as the sellerIds index, is another kind of record
+		if validJSON(recordAsBytes) {
+			return wrongTypeError(key, kind)
+		}
+		return err
+	}
+	recordType := recordKind(record)
+	if recordType == "" && kind == KIND_CONTRACT {
+		//contracts stored before they carried a type are only known by the key they are read with
+		version, err := recordVersion(key, record)
+		if err != nil {
+			return err
+		}
+		if version < CONTRACT_TYPE_VERSION {
+			recordType = KIND_CONTRACT
+		}
+	}
+	if recordType != kind {
+		return wrongTypeError(key, kind)
+	}
+	if err = upgradeRecord(key, kind, record); err != nil {
+		return err
+	}
+	upgradedAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Failed to encode record %s: %s", key, err.Error())
+	}
+	if err = decodeStrict(upgradedAsBytes, v); err != nil {
+		return corruptRecordError(key, "does not match the "+kind+" schema: "+err.Error())
+	}
+	return nil
+}
+
+// writeRecord stamps v with the current schema version and stores it under key
+func writeRecord(stub shim.ChaincodeStubInterface, key string, v versioned) ([]byte, error) {
+	v.setSchemaVersion(SCHEMA_VERSION)
+	recordAsBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, recordAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return recordAsBytes, nil
+}
+
+// corruptRecordError reports a stored record that cannot be read in the current schema
+func corruptRecordError(key string, problem string) *ChaincodeError {
+	return newError(ERR_CORRUPT_RECORD, "Record "+key+" "+problem).withDetail("key", key)
+}
+
+// decodeStrict decodes a single JSON value into v, rejecting unknown fields and trailing data
+func decodeStrict(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	return checkKnownFields(data, reflect.TypeOf(v))
+}
+
+// checkKnownFields fails on the first object member in data that no json tag of t, or of its elements, matches
+func checkKnownFields(data json.RawMessage, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return nil
+	}
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(data, &members); err != nil {
+			return err
+		}
+		fields := map[string]reflect.Type{}
+		jsonFields(t, fields)
+		for name, value := range members {
+			fieldType, ok := fields[name]
+			if !ok {
+				for fieldName, aType := range fields {
+					if strings.EqualFold(fieldName, name) {
+						fieldType, ok = aType, true
+						break
+					}
+				}
+			}
+			if !ok {
+				return fmt.Errorf("json: unknown field %q", name)
+			}
+			if err := checkKnownFields(value, fieldType); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return err
+		}
+		for _, element := range elements {
+			if err := checkKnownFields(element, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var elements map[string]json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return err
+		}
+		for _, element := range elements {
+			if err := checkKnownFields(element, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFields adds the json names of t's fields to fields, including those promoted from embedded structs
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				jsonFields(embedded, fields)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+}
+
+// validJSON tells whether data is a single JSON value
+func validJSON(data []byte) bool {
+	var value json.RawMessage
+	return json.Unmarshal(data, &value) == nil
+}
+
+// parseRecord decodes stored bytes into a generic record, keeping numbers exact
+func parseRecord(key string, recordAsBytes []byte) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(recordAsBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil || record == nil {
+		return nil, corruptRecordError(key, "is not a JSON object")
+	}
+	return record, nil
+}
+
+// newRecord returns an empty record of the given kind, nil for unversioned kinds
+func newRecord(kind string) versioned {
+	if kind == TYPE_USER {
+		return &User{}
+	} else if kind == TYPE_SELLER {
+		return &Seller{}
+	} else if kind == KIND_CONTRACT {
+		return &Contract{}
+	}
+	return nil
+}
+
+// recordKind identifies a generic record by its explicit type: members by their memberType, others by their recordType
+func recordKind(record map[string]interface{}) string {
+	if memberType, ok := record["memberType"].(string); ok {
+		return memberType
+	}
+	if recordType, ok := record["recordType"].(string); ok {
+		return recordType
+	}
+	return ""
+}
+
+// recordVersion reads the schema version of a generic record, 0 when it has none
+func recordVersion(key string, record map[string]interface{}) (int, error) {
+	raw, ok := record["schemaVersion"]
+	if !ok {
+		return 0, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, corruptRecordError(key, "has an invalid schemaVersion")
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, corruptRecordError(key, "has an invalid schemaVersion")
+	}
+	return int(version), nil
+}
+
+// upgradeRecord runs the registered migrations until the record reaches SCHEMA_VERSION
+func upgradeRecord(key string, kind string, record map[string]interface{}) error {
+	version, err := recordVersion(key, record)
+	if err != nil {
+		return err
+	}
+	if version > SCHEMA_VERSION {
+		return newError(ERR_UNSUPPORTED_SCHEMA, fmt.Sprintf("Record %s has schema version %d, newer than supported version %d", key, version, SCHEMA_VERSION)).
+			withDetail("key", key).
+			withDetail("schemaVersion", version)
+	}
+	for version < SCHEMA_VERSION {
+		version = version + 1
+		step, ok := migrations[kind][version]
+		if !ok {
+			continue
+		}
+		if err = step(record); err != nil {
+			return corruptRecordError(key, fmt.Sprintf("failed to migrate to schema version %d: %s", version, err.Error()))
+		}
+	}
+	record["schemaVersion"] = SCHEMA_VERSION
+	return nil
+}
+
+// ============================================================================================================================
+// Migrate state - upgrade the member and contract records of a page of simple keys to the current schema version
+// Inputs - (none), or startKey and limit to continue from the nextStartKey of the previous page
+// ============================================================================================================================
+func (t *SimpleChaincode) migrateState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request migrateStateRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	startKey := request.StartKey
+	if startKey == "" {
+		startKey = FIRST_SIMPLE_KEY
+	}
+	limit := request.Limit
+	if limit == 0 {
+		limit = MIGRATION_PAGE_SIZE
+	}
+
+	//composite keys only hold unversioned index and log records, so the scan starts after them
+	resultsIterator, err := stub.GetStateByRange(startKey, LAST_SIMPLE_KEY)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer resultsIterator.Close()
+
+	type MigrationResult struct {
+		Scanned      int    `json:"scanned"`
+		Migrated     int    `json:"migrated"`
+		NextStartKey string `json:"nextStartKey,omitempty"`
+	}
+	var result MigrationResult
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(err)
+		}
+		if result.Scanned == limit {
+			result.NextStartKey = aKeyValue.Key
+			break
+		}
+		result.Scanned++
+
+		//only typed member and contract records are versioned, and the untyped contracts stored before them
+		record, err := parseRecord(aKeyValue.Key, aKeyValue.Value)
+		if err != nil {
+			continue
+		}
+		kind := recordKind(record)
+		if kind == "" && isContractKey(aKeyValue.Key) {
+			kind = KIND_CONTRACT
+		}
+		upgraded := newRecord(kind)
+		if upgraded == nil {
+			continue
+		}
+		version, err := recordVersion(aKeyValue.Key, record)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if version == SCHEMA_VERSION {
+			continue
+		}
+
+		//upgrade and store the record in the current schema
+		err = decodeRecord(aKeyValue.Key, aKeyValue.Value, kind, upgraded)
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = writeRecord(stub, aKeyValue.Key, upgraded)
+		if err != nil {
+			return errorResponse(err)
+		}
+		result.Migrated++
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,136 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnversionedMemberIsUpgradedOnRead(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("u1", `{"id":"u1","memberType":"user","fitcoinsBalance":5,"totalSteps":500,"stepsUsedForConversion":500,"contractIds":[]}`)
+
+	var user User
+	e.mustInvoke(&user, "getState", "u1")
+	if user.Status != STATUS_ACTIVE || user.Frozen || user.Version != SCHEMA_VERSION || user.FitcoinsBalance != 5 {
+		t.Fatalf("upgraded user is %+v", user)
+	}
+
+	//reading does not write
+	if strings.Contains(e.get("u1"), "schemaVersion") {
+		t.Fatalf("getState rewrote the record: %s", e.get("u1"))
+	}
+}
+
+func TestUntypedContractIsReadAsContract(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("c123456", `{"id":"c123456","sellerId":"s1","userId":"u1","productId":"p1","productName":"Shirt","quantity":1,"cost":3,"state":"pending"}`)
+
+	contract := e.contract("c123456")
+	if contract.Type != KIND_CONTRACT || contract.Version != SCHEMA_VERSION {
+		t.Fatalf("upgraded contract is %+v", contract)
+	}
+
+	//an untyped record in the current schema is not a contract
+	e.put("c654321", `{"id":"c654321","state":"pending","schemaVersion":1}`)
+	_, err := loadContract(e.stub, "c654321")
+	if chaincodeError, ok := err.(*ChaincodeError); !ok || chaincodeError.Code != ERR_WRONG_RECORD_TYPE {
+		t.Fatalf("loading an untyped current record returned %v", err)
+	}
+}
+
+func TestRecordsThatDoNotMatchTheSchemaAreRefused(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("u1", `{"id":"u1","memberType":"user","fitcoinsBalance":5,"schemaVersion":99}`)
+	e.put("u2", `{"id":"u2","memberType":"user","fitcoinsBalance":5,"nickname":"x","schemaVersion":1}`)
+	e.put("u3", `{"id":"u3","memberType":"user","fitcoinsBalance":"5","schemaVersion":1}`)
+
+	e.mustFail(ERR_UNSUPPORTED_SCHEMA, "getState", "u1")
+	e.mustFail(ERR_CORRUPT_RECORD, "getState", "u2")
+	e.mustFail(ERR_CORRUPT_RECORD, "getState", "u3")
+}
+
+func TestDecodeStrictRejectsUnknownNestedFields(t *testing.T) {
+	var seller Seller
+	err := decodeStrict([]byte(`{"id":"s1","memberType":"seller","products":[{"id":"p1","count":1,"extra":2}]}`), &seller)
+	if err == nil || !strings.Contains(err.Error(), `"extra"`) {
+		t.Fatalf("decoding an unknown nested field returned %v", err)
+	}
+	err = decodeStrict([]byte(`{"id":"s1","memberType":"seller"} {}`), &seller)
+	if err == nil {
+		t.Fatalf("decoding trailing data succeeded")
+	}
+	err = decodeStrict([]byte(`{"ID":"s1","memberType":"seller","fitcoinsBalance":2}`), &seller)
+	if err != nil || seller.Id != "s1" {
+		t.Fatalf("decoding a field in another case returned %v, %+v", err, seller)
+	}
+}
+
+func TestMigrateStatePagesThroughSimpleKeys(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("c000001", `{"id":"c000001","sellerId":"s1","userId":"u1","productId":"p1","productName":"Shirt","quantity":1,"cost":3,"state":"pending"}`)
+	e.put("s1", `{"id":"s1","memberType":"seller","fitcoinsBalance":0,"products":[]}`)
+	e.put("u1", `{"id":"u1","memberType":"user","fitcoinsBalance":5,"totalSteps":500,"stepsUsedForConversion":500,"contractIds":["c000001"]}`)
+
+	e.as("u1").mustFail(ERR_UNAUTHORIZED, "migrateState")
+	e.asAdmin().mustFail(ERR_INVALID_ARGUMENT, "migrateState", "", "1001")
+
+	type migrationResult struct {
+		Scanned      int    `json:"scanned"`
+		Migrated     int    `json:"migrated"`
+		NextStartKey string `json:"nextStartKey"`
+	}
+	//other records count towards the page but are left alone
+	var first migrationResult
+	e.asAdmin().mustInvoke(&first, "migrateState", "", "2")
+	if first.Scanned != 2 || first.NextStartKey == "" {
+		t.Fatalf("first page is %+v", first)
+	}
+	migrated := first.Migrated
+	page := first
+	for page.NextStartKey != "" {
+		startKey := page.NextStartKey
+		page = migrationResult{}
+		e.asAdmin().mustInvoke(&page, "migrateState", startKey, "2")
+		migrated = migrated + page.Migrated
+	}
+	if migrated != 3 {
+		t.Fatalf("migrated %d records, expected 3", migrated)
+	}
+
+	//every record is stored in the current schema, typed, and migrating again changes nothing
+	for _, key := range []string{"c000001", "s1", "u1"} {
+		var record map[string]interface{}
+		json.Unmarshal([]byte(e.get(key)), &record)
+		if record["schemaVersion"] != float64(SCHEMA_VERSION) {
+			t.Fatalf("%s is stored as %s", key, e.get(key))
+		}
+	}
+	if e.contract("c000001").Type != KIND_CONTRACT || e.seller("s1").Status != STATUS_ACTIVE {
+		t.Fatalf("migrated records are %s and %s", e.get("c000001"), e.get("s1"))
+	}
+	var again migrationResult
+	e.asAdmin().mustInvoke(&again, "migrateState")
+	if again.Migrated != 0 {
+		t.Fatalf("migrating again is %+v", again)
+	}
+}
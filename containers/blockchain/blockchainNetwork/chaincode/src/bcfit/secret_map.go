@@ -34,11 +34,19 @@ const STEPS_TO_FITCOIN = 100
 const STATE_COMPLETE = "complete"
 const STATE_PENDING = "pending"
 const STATE_DECLINED = "declined"
+const STATE_ACCEPTED = "accepted"
+const STATE_READY = "ready"
+const STATE_CANCELLED = "cancelled"
 
 //member type
 const TYPE_USER = "user"
 const TYPE_SELLER = "seller"
 
+//member status
+const STATUS_ACTIVE = "active"
+const STATUS_INACTIVE = "inactive"
+const STATUS_CLOSED = "closed"
+
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
 }
@@ -48,40 +56,108 @@ type Member struct {
 	Id              string `json:"id"`
 	Type            string `json:"memberType"`
 	FitcoinsBalance int    `json:"fitcoinsBalance"`
+	Status          string `json:"status"`
+	Frozen          bool   `json:"frozen"`
+	DisplayName     string `json:"displayName,omitempty"`
+	AvatarHash      string `json:"avatarHash,omitempty"`
+	Version         int    `json:"schemaVersion"`
 }
 
 // User
 type User struct {
 	Member
-	TotalSteps             int      `json:"totalSteps"`
-	StepsUsedForConversion int      `json:"stepsUsedForConversion"`
-	ContractIds            []string `json:"contractIds"`
+	TotalSteps             int                      `json:"totalSteps"`
+	StepsUsedForConversion int                      `json:"stepsUsedForConversion"`
+	ContractIds            []string                 `json:"contractIds"`
+	TeamId                 string                   `json:"teamId,omitempty"`
+	Activities             map[string]ActivityTotal `json:"activities,omitempty"`
 }
 
 // Seller
 type Seller struct {
 	Member
-	Products []Product `json:"products"`
+	Products    []Product `json:"products"`
+	Description string    `json:"description,omitempty"`
+	Contact     string    `json:"contact,omitempty"`
+	BoothId     string    `json:"boothId,omitempty"`
 }
 
 // Product
 type Product struct {
-	Id    string `json:"id"`
-	Name  string `json:"name"`
-	Count int    `json:"count"`
-	Price int    `json:"price"`
+	Id            string  `json:"id"`
+	Name          string  `json:"name"`
+	Count         int     `json:"count"`
+	Price         int     `json:"price"`
+	RatingCount   int     `json:"ratingCount,omitempty"`
+	RatingTotal   int     `json:"ratingTotal,omitempty"`
+	AverageRating float64 `json:"averageRating,omitempty"`
 }
 
 // Contract
 type Contract struct {
-	Id          string `json:"id"`
+	Id          string        `json:"id"`
+	Type        string        `json:"recordType"`
+	SellerId    string        `json:"sellerId"`
+	UserId      string        `json:"userId"`
+	ProductId   string        `json:"productId"`
+	ProductName string        `json:"productName"`
+	Quantity    int           `json:"quantity"`
+	Cost        int           `json:"cost"`
+	Discount    int           `json:"discount"`
+	CouponHash  string        `json:"couponHash,omitempty"`
+	State       string        `json:"state"`
+	Items       []LineItem    `json:"items,omitempty"`
+	AuctionId   string        `json:"auctionId,omitempty"`
+	PickupHash  string        `json:"pickupHash,omitempty"`
+	RedeemedAt  string        `json:"redeemedAt,omitempty"`
+	History     []StateChange `json:"history,omitempty"`
+	Version     int           `json:"schemaVersion"`
+}
+
+// StateChange of a contract, made by a member at the transaction time
+type StateChange struct {
+	State     string `json:"state"`
+	MemberId  string `json:"memberId,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// LineItem of a cart contract
+type LineItem struct {
 	SellerId    string `json:"sellerId"`
-	UserId      string `json:"userId"`
 	ProductId   string `json:"productId"`
 	ProductName string `json:"productName"`
 	Quantity    int    `json:"quantity"`
 	Cost        int    `json:"cost"`
 	State       string `json:"state"`
+	PickupHash  string `json:"pickupHash,omitempty"`
+	RedeemedAt  string `json:"redeemedAt,omitempty"`
+}
+
+// AuditRecord of an admin action, written once and never updated
+type AuditRecord struct {
+	Id        string   `json:"id"`
+	Action    string   `json:"action"`
+	MemberId  string   `json:"memberId"`
+	Amount    int      `json:"amount"`
+	Reason    string   `json:"reason"`
+	Actor     Identity `json:"actor"`
+	Timestamp string   `json:"timestamp"`
+	Version   int      `json:"schemaVersion"`
+}
+
+type getStateRequest struct {
+	Id string `json:"id" desc:"userId, sellerId or contractId"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "getState",
+		Description: "Get the state stored for a user, seller or contract id",
+		Request:     getStateRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getState,
+	})
 }
 
 // ============================================================================================================================
@@ -96,16 +172,46 @@ func main() {
 
 // ============================================================================================================================
 // Init - initialize the chaincode
+// Inputs - (none) or the admin MSP ids and admin identities, as MSP id/certificate subject
 // ============================================================================================================================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	stub = newTxStub(stub)
+
+	//store admin MSP ids, an upgrade without args keeps the existing ones
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) > 0 {
+		adminMspsBytes, _ := json.Marshal(args)
+		err := stub.PutState(ADMIN_MSPS_KEY, adminMspsBytes)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	//record fitcoins minted before the supply ledger existed
+	err := seedSupply(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//keep existing sellerIds when the chaincode is upgraded
+	existingSellerIdsBytes, err := stub.GetState("sellerIds")
+	if err != nil {
+		return errorResponse(newError(ERR_INTERNAL, "Error initializing sellers."))
+	}
+	if existingSellerIdsBytes != nil {
+		return shim.Success(nil)
+	}
 
 	//store sellerIds
 	var sellerIds []string
 	sellerIdsBytes, err := json.Marshal(sellerIds)
 	if err != nil {
-		return shim.Error("Error initializing sellers.")
+		return errorResponse(newError(ERR_INTERNAL, "Error initializing sellers."))
 	}
 	err = stub.PutState("sellerIds", sellerIdsBytes)
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	return shim.Success(nil)
 }
@@ -114,36 +220,50 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 // Invoke - Our entry point for Invocations
 // ============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	stub = newTxStub(stub)
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println(" ")
 	fmt.Println("starting invoke, for - " + function)
 
-	//call functions
-	if function == "createMember" {
-		return t.createMember(stub, args)
-	} else if function == "generateFitcoins" {
-		return t.generateFitcoins(stub, args)
-	} else if function == "getState" {
-		return t.getState(stub, args)
-	} else if function == "createProduct" {
-		return t.createProduct(stub, args)
-	} else if function == "updateProduct" {
-		return t.updateProduct(stub, args)
-	} else if function == "getProductByID" {
-		return t.getProductByID(stub, args)
-	} else if function == "getProductsForSale" {
-		return t.getProductsForSale(stub, args)
-	} else if function == "makePurchase" {
-		return t.makePurchase(stub, args)
-	} else if function == "transactPurchase" {
-		return t.transactPurchase(stub, args)
-	} else if function == "getAllUserContracts" {
-		return t.getAllUserContracts(stub, args)
-	} else if function == "getAllContracts" {
-		return t.getAllContracts(stub, args)
-	}
-
-	return shim.Error("Function with the name " + function + " does not exist.")
+	//look up the function, its handler decodes the args
+	f, ok := functions[function]
+	if !ok {
+		return errorResponse(unknownFunctionError(function))
+	}
+
+	//refuse callers without the function's role
+	err := checkRole(stub, f, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if f.ReadOnly {
+		return f.handler(t, stub, args)
+	}
+
+	//a call repeating an idempotency key gets the result of the call that first used it
+	call, err := readIdempotentCall(stub, function, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if call.TxId != "" {
+		return shim.Success([]byte(call.Result))
+	}
+
+	//count the call against the function's rate limit
+	err = checkRateLimit(stub, function)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	response := f.handler(t, stub, args)
+	if response.Status == shim.OK && call.Key != "" {
+		err = writeIdempotentCall(stub, call, response.Payload)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	return response
 }
 
 // ============================================================================================================================
@@ -151,17 +271,31 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 // Inputs - id
 // ============================================================================================================================
 func (t *SimpleChaincode) getState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+	var request getStateRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//get id
-	id := args[0]
+	id := request.Id
 
 	// Get the state from the ledger
-	dataAsBytes, err := stub.GetState(id)
+	dataAsBytes, err := getRecordBytes(stub, id, ERR_RECORD_NOT_FOUND)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(err)
+	}
+
+	//return member and contract records in the current schema
+	if record, err := parseRecord(id, dataAsBytes); err == nil {
+		kind := recordKind(record)
+		if upgraded := newRecord(kind); upgraded != nil {
+			err = decodeRecord(id, dataAsBytes, kind, upgraded)
+			if err != nil {
+				return errorResponse(err)
+			}
+			dataAsBytes, _ = json.Marshal(upgraded)
+		}
 	}
 
 	//return user info
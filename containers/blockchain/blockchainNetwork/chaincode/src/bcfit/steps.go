@@ -0,0 +1,201 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//composite key object type of a user's steps per day, keyed by user id and UTC date
+const DAILY_STEPS_INDEX = "dailySteps"
+
+//format of the UTC dates steps are recorded under, dates in this format sort in time order
+const DATE_FORMAT = "2006-01-02"
+
+//longest date range of a step history, in days
+const MAX_STEP_HISTORY_DAYS = 366
+
+// generateFitcoins records the steps it adds to a user's total under the UTC date of the transaction, so the
+// steps of a day are the steps reported during that day, including steps too few to earn a fitcoin yet.
+
+// DailySteps of a user on a UTC date
+type DailySteps struct {
+	UserId  string `json:"userId"`
+	Date    string `json:"date"`
+	Steps   int    `json:"steps"`
+	Version int    `json:"schemaVersion"`
+}
+
+type getStepHistoryRequest struct {
+	UserId string `json:"userId" desc:"the user's id"`
+	From   string `json:"from" desc:"the first UTC date, as YYYY-MM-DD"`
+	To     string `json:"to" desc:"the last UTC date, as YYYY-MM-DD"`
+}
+
+func (r *getStepHistoryRequest) validate() error {
+	from, err := time.Parse(DATE_FORMAT, r.From)
+	if err != nil {
+		return argError(1, "from", "must be a date as YYYY-MM-DD")
+	}
+	to, err := time.Parse(DATE_FORMAT, r.To)
+	if err != nil {
+		return argError(2, "to", "must be a date as YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return argError(2, "to", "must not be before from")
+	}
+	if to.Sub(from) >= MAX_STEP_HISTORY_DAYS*24*time.Hour {
+		return argError(2, "to", "must be less than "+strconv.Itoa(MAX_STEP_HISTORY_DAYS)+" days after from")
+	}
+	return nil
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "getStepHistory",
+		Description: "Get a user's steps per day and per week over a date range",
+		Request:     getStepHistoryRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getStepHistory,
+	})
+}
+
+// ============================================================================================================================
+// Get step history - daily and weekly totals, weeks start on Monday and are cut at the ends of the range
+// Inputs - userId, from, to
+// ============================================================================================================================
+func (t *SimpleChaincode) getStepHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getStepHistoryRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//return every day of the range, the weeks and the Total
+	type DayTotal struct {
+		Date  string `json:"date"`
+		Steps int    `json:"steps"`
+	}
+	type WeekTotal struct {
+		WeekStart string `json:"weekStart"`
+		Steps     int    `json:"steps"`
+	}
+	type ReturnStepHistory struct {
+		UserId string      `json:"userId"`
+		From   string      `json:"from"`
+		To     string      `json:"to"`
+		Total  int         `json:"total"`
+		Days   []DayTotal  `json:"days"`
+		Weeks  []WeekTotal `json:"weeks"`
+	}
+	var returnHistory ReturnStepHistory
+	returnHistory.UserId = user.Id
+	returnHistory.From = request.From
+	returnHistory.To = request.To
+	returnHistory.Days = []DayTotal{}
+	returnHistory.Weeks = []WeekTotal{}
+
+	from, _ := time.Parse(DATE_FORMAT, request.From)
+	to, _ := time.Parse(DATE_FORMAT, request.To)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(DATE_FORMAT)
+		steps, err := readDailySteps(stub, user.Id, date)
+		if err != nil {
+			return errorResponse(err)
+		}
+		returnHistory.Days = append(returnHistory.Days, DayTotal{date, steps})
+		returnHistory.Total = returnHistory.Total + steps
+
+		//weeks start on Monday, the first week starts on the first day of the range
+		if day.Equal(from) || day.Weekday() == time.Monday {
+			returnHistory.Weeks = append(returnHistory.Weeks, WeekTotal{date, 0})
+		}
+		week := &returnHistory.Weeks[len(returnHistory.Weeks)-1]
+		week.Steps = week.Steps + steps
+	}
+
+	returnHistoryBytes, _ := json.Marshal(returnHistory)
+	return shim.Success(returnHistoryBytes)
+}
+
+// readDailySteps reads a user's steps of a UTC date, 0 when none are recorded. The history reads each day of its
+// range by key: a range over the composite keys of the dates is not possible, as Fabric refuses range queries
+// whose bounds are composite keys, and a partial key query would read every day the user ever recorded.
+func readDailySteps(stub shim.ChaincodeStubInterface, userId string, date string) (int, error) {
+	key, err := stub.CreateCompositeKey(DAILY_STEPS_INDEX, []string{userId, date})
+	if err != nil {
+		return 0, err
+	}
+	dailyAsBytes, err := stub.GetState(key)
+	if err != nil || dailyAsBytes == nil {
+		return 0, err
+	}
+	var daily DailySteps
+	err = decodeStrict(dailyAsBytes, &daily)
+	if err != nil {
+		return 0, corruptRecordError(key, "is not a daily steps record: "+err.Error())
+	}
+	return daily.Steps, nil
+}
+
+// addDailySteps adds a user's new steps to the user's steps of the transaction's UTC date
+func addDailySteps(stub shim.ChaincodeStubInterface, userId string, newSteps int) error {
+	if newSteps <= 0 {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	date := txTime.Format(DATE_FORMAT)
+
+	key, err := stub.CreateCompositeKey(DAILY_STEPS_INDEX, []string{userId, date})
+	if err != nil {
+		return err
+	}
+	dailyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	var daily DailySteps
+	if dailyAsBytes != nil {
+		err = decodeStrict(dailyAsBytes, &daily)
+		if err != nil {
+			return corruptRecordError(key, "is not a daily steps record: "+err.Error())
+		}
+	}
+	daily.UserId = userId
+	daily.Date = date
+	daily.Steps = daily.Steps + newSteps
+
+	_, err = writeRecord(stub, key, &daily)
+	return err
+}
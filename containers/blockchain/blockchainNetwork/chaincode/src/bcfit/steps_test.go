@@ -0,0 +1,89 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testStepHistory is a step history as getStepHistory returns it
+type testStepHistory struct {
+	Total int `json:"total"`
+	Days  []struct {
+		Date  string `json:"date"`
+		Steps int    `json:"steps"`
+	} `json:"days"`
+	Weeks []struct {
+		WeekStart string `json:"weekStart"`
+		Steps     int    `json:"steps"`
+	} `json:"weeks"`
+}
+
+func TestStepsAreRecordedOnTheDayTheyAreReported(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	//steps too few for a fitcoin still count for the day
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "50")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "150")
+	e.advance(24 * time.Hour)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "130")
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "400")
+	e.advance(3 * 24 * time.Hour)
+	e.as("u1").mustInvoke(nil, "generateFitcoins", "u1", "1000")
+	e.checkBalance("u1", 10)
+
+	var history testStepHistory
+	e.as("u1").mustInvoke(&history, "getStepHistory", "u1", "2018-02-28", "2018-03-06")
+	steps := map[string]int{}
+	for _, day := range history.Days {
+		steps[day.Date] = day.Steps
+	}
+	if history.Total != 1000 || len(history.Days) != 7 || steps["2018-03-01"] != 150 || steps["2018-03-02"] != 250 || steps["2018-03-05"] != 600 {
+		t.Fatalf("history is %+v", history)
+	}
+	//the first week starts with the range, the next on Monday
+	if len(history.Weeks) != 2 || history.Weeks[0].WeekStart != "2018-02-28" || history.Weeks[0].Steps != 400 || history.Weeks[1].WeekStart != "2018-03-05" {
+		t.Fatalf("weeks are %+v", history.Weeks)
+	}
+
+	e.as("u1").mustInvoke(&history, "getStepHistory", "u1", "2018-03-02", "2018-03-02")
+	if history.Total != 250 || len(history.Days) != 1 || len(history.Weeks) != 1 {
+		t.Fatalf("history of a day is %+v", history)
+	}
+}
+
+func TestStepHistoryRangeIsValidated(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "getStepHistory", "u1", "2018-3-1", "2018-03-02")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "getStepHistory", "u1", "2018-03-01", "tomorrow")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "getStepHistory", "u1", "2018-03-02", "2018-03-01")
+	e.as("u1").mustFail(ERR_INVALID_ARGUMENT, "getStepHistory", "u1", "2018-01-01", "2019-01-02")
+	e.as("u1").mustFail(ERR_MEMBER_NOT_FOUND, "getStepHistory", "u9", "2018-03-01", "2018-03-02")
+
+	var history testStepHistory
+	e.as("u1").mustInvoke(&history, "getStepHistory", "u1", "2018-01-01", "2019-01-01")
+	if history.Total != 0 || len(history.Days) != MAX_STEP_HISTORY_DAYS {
+		t.Fatalf("history of a year has %d days", len(history.Days))
+	}
+}
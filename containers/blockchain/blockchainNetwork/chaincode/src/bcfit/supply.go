@@ -0,0 +1,287 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//composite key object type of supply records, keyed by transaction id
+const SUPPLY_INDEX = "supply"
+
+// The supply ledger records every fitcoin minted and burned. Every transaction that mints or burns
+// writes its own supply record instead of updating a single total, so that concurrent transactions,
+// such as users generating fitcoins at the same time, do not invalidate each other on the same key.
+// The total supply is the sum of all supply records.
+
+// SupplyRecord of the fitcoins minted and burned by one transaction
+type SupplyRecord struct {
+	Id      string `json:"id"`
+	Minted  int    `json:"minted"`
+	Burned  int    `json:"burned"`
+	Version int    `json:"schemaVersion"`
+}
+
+// Supply totals over all supply records
+type Supply struct {
+	Minted      int `json:"minted"`
+	Burned      int `json:"burned"`
+	Circulating int `json:"circulating"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "getSupply",
+		Description: "Get the total fitcoins minted, burned and in circulation",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getSupply,
+	})
+	registerFunction(Function{
+		Name:        "auditInvariants",
+		Description: "Check that the balances and escrow add up to the supply and that no balance or product count is negative",
+		Request:     emptyRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).auditInvariants,
+	})
+}
+
+// mintFitcoins credits new fitcoins to a member and records them in the supply
+func mintFitcoins(stub shim.ChaincodeStubInterface, member *Member, amount int) error {
+	err := issueCoins(stub, member, amount)
+	if err != nil {
+		return err
+	}
+	return recordSupply(stub, amount, 0)
+}
+
+// burnFitcoins debits fitcoins from a member for good and records them in the supply
+func burnFitcoins(stub shim.ChaincodeStubInterface, member *Member, amount int) error {
+	_, err := spendCoins(stub, member, amount)
+	if err != nil {
+		return err
+	}
+	return recordSupply(stub, 0, amount)
+}
+
+// recordSupply adds minted and burned fitcoins to the supply record of the transaction
+func recordSupply(stub shim.ChaincodeStubInterface, minted int, burned int) error {
+	if minted == 0 && burned == 0 {
+		return nil
+	}
+	key, err := stub.CreateCompositeKey(SUPPLY_INDEX, []string{stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+
+	//a transaction may mint or burn more than once
+	var supplyRecord SupplyRecord
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingAsBytes != nil {
+		err = decodeStrict(existingAsBytes, &supplyRecord)
+		if err != nil {
+			return corruptRecordError(key, "is not a supply record: "+err.Error())
+		}
+	}
+	supplyRecord.Id = stub.GetTxID()
+	supplyRecord.Minted = supplyRecord.Minted + minted
+	supplyRecord.Burned = supplyRecord.Burned + burned
+
+	_, err = writeRecord(stub, key, &supplyRecord)
+	return err
+}
+
+// ============================================================================================================================
+// Read supply - sum all supply records
+// ============================================================================================================================
+func readSupply(stub shim.ChaincodeStubInterface) (Supply, bool, error) {
+	var supply Supply
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(SUPPLY_INDEX, []string{})
+	if err != nil {
+		return supply, false, err
+	}
+	defer resultsIterator.Close()
+
+	found := false
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return supply, false, err
+		}
+		var supplyRecord SupplyRecord
+		err = decodeStrict(aKeyValue.Value, &supplyRecord)
+		if err != nil {
+			return supply, false, corruptRecordError(aKeyValue.Key, "is not a supply record: "+err.Error())
+		}
+		found = true
+		supply.Minted = supply.Minted + supplyRecord.Minted
+		supply.Burned = supply.Burned + supplyRecord.Burned
+	}
+	supply.Circulating = supply.Minted - supply.Burned
+	return supply, found, nil
+}
+
+// ============================================================================================================================
+// Seed supply - record the fitcoins minted before the supply ledger existed, called from Init
+// ============================================================================================================================
+func seedSupply(stub shim.ChaincodeStubInterface) error {
+	_, found, err := readSupply(stub)
+	if err != nil || found {
+		return err
+	}
+	balances := 0
+	err = forEachMember(stub, func(record versioned, member *Member) error {
+		balances = balances + member.FitcoinsBalance
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return recordSupply(stub, balances, 0)
+}
+
+// forEachMember calls f with every user and seller stored on the ledger
+func forEachMember(stub shim.ChaincodeStubInterface, f func(record versioned, member *Member) error) error {
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		record, err := parseRecord(aKeyValue.Key, aKeyValue.Value)
+		if err != nil {
+			continue
+		}
+		kind := recordKind(record)
+		if kind != TYPE_USER && kind != TYPE_SELLER {
+			continue
+		}
+		memberRecord, member, err := loadMember(stub, aKeyValue.Key)
+		if err != nil {
+			return err
+		}
+		err = f(memberRecord, member)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Get supply
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) getSupply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	supply, _, err := readSupply(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	supplyAsBytes, _ := json.Marshal(supply)
+	return shim.Success(supplyAsBytes)
+}
+
+// ============================================================================================================================
+// Audit invariants - the member balances and escrow must add up to the circulating supply, and no balance or
+// product count may be negative. Violations are returned, not raised, so that all of them are reported.
+// Inputs - (none)
+// ============================================================================================================================
+func (t *SimpleChaincode) auditInvariants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := decodeRequest(args, &emptyRequest{})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	type Violation struct {
+		Key     string `json:"key"`
+		Problem string `json:"problem"`
+	}
+	type AuditResult struct {
+		Supply
+		Balances   int         `json:"balances"`
+		Escrow     int         `json:"escrow"`
+		Ok         bool        `json:"ok"`
+		Violations []Violation `json:"violations"`
+	}
+	result := AuditResult{Violations: []Violation{}}
+
+	result.Supply, _, err = readSupply(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//sum balances and check members
+	err = forEachMember(stub, func(record versioned, member *Member) error {
+		result.Balances = result.Balances + member.FitcoinsBalance
+		if member.FitcoinsBalance < 0 {
+			result.Violations = append(result.Violations, Violation{member.Id, "negative fitcoins balance"})
+		}
+		problem, err := coinBatchViolation(stub, *member)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			result.Violations = append(result.Violations, Violation{member.Id, problem})
+		}
+		if seller, ok := record.(*Seller); ok {
+			for _, product := range seller.Products {
+				if product.Count < 0 {
+					result.Violations = append(result.Violations, Violation{member.Id, "negative count of product " + product.Id})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//bids held by open auctions are part of the circulating supply
+	result.Escrow, err = auctionEscrow(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if result.Balances+result.Escrow != result.Circulating {
+		result.Violations = append(result.Violations, Violation{SUPPLY_INDEX, "balances and escrow do not add up to the circulating supply"})
+	}
+	result.Ok = len(result.Violations) == 0
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// testAuditResult is the part of the auditInvariants result the tests check
+type testAuditResult struct {
+	Supply
+	Balances   int  `json:"balances"`
+	Escrow     int  `json:"escrow"`
+	Ok         bool `json:"ok"`
+	Violations []struct {
+		Key     string `json:"key"`
+		Problem string `json:"problem"`
+	} `json:"violations"`
+}
+
+func TestSupplyFollowsMintsBurnsAndTransfers(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 1000)
+	e.createSeller("s1", "p1", 5, 3)
+
+	var contract Contract
+	e.as("u1").mustInvoke(&contract, "makePurchase", "u1", "s1", "p1", "1")
+	e.as("s1").mustInvoke(nil, "transactPurchase", "s1", contract.Id, STATE_COMPLETE, e.pickupSecret(contract.Id, ""))
+	e.asAdmin().mustInvoke(nil, "adminBurn", "s1", "2", "refund")
+
+	var supply Supply
+	e.as("u1").mustInvoke(&supply, "getSupply")
+	if supply.Minted != 10 || supply.Burned != 2 || supply.Circulating != 8 {
+		t.Fatalf("supply is %+v", supply)
+	}
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok || audit.Balances != 8 || len(audit.Violations) != 0 {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
+
+func TestSupplyIsSeededOnUpgrade(t *testing.T) {
+	e := newTestEnv(t)
+	e.put("u1", `{"id":"u1","memberType":"user","fitcoinsBalance":7,"totalSteps":700,"stepsUsedForConversion":700,"contractIds":[]}`)
+
+	e.asAdmin()
+	if response := e.run(true, "init"); response.Status != shim.OK {
+		t.Fatalf("upgrade failed: %s", response.Message)
+	}
+	//seeding happens once
+	e.asAdmin()
+	if response := e.run(true, "init"); response.Status != shim.OK {
+		t.Fatalf("upgrade failed: %s", response.Message)
+	}
+
+	var supply Supply
+	e.as("u1").mustInvoke(&supply, "getSupply")
+	if supply.Minted != 7 || supply.Circulating != 7 {
+		t.Fatalf("seeded supply is %+v", supply)
+	}
+}
+
+func TestAuditReportsEveryViolation(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 500)
+	e.put("u2", fmt.Sprintf(`{"id":"u2","memberType":"user","fitcoinsBalance":-1,"totalSteps":0,"stepsUsedForConversion":0,"contractIds":[],"status":"active","frozen":false,"schemaVersion":%d}`, SCHEMA_VERSION))
+	e.put("s1", fmt.Sprintf(`{"id":"s1","memberType":"seller","fitcoinsBalance":0,"products":[{"id":"p1","name":"Shirt","count":-2,"price":1}],"status":"active","frozen":false,"schemaVersion":%d}`, SCHEMA_VERSION))
+
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if audit.Ok || audit.Balances != 4 || audit.Circulating != 5 {
+		t.Fatalf("audit is %+v", audit)
+	}
+	problems := map[string]bool{}
+	for _, violation := range audit.Violations {
+		problems[violation.Key] = true
+	}
+	if !problems["u2"] || !problems["s1"] || !problems[SUPPLY_INDEX] {
+		t.Fatalf("violations are %+v", audit.Violations)
+	}
+}
@@ -0,0 +1,802 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+//most members in a team
+const MAX_TEAM_MEMBERS = 20
+
+//challenge state
+const CHALLENGE_OPEN = "open"
+const CHALLENGE_SETTLED = "settled"
+
+//composite key object types of teams, keyed by team id, of the steps walked for a team, keyed by team and user id,
+//of challenges, keyed by challenge id, of the open challenge index, keyed by challenge id, and of the steps
+//walked during a challenge, keyed by challenge, team and user id
+const TEAM_INDEX = "team"
+const TEAM_STEPS_INDEX = "teamSteps"
+const CHALLENGE_INDEX = "challenge"
+const CHALLENGE_OPEN_INDEX = "challengeOpen"
+const CHALLENGE_STEPS_INDEX = "challengeSteps"
+
+// A user belongs to at most one team. Whenever generateFitcoins raises a team member's total steps, the
+//new steps are added to the member's own steps record for the team and, for every challenge running at
+//the transaction time, to the member's steps record for the challenge. Each record is only written by
+//its user's transactions, so team members generating fitcoins at the same time do not conflict. Team
+//and challenge totals are the sums of these records. Once a challenge has ended, settleChallenge mints
+// its reward pool to the members of the team with the most steps in proportion to their steps.
+
+// Team of users walking together
+type Team struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	CaptainId string   `json:"captainId"`
+	MemberIds []string `json:"memberIds"`
+	CreatedAt string   `json:"createdAt"`
+	Version   int      `json:"schemaVersion"`
+}
+
+// TeamSteps walked by a user for a team, in total or during a challenge
+type TeamSteps struct {
+	TeamId      string `json:"teamId"`
+	UserId      string `json:"userId"`
+	ChallengeId string `json:"challengeId,omitempty"`
+	Steps       int    `json:"steps"`
+	Version     int    `json:"schemaVersion"`
+}
+
+// Challenge between teams for a reward pool
+type Challenge struct {
+	Id             string            `json:"id"`
+	Name           string            `json:"name"`
+	StartsAt       string            `json:"startsAt"`
+	EndsAt         string            `json:"endsAt"`
+	RewardPool     int               `json:"rewardPool"`
+	State          string            `json:"state"`
+	WinningTeamIds []string          `json:"winningTeamIds,omitempty"`
+	Payouts        []ChallengePayout `json:"payouts,omitempty"`
+	Version        int               `json:"schemaVersion"`
+}
+
+// ChallengePayout to a member of a winning team
+type ChallengePayout struct {
+	UserId string `json:"userId"`
+	TeamId string `json:"teamId"`
+	Steps  int    `json:"steps"`
+	Amount int    `json:"amount"`
+}
+
+// TeamStanding in a challenge
+type TeamStanding struct {
+	TeamId string `json:"teamId"`
+	Steps  int    `json:"steps"`
+}
+
+type createTeamRequest struct {
+	UserId string `json:"userId" desc:"the id of the user creating and captaining the team"`
+	TeamId string `json:"teamId" desc:"the id of the new team"`
+	Name   string `json:"name" desc:"the team's display name"`
+}
+
+type joinTeamRequest struct {
+	UserId string `json:"userId" desc:"the user's id"`
+	TeamId string `json:"teamId" desc:"the id of the team to join"`
+}
+
+type leaveTeamRequest struct {
+	UserId string `json:"userId" desc:"the user's id"`
+}
+
+type getTeamRequest struct {
+	TeamId string `json:"teamId" desc:"the team id"`
+}
+
+type createChallengeRequest struct {
+	Name       string `json:"name" desc:"the challenge's display name"`
+	StartsAt   string `json:"startsAt" desc:"RFC 3339 time from which steps count for the challenge"`
+	EndsAt     string `json:"endsAt" desc:"RFC 3339 time from which steps no longer count and the challenge can be settled"`
+	RewardPool int    `json:"rewardPool" desc:"the fitcoins minted to the winning team's members"`
+}
+
+func (r *createChallengeRequest) validate() error {
+	startsAt, err := time.Parse(time.RFC3339, r.StartsAt)
+	if err != nil {
+		return argError(1, "startsAt", "must be an RFC 3339 time")
+	}
+	endsAt, err := time.Parse(time.RFC3339, r.EndsAt)
+	if err != nil {
+		return argError(2, "endsAt", "must be an RFC 3339 time")
+	}
+	if !endsAt.After(startsAt) {
+		return argError(2, "endsAt", "must be after startsAt")
+	}
+	if r.RewardPool <= 0 {
+		return argError(3, "rewardPool", "must be positive")
+	}
+	return nil
+}
+
+type challengeRequest struct {
+	ChallengeId string `json:"challengeId" desc:"the challenge id returned by createChallenge"`
+}
+
+func init() {
+	registerFunction(Function{
+		Name:        "createTeam",
+		Description: "Create a team captained by the user",
+		Request:     createTeamRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).createTeam,
+	})
+	registerFunction(Function{
+		Name:        "joinTeam",
+		Description: "Join a team, users belong to at most one team",
+		Request:     joinTeamRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).joinTeam,
+	})
+	registerFunction(Function{
+		Name:        "leaveTeam",
+		Description: "Leave the user's team, the steps already walked for it stay with the team",
+		Request:     leaveTeamRequest{},
+		Role:        ROLE_USER,
+		handler:     (*SimpleChaincode).leaveTeam,
+	})
+	registerFunction(Function{
+		Name:        "getTeam",
+		Description: "Get a team with its total steps and the steps of each user",
+		Request:     getTeamRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getTeam,
+	})
+	registerFunction(Function{
+		Name:        "createChallenge",
+		Description: "Create a time-boxed step challenge between all teams with a reward pool",
+		Request:     createChallengeRequest{},
+		Role:        ROLE_ADMIN,
+		handler:     (*SimpleChaincode).createChallenge,
+	})
+	registerFunction(Function{
+		Name:        "settleChallenge",
+		Description: "Settle an ended challenge, minting the reward pool to the winning team's members",
+		Request:     challengeRequest{},
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).settleChallenge,
+	})
+	registerFunction(Function{
+		Name:        "getChallenge",
+		Description: "Get a challenge with the steps of each team",
+		Request:     challengeRequest{},
+		ReadOnly:    true,
+		Role:        ROLE_ANY,
+		handler:     (*SimpleChaincode).getChallenge,
+	})
+}
+
+// ============================================================================================================================
+// Create team
+// Inputs - userId, teamId, name
+// ============================================================================================================================
+func (t *SimpleChaincode) createTeam(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request createTeamRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	user, err := loadTeamlessUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//refuse team ids in use
+	key, err := stub.CreateCompositeKey(TEAM_INDEX, []string{request.TeamId})
+	if err != nil {
+		return errorResponse(err)
+	}
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if existingAsBytes != nil {
+		return errorResponse(newError(ERR_ALREADY_EXISTS, "Team "+request.TeamId+" already exists").withDetail("teamId", request.TeamId))
+	}
+
+	//create team with the user as captain
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var team Team
+	team.Id = request.TeamId
+	team.Name = request.Name
+	team.CaptainId = user.Id
+	team.MemberIds = []string{user.Id}
+	team.CreatedAt = txTime.Format(TIMESTAMP_FORMAT)
+
+	user.TeamId = team.Id
+	_, err = writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+	teamAsBytes, err := writeRecord(stub, key, &team)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(teamAsBytes)
+}
+
+// ============================================================================================================================
+// Join team
+// Inputs - userId, teamId
+// ============================================================================================================================
+func (t *SimpleChaincode) joinTeam(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request joinTeamRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	user, err := loadTeamlessUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	team, key, err := loadTeam(stub, request.TeamId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(team.MemberIds) >= MAX_TEAM_MEMBERS {
+		return errorResponse(newError(ERR_INVALID_STATE, "Team is full").
+			withDetail("teamId", team.Id).
+			withDetail("maxMembers", MAX_TEAM_MEMBERS))
+	}
+
+	//add user to team, a team left by all its members is taken over by the next one joining
+	if len(team.MemberIds) == 0 {
+		team.CaptainId = user.Id
+	}
+	team.MemberIds = append(team.MemberIds, user.Id)
+	user.TeamId = team.Id
+
+	_, err = writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+	teamAsBytes, err := writeRecord(stub, key, &team)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(teamAsBytes)
+}
+
+// ============================================================================================================================
+// Leave team
+// Inputs - userId
+// ============================================================================================================================
+func (t *SimpleChaincode) leaveTeam(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request leaveTeamRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//get user
+	user, err := loadUser(stub, request.UserId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if user.TeamId == "" {
+		return errorResponse(newError(ERR_INVALID_STATE, "User is not in a team").withDetail("userId", user.Id))
+	}
+
+	err = removeTeamMember(stub, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+	userAsBytes, err := writeRecord(stub, user.Id, &user)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(userAsBytes)
+}
+
+// ============================================================================================================================
+// Get team
+// Inputs - teamId
+// ============================================================================================================================
+func (t *SimpleChaincode) getTeam(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request getTeamRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	team, _, err := loadTeam(stub, request.TeamId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	contributions, err := readTeamSteps(stub, TEAM_STEPS_INDEX, []string{team.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//return team with TotalSteps and Contributions
+	type ReturnTeam struct {
+		Team
+		TotalSteps    int         `json:"totalSteps"`
+		Contributions []TeamSteps `json:"contributions"`
+	}
+	var returnTeam ReturnTeam
+	returnTeam.Team = team
+	returnTeam.Contributions = contributions
+	for _, contribution := range contributions {
+		returnTeam.TotalSteps = returnTeam.TotalSteps + contribution.Steps
+	}
+
+	returnTeamBytes, _ := json.Marshal(returnTeam)
+	return shim.Success(returnTeamBytes)
+}
+
+// ============================================================================================================================
+// Create challenge
+// Inputs - name, startsAt, endsAt, rewardPool
+// ============================================================================================================================
+func (t *SimpleChaincode) createChallenge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request createChallengeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//create challenge, identified by the transaction id
+	var challenge Challenge
+	challenge.Id = stub.GetTxID()
+	challenge.Name = request.Name
+	startsAt, _ := time.Parse(time.RFC3339, request.StartsAt)
+	endsAt, _ := time.Parse(time.RFC3339, request.EndsAt)
+	challenge.StartsAt = startsAt.UTC().Format(TIMESTAMP_FORMAT)
+	challenge.EndsAt = endsAt.UTC().Format(TIMESTAMP_FORMAT)
+	challenge.RewardPool = request.RewardPool
+	challenge.State = CHALLENGE_OPEN
+
+	//index the challenge as open until settled
+	openKey, err := stub.CreateCompositeKey(CHALLENGE_OPEN_INDEX, []string{challenge.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.PutState(openKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(err)
+	}
+	challengeAsBytes, err := writeChallenge(stub, &challenge)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(challengeAsBytes)
+}
+
+// ============================================================================================================================
+// Settle challenge
+// Inputs - challengeId
+// ============================================================================================================================
+func (t *SimpleChaincode) settleChallenge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request challengeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	challenge, err := loadChallenge(stub, request.ChallengeId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if challenge.State != CHALLENGE_OPEN {
+		return errorResponse(newError(ERR_INVALID_STATE, "Challenge already settled").
+			withDetail("challengeId", challenge.Id).
+			withDetail("state", challenge.State))
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if txTime.Format(TIMESTAMP_FORMAT) < challenge.EndsAt {
+		return errorResponse(newError(ERR_INVALID_STATE, "Challenge has not ended").
+			withDetail("challengeId", challenge.Id).
+			withDetail("endsAt", challenge.EndsAt))
+	}
+
+	//the teams with the most steps win, tied teams share the pool
+	contributions, err := readTeamSteps(stub, CHALLENGE_STEPS_INDEX, []string{challenge.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	standings := challengeStandings(contributions)
+	winners := map[string]bool{}
+	for _, standing := range standings {
+		if standing.Steps > 0 && standing.Steps == standings[0].Steps {
+			winners[standing.TeamId] = true
+			challenge.WinningTeamIds = append(challenge.WinningTeamIds, standing.TeamId)
+		}
+	}
+
+	//closed accounts get no share. A user who moved between tied winning teams gets a payout for each team
+	var payees []User
+	payeeIndex := map[string]int{}
+	closed := map[string]bool{}
+	for _, contribution := range contributions {
+		if !winners[contribution.TeamId] || contribution.Steps <= 0 || closed[contribution.UserId] {
+			continue
+		}
+		if _, ok := payeeIndex[contribution.UserId]; !ok {
+			user, err := loadUser(stub, contribution.UserId)
+			if err != nil {
+				return errorResponse(err)
+			}
+			if user.Status == STATUS_CLOSED {
+				closed[user.Id] = true
+				continue
+			}
+			payeeIndex[user.Id] = len(payees)
+			payees = append(payees, user)
+		}
+		var payout ChallengePayout
+		payout.UserId = contribution.UserId
+		payout.TeamId = contribution.TeamId
+		payout.Steps = contribution.Steps
+		challenge.Payouts = append(challenge.Payouts, payout)
+	}
+	splitRewardPool(challenge.RewardPool, challenge.Payouts)
+
+	//credit each user's payouts together so every user record is written once, and record them in the supply once for the whole pool
+	amounts := make([]int, len(payees))
+	for _, payout := range challenge.Payouts {
+		amounts[payeeIndex[payout.UserId]] = amounts[payeeIndex[payout.UserId]] + payout.Amount
+	}
+	paid := 0
+	for i := range payees {
+		err = issueCoins(stub, &payees[i].Member, amounts[i])
+		if err != nil {
+			return errorResponse(err)
+		}
+		paid = paid + amounts[i]
+		_, err = writeRecord(stub, payees[i].Id, &payees[i])
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	err = recordSupply(stub, paid, 0)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//close the challenge
+	openKey, err := stub.CreateCompositeKey(CHALLENGE_OPEN_INDEX, []string{challenge.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = stub.DelState(openKey)
+	if err != nil {
+		return errorResponse(err)
+	}
+	challenge.State = CHALLENGE_SETTLED
+	challengeAsBytes, err := writeChallenge(stub, &challenge)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(challengeAsBytes)
+}
+
+// ============================================================================================================================
+// Get challenge
+// Inputs - challengeId
+// ============================================================================================================================
+func (t *SimpleChaincode) getChallenge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var request challengeRequest
+	err := decodeRequest(args, &request)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	challenge, err := loadChallenge(stub, request.ChallengeId)
+	if err != nil {
+		return errorResponse(err)
+	}
+	contributions, err := readTeamSteps(stub, CHALLENGE_STEPS_INDEX, []string{challenge.Id})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	//return challenge with Standings, best team first
+	type ReturnChallenge struct {
+		Challenge
+		Standings []TeamStanding `json:"standings"`
+	}
+	var returnChallenge ReturnChallenge
+	returnChallenge.Challenge = challenge
+	returnChallenge.Standings = challengeStandings(contributions)
+
+	returnChallengeBytes, _ := json.Marshal(returnChallenge)
+	return shim.Success(returnChallengeBytes)
+}
+
+// addTeamSteps adds a user's new steps to its team and to every challenge running at the transaction time
+func addTeamSteps(stub shim.ChaincodeStubInterface, user User, newSteps int) error {
+	if user.TeamId == "" || newSteps <= 0 {
+		return nil
+	}
+	err := addStepsRecord(stub, TEAM_STEPS_INDEX, []string{user.TeamId, user.Id}, user, "", newSteps)
+	if err != nil {
+		return err
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	now := txTime.Format(TIMESTAMP_FORMAT)
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(CHALLENGE_OPEN_INDEX, []string{})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil {
+			return err
+		}
+		challenge, err := loadChallenge(stub, keyParts[0])
+		if err != nil {
+			return err
+		}
+		if now < challenge.StartsAt || now >= challenge.EndsAt {
+			continue
+		}
+		err = addStepsRecord(stub, CHALLENGE_STEPS_INDEX, []string{challenge.Id, user.TeamId, user.Id}, user, challenge.Id, newSteps)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStepsRecord adds steps to the steps record of a user stored under the composite key
+func addStepsRecord(stub shim.ChaincodeStubInterface, index string, attributes []string, user User, challengeId string, steps int) error {
+	key, err := stub.CreateCompositeKey(index, attributes)
+	if err != nil {
+		return err
+	}
+	var teamSteps TeamSteps
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingAsBytes != nil {
+		err = decodeStrict(existingAsBytes, &teamSteps)
+		if err != nil {
+			return corruptRecordError(key, "is not a steps record: "+err.Error())
+		}
+	}
+	teamSteps.TeamId = user.TeamId
+	teamSteps.UserId = user.Id
+	teamSteps.ChallengeId = challengeId
+	teamSteps.Steps = teamSteps.Steps + steps
+	_, err = writeRecord(stub, key, &teamSteps)
+	return err
+}
+
+// readTeamSteps reads the steps records under a partial composite key
+func readTeamSteps(stub shim.ChaincodeStubInterface, index string, attributes []string) ([]TeamSteps, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []TeamSteps{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var teamSteps TeamSteps
+		err = decodeStrict(aKeyValue.Value, &teamSteps)
+		if err != nil {
+			return nil, corruptRecordError(aKeyValue.Key, "is not a steps record: "+err.Error())
+		}
+		records = append(records, teamSteps)
+	}
+	return records, nil
+}
+
+// challengeStandings sums the steps of each team, most steps first and ties by team id
+func challengeStandings(contributions []TeamSteps) []TeamStanding {
+	totals := map[string]int{}
+	for _, contribution := range contributions {
+		totals[contribution.TeamId] = totals[contribution.TeamId] + contribution.Steps
+	}
+	standings := []TeamStanding{}
+	for teamId, steps := range totals {
+		standings = append(standings, TeamStanding{TeamId: teamId, Steps: steps})
+	}
+	sort.Sort(standingsBySteps(standings))
+	return standings
+}
+
+// splitRewardPool sets each payout's amount in proportion to its steps. The fitcoins left over by
+// rounding down go one each to the payouts with the largest remainders, the earliest first on ties.
+func splitRewardPool(pool int, payouts []ChallengePayout) {
+	totalSteps := 0
+	for _, payout := range payouts {
+		totalSteps = totalSteps + payout.Steps
+	}
+	if totalSteps == 0 {
+		return
+	}
+
+	left := pool
+	order := payoutsByRemainder{make([]int, len(payouts)), make([]int, len(payouts))}
+	for i := range payouts {
+		payouts[i].Amount = pool * payouts[i].Steps / totalSteps
+		left = left - payouts[i].Amount
+		order.indexes[i] = i
+		order.remainders[i] = pool * payouts[i].Steps % totalSteps
+	}
+	sort.Stable(order)
+	for i := 0; i < left; i++ {
+		payouts[order.indexes[i]].Amount++
+	}
+}
+
+// standingsBySteps sorts team standings most steps first and ties by team id
+type standingsBySteps []TeamStanding
+
+func (s standingsBySteps) Len() int      { return len(s) }
+func (s standingsBySteps) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s standingsBySteps) Less(i, j int) bool {
+	if s[i].Steps != s[j].Steps {
+		return s[i].Steps > s[j].Steps
+	}
+	return s[i].TeamId < s[j].TeamId
+}
+
+// payoutsByRemainder sorts payout indexes by the remainder their amount was rounded down by, largest first
+type payoutsByRemainder struct {
+	indexes    []int
+	remainders []int
+}
+
+func (p payoutsByRemainder) Len() int { return len(p.indexes) }
+func (p payoutsByRemainder) Swap(i, j int) {
+	p.indexes[i], p.indexes[j] = p.indexes[j], p.indexes[i]
+	p.remainders[i], p.remainders[j] = p.remainders[j], p.remainders[i]
+}
+func (p payoutsByRemainder) Less(i, j int) bool { return p.remainders[i] > p.remainders[j] }
+
+// removeTeamMember takes a user out of its team, passing the captaincy on to the next member
+func removeTeamMember(stub shim.ChaincodeStubInterface, user *User) error {
+	team, key, err := loadTeam(stub, user.TeamId)
+	if err != nil {
+		return err
+	}
+	var memberIds []string
+	for _, memberId := range team.MemberIds {
+		if memberId != user.Id {
+			memberIds = append(memberIds, memberId)
+		}
+	}
+	team.MemberIds = memberIds
+	if team.CaptainId == user.Id {
+		team.CaptainId = ""
+		if len(memberIds) > 0 {
+			team.CaptainId = memberIds[0]
+		}
+	}
+	user.TeamId = ""
+	_, err = writeRecord(stub, key, &team)
+	return err
+}
+
+// loadTeamlessUser reads an active user that is not in a team
+func loadTeamlessUser(stub shim.ChaincodeStubInterface, id string) (User, error) {
+	user, err := loadUser(stub, id)
+	if err != nil {
+		return user, err
+	}
+	err = checkActive(user.Member)
+	if err != nil {
+		return user, err
+	}
+	if user.TeamId != "" {
+		return user, newError(ERR_INVALID_STATE, "User is already in a team").
+			withDetail("userId", user.Id).
+			withDetail("teamId", user.TeamId)
+	}
+	return user, nil
+}
+
+// loadTeam reads a team by id, returning its key
+func loadTeam(stub shim.ChaincodeStubInterface, id string) (Team, string, error) {
+	var team Team
+	key, err := stub.CreateCompositeKey(TEAM_INDEX, []string{id})
+	if err != nil {
+		return team, "", err
+	}
+	teamAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return team, "", err
+	}
+	if teamAsBytes == nil {
+		return team, "", newError(ERR_TEAM_NOT_FOUND, "Team "+id+" not found").withDetail("teamId", id)
+	}
+	err = decodeStrict(teamAsBytes, &team)
+	if err != nil {
+		return team, "", corruptRecordError(key, "is not a team: "+err.Error())
+	}
+	return team, key, nil
+}
+
+// loadChallenge reads a challenge by id
+func loadChallenge(stub shim.ChaincodeStubInterface, id string) (Challenge, error) {
+	var challenge Challenge
+	key, err := stub.CreateCompositeKey(CHALLENGE_INDEX, []string{id})
+	if err != nil {
+		return challenge, err
+	}
+	challengeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return challenge, err
+	}
+	if challengeAsBytes == nil {
+		return challenge, newError(ERR_CHALLENGE_NOT_FOUND, "Challenge "+id+" not found").withDetail("challengeId", id)
+	}
+	err = decodeStrict(challengeAsBytes, &challenge)
+	if err != nil {
+		return challenge, corruptRecordError(key, "is not a challenge: "+err.Error())
+	}
+	return challenge, nil
+}
+
+// writeChallenge stores a challenge under its composite key
+func writeChallenge(stub shim.ChaincodeStubInterface, challenge *Challenge) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(CHALLENGE_INDEX, []string{challenge.Id})
+	if err != nil {
+		return nil, err
+	}
+	return writeRecord(stub, key, challenge)
+}
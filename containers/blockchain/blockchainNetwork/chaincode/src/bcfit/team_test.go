@@ -0,0 +1,166 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newChallengeEnv creates a challenge with a pool of pool fitcoins running from 10:00 to 11:00, and
+// moves the time to its start
+func newChallengeEnv(t *testing.T, pool string) (*testEnv, string) {
+	e := newTestEnv(t)
+	challengeId := e.nextTxId()
+	e.asAdmin().mustInvoke(nil, "createChallenge", "Spring", "2018-03-01T10:00:00Z", "2018-03-01T11:00:00Z", pool)
+	return e, challengeId
+}
+
+// walk reports a user's new total steps
+func walk(e *testEnv, userId string, totalSteps int) {
+	e.as(userId).mustInvoke(nil, "generateFitcoins", userId, strconv.Itoa(totalSteps))
+}
+
+func TestTeamMembership(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser("u1", 0)
+	e.createUser("u2", 0)
+
+	e.as("u1").mustInvoke(nil, "createTeam", "u1", "t1", "Walkers")
+	e.as("u2").mustFail(ERR_ALREADY_EXISTS, "createTeam", "u2", "t1", "Runners")
+	e.as("u1").mustFail(ERR_INVALID_STATE, "joinTeam", "u1", "t1")
+	e.as("u2").mustFail(ERR_TEAM_NOT_FOUND, "joinTeam", "u2", "t9")
+	e.as("u2").mustInvoke(nil, "joinTeam", "u2", "t1")
+	walk(e, "u1", 300)
+	walk(e, "u2", 200)
+
+	//the captain's steps stay with the team and the next member becomes captain
+	e.as("u1").mustInvoke(nil, "leaveTeam", "u1")
+	e.as("u1").mustFail(ERR_INVALID_STATE, "leaveTeam", "u1")
+	walk(e, "u1", 900)
+
+	var team struct {
+		Team
+		TotalSteps int `json:"totalSteps"`
+	}
+	e.as("u2").mustInvoke(&team, "getTeam", "t1")
+	if team.CaptainId != "u2" || len(team.MemberIds) != 1 || team.TotalSteps != 500 {
+		t.Fatalf("team is %+v", team)
+	}
+}
+
+func TestChallengePoolIsSplitByStepsWithinTheWinningTeam(t *testing.T) {
+	e, challengeId := newChallengeEnv(t, "100")
+	for _, userId := range []string{"u1", "u2", "u3", "u4"} {
+		e.createUser(userId, 0)
+	}
+	e.as("u1").mustInvoke(nil, "createTeam", "u1", "t1", "Walkers")
+	e.as("u2").mustInvoke(nil, "joinTeam", "u2", "t1")
+	e.as("u4").mustInvoke(nil, "joinTeam", "u4", "t1")
+	e.as("u3").mustInvoke(nil, "createTeam", "u3", "t2", "Runners")
+
+	//steps before the start do not count
+	walk(e, "u3", 1000)
+	e.advance(time.Hour)
+	walk(e, "u1", 300)
+	walk(e, "u2", 600)
+	walk(e, "u4", 300)
+	walk(e, "u3", 1500)
+	e.as("u4").mustInvoke(nil, "closeAccount", "u4")
+
+	e.as("u1").mustFail(ERR_INVALID_STATE, "settleChallenge", challengeId)
+	e.advance(time.Hour)
+	walk(e, "u3", 3000)
+
+	var settled Challenge
+	e.as("u1").mustInvoke(&settled, "settleChallenge", challengeId)
+	e.as("u1").mustFail(ERR_INVALID_STATE, "settleChallenge", challengeId)
+	if settled.State != CHALLENGE_SETTLED || len(settled.WinningTeamIds) != 1 || settled.WinningTeamIds[0] != "t1" || len(settled.Payouts) != 2 {
+		t.Fatalf("settled challenge is %+v", settled)
+	}
+
+	//the leftover fitcoin goes to the larger remainder
+	if settled.Payouts[0].UserId != "u1" || settled.Payouts[0].Amount != 33 || settled.Payouts[1].Amount != 67 {
+		t.Fatalf("payouts are %+v", settled.Payouts)
+	}
+	e.checkBalance("u1", 3+33)
+	e.checkBalance("u2", 6+67)
+	e.checkBalance("u4", 3)
+
+	var audit testAuditResult
+	e.as("u1").mustInvoke(&audit, "auditInvariants")
+	if !audit.Ok {
+		t.Fatalf("audit is %+v", audit)
+	}
+}
+
+func TestTiedTeamsShareThePoolAndMoversAreCreditedOnce(t *testing.T) {
+	e, challengeId := newChallengeEnv(t, "40")
+	for _, userId := range []string{"u1", "u2", "u3"} {
+		e.createUser(userId, 0)
+	}
+	e.as("u1").mustInvoke(nil, "createTeam", "u1", "t1", "Walkers")
+	e.as("u3").mustInvoke(nil, "joinTeam", "u3", "t1")
+	e.as("u2").mustInvoke(nil, "createTeam", "u2", "t2", "Runners")
+
+	e.advance(time.Hour)
+	walk(e, "u1", 500)
+	walk(e, "u3", 500)
+	walk(e, "u2", 500)
+	e.as("u1").mustInvoke(nil, "leaveTeam", "u1")
+	e.as("u1").mustInvoke(nil, "joinTeam", "u1", "t2")
+	walk(e, "u1", 1000)
+
+	e.advance(time.Hour)
+	var settled Challenge
+	e.as("u2").mustInvoke(&settled, "settleChallenge", challengeId)
+	if len(settled.WinningTeamIds) != 2 || len(settled.Payouts) != 4 {
+		t.Fatalf("settled challenge is %+v", settled)
+	}
+	for _, payout := range settled.Payouts {
+		if payout.Amount != 10 {
+			t.Fatalf("payouts are %+v", settled.Payouts)
+		}
+	}
+	e.checkBalance("u1", 10+20)
+	e.checkBalance("u2", 5+10)
+	e.checkBalance("u3", 5+10)
+
+	var supply Supply
+	e.as("u1").mustInvoke(&supply, "getSupply")
+	if supply.Minted != 20+40 {
+		t.Fatalf("supply is %+v", supply)
+	}
+}
+
+func TestChallengeWithoutStepsPaysNothing(t *testing.T) {
+	e, challengeId := newChallengeEnv(t, "40")
+	e.createUser("u1", 0)
+	e.as("u1").mustInvoke(nil, "createTeam", "u1", "t1", "Walkers")
+	e.advance(2 * time.Hour)
+
+	var settled Challenge
+	e.as("u1").mustInvoke(&settled, "settleChallenge", challengeId)
+	if settled.State != CHALLENGE_SETTLED || len(settled.WinningTeamIds) != 0 || len(settled.Payouts) != 0 {
+		t.Fatalf("settled challenge is %+v", settled)
+	}
+	e.as("u1").mustFail(ERR_CHALLENGE_NOT_FOUND, "settleChallenge", "nope")
+}
@@ -0,0 +1,90 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Fabric does not let a transaction read its own writes: GetState returns the value from before the
+// transaction even after a PutState in it. Init and Invoke wrap the stub in a txStub, which remembers the
+// writes of the transaction and returns them from GetState, so that a record written twice in one
+// transaction, like the coin batch and supply record of a transaction minting to a member twice, adds
+// up instead of the second write replacing the first. Range queries still return the ledger state;
+// callers that need their own writes in a range merge them in with pendingWrites.
+
+// txStub is a stub that reads the writes of its own transaction
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes map[string][]byte
+}
+
+// newTxStub wraps the stub of a transaction
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{stub, map[string][]byte{}}
+}
+
+// GetState returns the value written earlier in the transaction, or else the ledger value
+func (s *txStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+// PutState writes the value and remembers it for the rest of the transaction
+func (s *txStub) PutState(key string, value []byte) error {
+	err := s.ChaincodeStubInterface.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	s.writes[key] = value
+	return nil
+}
+
+// DelState deletes the key and remembers it as deleted for the rest of the transaction
+func (s *txStub) DelState(key string) error {
+	err := s.ChaincodeStubInterface.DelState(key)
+	if err != nil {
+		return err
+	}
+	s.writes[key] = nil
+	return nil
+}
+
+// pendingWrites returns the keys starting with prefix written earlier in the transaction, in key order,
+// with their values, nil for deleted keys. A stub that is not a txStub has none.
+func pendingWrites(stub shim.ChaincodeStubInterface, prefix string) ([]string, map[string][]byte) {
+	s, ok := stub.(*txStub)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for key := range s.writes {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, s.writes
+}
@@ -1,6 +1,6 @@
 'use strict';
 const TRANSACTION_TIMEOUT = 120000;
-export default async function (userId, clientObject, chaincodeId, chaincodeVersion, fcn, args) {
+export default async function (userId, clientObject, chaincodeId, chaincodeVersion, fcn, args, transient) {
   var Transaction = require('fabric-client/lib/TransactionID.js');
   //var user_from_store = await clientObject._client.getUserContext(userId, true);
   var getUser = async function (clientObject, userId, count) {
@@ -32,6 +32,13 @@ export default async function (userId, clientObject, chaincodeId, chaincodeVersi
       chainId: clientObject._channelName,
       txId: txId
     };
+    if(transient) {
+      //transient data reaches the chaincode but is not written to the ledger
+      request.transientMap = {};
+      Object.keys(transient).forEach(key => {
+        request.transientMap[key] = Buffer.from(transient[key]);
+      });
+    }
     const results = await clientObject._channel.sendTransactionProposal(request);
     proposalResponses = results[0];
     proposal = results[1];
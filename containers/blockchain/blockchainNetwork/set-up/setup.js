@@ -134,8 +134,9 @@ function getAdminOrgs() {
   }
   // Instantiate chaincode on all peers
   // Instantiating the chaincode on a single peer should be enough (for now)
+  // The instantiate args are the organizer MSP ids and identities allowed to call admin functions
   try {
-    await clients[0].instantiate(config.chaincodeId, config.chaincodeVersion, config.chaincodePath, '{"Args":[""]}');
+    await clients[0].instantiate(config.chaincodeId, config.chaincodeVersion, config.chaincodePath, ...(config.chaincodeAdmins || []));
     console.log('Successfully instantiated chaincode on all peers.');
   } catch(e) {
     console.log('Fatal error instantiating chaincode on some(all) peers!');
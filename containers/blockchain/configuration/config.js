@@ -9,6 +9,8 @@ const config = {
   chaincodeId: 'bcfit',
   chaincodeVersion: '1',
   chaincodePath: 'bcfit',
+  // organizer MSP ids, or MSP id/certificate subject of single identities, allowed to call admin functions
+  chaincodeAdmins: process.env.CHAINCODE_ADMINS ? process.env.CHAINCODE_ADMINS.split(',') : ['FitCoinOrgMSP/admin'],
   rabbitmq: 'amqp://rabbitmq:5672?heartbeat=60',
   redisHost: 'redis-server',
   redisPort: 7000,